@@ -0,0 +1,50 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import "unsafe"
+
+// errOpen is returned by the AEAD implementations of this
+// package when a ciphertext cannot be decrypted and verified.
+const errOpen errorType = "sio: message authentication failed"
+
+// sliceForAppend takes a slice and a requested number of bytes. It
+// returns a slice with the contents of the given slice followed by
+// that many bytes and a second slice that aliases into it and
+// contains only the extra bytes. If the original slice has
+// sufficient capacity then no allocation is performed.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
+
+// anyOverlap reports whether x and y share memory at any
+// (not necessarily corresponding) index.
+func anyOverlap(x, y []byte) bool {
+	return len(x) > 0 && len(y) > 0 &&
+		uintptr(unsafe.Pointer(&x[0])) <= uintptr(unsafe.Pointer(&y[len(y)-1])) &&
+		uintptr(unsafe.Pointer(&y[0])) <= uintptr(unsafe.Pointer(&x[len(x)-1]))
+}
+
+// inexactOverlap reports whether x and y share memory at any
+// non-corresponding index. The memory beyond the slice length
+// is ignored.
+//
+// The AEAD implementations of this package use inexactOverlap
+// to reject dst and src buffers that overlap in a way that
+// cannot be processed in-place - just like the crypto/cipher
+// AEADs do.
+func inexactOverlap(x, y []byte) bool {
+	if len(x) == 0 || len(y) == 0 || &x[0] == &y[0] {
+		return false
+	}
+	return anyOverlap(x, y)
+}
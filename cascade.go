@@ -0,0 +1,147 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// cascade implements the cipher.AEAD interface by
+// encrypting the plaintext with XChaCha20-Poly1305 (inner)
+// and then encrypting the resulting ciphertext again with
+// AES-256-GCM (outer). Both AEADs use independent keys.
+//
+// The nonce of a cascade is 24 bytes long. The inner AEAD
+// uses the entire nonce. The outer AEAD only uses the trailing
+// 12 bytes of the nonce - such that it sees the 4 byte fragment
+// counter of a Stream. To not rely on 8 random nonce bytes,
+// the AES-256-GCM key is derived from the outer key and the
+// leading 12 bytes of the nonce using HKDF-SHA-256. Hence, each
+// data stream is encrypted with its own AES-256-GCM key.
+// Both AEADs authenticate the same associated data.
+type cascade struct {
+	inner    cipher.AEAD
+	outerKey []byte
+
+	lock        sync.Mutex
+	outerPrefix []byte      // The leading nonce bytes of the cached outer AEAD
+	outer       cipher.AEAD // The cached outer AEAD
+}
+
+const (
+	cascadeOuterNonceSize = 12
+	cascadeOuterOverhead  = 16
+)
+
+func (c *cascade) NonceSize() int { return c.inner.NonceSize() }
+
+func (c *cascade) Overhead() int { return c.inner.Overhead() + cascadeOuterOverhead }
+
+func (c *cascade) Seal(dst, nonce, plaintext, associatedData []byte) []byte {
+	if len(nonce) != c.NonceSize() {
+		panic("sio: incorrect nonce length given to cascade")
+	}
+	outer, outerNonce := c.outerAEAD(nonce)
+
+	// Allocate the entire output at once such that the
+	// outer AEAD can encrypt the inner ciphertext in-place.
+	ret, out := sliceForAppend(dst, len(plaintext)+c.Overhead())
+	if inexactOverlap(out, plaintext) {
+		panic("sio: invalid buffer overlap")
+	}
+	ciphertext := c.inner.Seal(out[:0], nonce, plaintext, associatedData)
+	outer.Seal(ciphertext[:0], outerNonce, ciphertext, associatedData)
+	return ret
+}
+
+func (c *cascade) Open(dst, nonce, ciphertext, associatedData []byte) ([]byte, error) {
+	if len(nonce) != c.NonceSize() {
+		panic("sio: incorrect nonce length given to cascade")
+	}
+	if len(ciphertext) < c.Overhead() {
+		return nil, errOpen
+	}
+	outer, outerNonce := c.outerAEAD(nonce)
+
+	ret, out := sliceForAppend(dst, len(ciphertext)-c.Overhead())
+	if inexactOverlap(out, ciphertext) {
+		panic("sio: invalid buffer overlap")
+	}
+
+	// If dst does not overlap with the ciphertext we must not modify
+	// the ciphertext. Otherwise, we can decrypt everything in-place.
+	buffer := out[:0]
+	if !anyOverlap(out, ciphertext) {
+		buffer = make([]byte, 0, len(ciphertext)-outer.Overhead())
+	}
+	innerCiphertext, err := outer.Open(buffer, outerNonce, ciphertext, associatedData)
+	if err != nil {
+		return nil, errOpen
+	}
+	if _, err = c.inner.Open(out[:0], nonce, innerCiphertext, associatedData); err != nil {
+		clear(innerCiphertext)
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+// outerAEAD returns the outer AEAD for the nonce and the
+// trailing nonce bytes that must be passed to it.
+//
+// All fragments of a data stream share the same leading nonce
+// bytes. Therefore, outerAEAD caches the most recently derived
+// AEAD instead of deriving a new key for every fragment.
+func (c *cascade) outerAEAD(nonce []byte) (cipher.AEAD, []byte) {
+	prefix, outerNonce := nonce[:len(nonce)-cascadeOuterNonceSize], nonce[len(nonce)-cascadeOuterNonceSize:]
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.outer != nil && bytes.Equal(c.outerPrefix, prefix) {
+		return c.outer, outerNonce
+	}
+	outer, err := newCascadeOuterAEAD(c.outerKey, prefix)
+	if err != nil {
+		panic(err) // The outer key is always 32 bytes long
+	}
+	c.outer, c.outerPrefix = outer, append(c.outerPrefix[:0], prefix...)
+	return outer, outerNonce
+}
+
+// newCascadeOuterAEAD returns the AES-256-GCM AEAD for the
+// outer key and the leading nonce bytes.
+func newCascadeOuterAEAD(outerKey, prefix []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, outerKey, prefix, "sio: XChaCha20-Poly1305/AES-256-GCM cascade: AES-256-GCM nonce key", 256/8)
+	if err != nil {
+		return nil, err
+	}
+	return newAESGCM(key)
+}
+
+// newXChaCha20Poly1305AESGCM returns a cascade of XChaCha20-Poly1305
+// (inner) and AES-256-GCM (outer). Both AEAD keys are derived from the
+// 32 byte secret key using HKDF-SHA-256.
+func newXChaCha20Poly1305AESGCM(key []byte) (cipher.AEAD, error) {
+	innerKey, err := hkdf.Key(sha256.New, key, nil, "sio: XChaCha20-Poly1305/AES-256-GCM cascade: XChaCha20-Poly1305", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	outerKey, err := hkdf.Key(sha256.New, key, nil, "sio: XChaCha20-Poly1305/AES-256-GCM cascade: AES-256-GCM", 256/8)
+	if err != nil {
+		return nil, err
+	}
+
+	inner, err := chacha20poly1305.NewX(innerKey)
+	if err != nil {
+		return nil, err
+	}
+	return &cascade{inner: inner, outerKey: outerKey}, nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/sha256"
+	mrand "math/rand"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestCascade(t *testing.T) {
+	for i := 0; i < 64; i++ {
+		key, nonce := random(32), random(chacha20poly1305.NonceSizeX)
+		plaintext, associatedData := randomN(1024), randomN(64)
+
+		c, err := newXChaCha20Poly1305AESGCM(key)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create cascade: %v", i, err)
+		}
+		ciphertext := c.Seal(nil, nonce, plaintext, associatedData)
+		if want := referenceCascade(key, nonce, plaintext, associatedData); !bytes.Equal(ciphertext, want) {
+			t.Fatalf("Test %d: ciphertext does not match reference implementation", i)
+		}
+
+		// Seal and Open must work in-place.
+		buffer := make([]byte, len(plaintext), len(plaintext)+c.Overhead())
+		copy(buffer, plaintext)
+		if sealed := c.Seal(buffer[:0], nonce, buffer, associatedData); !bytes.Equal(sealed, ciphertext) {
+			t.Fatalf("Test %d: in-place Seal does not match ciphertext", i)
+		}
+		opened, err := c.Open(buffer[:0], nonce, buffer[:len(ciphertext)], associatedData)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to open ciphertext in-place: %v", i, err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Test %d: plaintext does not match original plaintext", i)
+		}
+
+		// Open must not modify the ciphertext when not decrypting in-place.
+		original := bytes.Clone(ciphertext)
+		if _, err = c.Open(nil, nonce, ciphertext, associatedData); err != nil {
+			t.Fatalf("Test %d: Failed to open ciphertext: %v", i, err)
+		}
+		if !bytes.Equal(ciphertext, original) {
+			t.Fatalf("Test %d: Open modified the ciphertext", i)
+		}
+
+		ciphertext[mrand.Intn(len(ciphertext))] ^= 1
+		if _, err = c.Open(nil, nonce, ciphertext, associatedData); err == nil {
+			t.Fatalf("Test %d: modified ciphertext is authentic", i)
+		}
+	}
+}
+
+func TestCascadeOverhead(t *testing.T) {
+	stream, err := XChaCha20Poly1305_AES_256_GCM.Stream(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("Failed to create Stream: %v", err)
+	}
+	if n := stream.NonceSize(); n != 20 {
+		t.Fatalf("Invalid nonce size: got %d - want %d", n, 20)
+	}
+	if overhead := stream.Overhead(BufSize + 1); overhead != 2*(16+16) {
+		t.Fatalf("Invalid overhead: got %d - want %d", overhead, 2*(16+16))
+	}
+}
+
+func TestCascadeOuterKey(t *testing.T) {
+	c, err := newXChaCha20Poly1305AESGCM(random(32))
+	if err != nil {
+		t.Fatalf("Failed to create cascade: %v", err)
+	}
+
+	// Two nonces that only differ in the leading bytes - which the
+	// outer AES-256-GCM does not see - must produce independent
+	// outer keystreams.
+	nonce := random(chacha20poly1305.NonceSizeX)
+	otherNonce := bytes.Clone(nonce)
+	otherNonce[0] ^= 1
+
+	outer, outerNonce := c.(*cascade).outerAEAD(nonce)
+	otherOuter, otherOuterNonce := c.(*cascade).outerAEAD(otherNonce)
+	if !bytes.Equal(outerNonce, otherOuterNonce) {
+		t.Fatal("The trailing nonce bytes do not match")
+	}
+	zeros := make([]byte, 64)
+	if bytes.Equal(outer.Seal(nil, outerNonce, zeros, nil), otherOuter.Seal(nil, otherOuterNonce, zeros, nil)) {
+		t.Fatal("The outer keystreams of different nonces are equal")
+	}
+}
+
+func TestCascadeOverlap(t *testing.T) {
+	c, err := newXChaCha20Poly1305AESGCM(random(32))
+	if err != nil {
+		t.Fatalf("Failed to create cascade: %v", err)
+	}
+	nonce := random(c.NonceSize())
+	buffer := make([]byte, 1+64+c.Overhead())
+
+	defer func() {
+		if err := recover(); err != "sio: invalid buffer overlap" {
+			t.Fatalf("Seal should panic for inexactly overlapping buffers: got %v", err)
+		}
+	}()
+	c.Seal(buffer[1:1], nonce, buffer[:64], nil)
+}
+
+// referenceCascade computes the XChaCha20-Poly1305 / AES-256-GCM
+// cascade ciphertext by applying both AEADs one after another.
+func referenceCascade(key, nonce, plaintext, associatedData []byte) []byte {
+	innerKey, _ := hkdf.Key(sha256.New, key, nil, "sio: XChaCha20-Poly1305/AES-256-GCM cascade: XChaCha20-Poly1305", 32)
+	outerKey, _ := hkdf.Key(sha256.New, key, nil, "sio: XChaCha20-Poly1305/AES-256-GCM cascade: AES-256-GCM", 32)
+	outerKey, _ = hkdf.Key(sha256.New, outerKey, nonce[:12], "sio: XChaCha20-Poly1305/AES-256-GCM cascade: AES-256-GCM nonce key", 32)
+
+	inner, err := chacha20poly1305.NewX(innerKey)
+	if err != nil {
+		panic(err)
+	}
+	outer, err := newAESGCM(outerKey)
+	if err != nil {
+		panic(err)
+	}
+	ciphertext := inner.Seal(nil, nonce, plaintext, associatedData)
+	return outer.Seal(nil, nonce[12:], ciphertext, associatedData)
+}
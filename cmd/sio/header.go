@@ -0,0 +1,163 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/secure-io/sio-go"
+)
+
+// headerMagic is the first part of every header. Its
+// last byte is the version of the header format.
+const headerMagic = "SIO\x01"
+
+// The key derivation functions used to derive
+// the file key from the key resp. passphrase.
+const (
+	kdfHKDF     byte = 1 // HKDF-SHA-256 for secret keys
+	kdfArgon2id byte = 2 // Argon2id + HKDF-SHA-256 for passphrases
+)
+
+// saltSize is the size of the random per-file salt.
+const saltSize = 32
+
+// Limits for the Argon2id parameters accepted when decrypting.
+// They prevent crafted headers from consuming arbitrary
+// amounts of memory and CPU time.
+const (
+	maxArgon2Time    = 16
+	maxArgon2Memory  = 1 << 20 // 1 GiB in KiB
+	maxArgon2Threads = 64
+)
+
+// header is the self-describing header written in
+// front of every data stream encrypted by sio encrypt.
+// The encoded header is the associated data of the data
+// stream such that it cannot be modified undetected.
+//
+// The binary encoding of a header is:
+//
+//	magic     [4]byte  "SIO\x01"
+//	algorithm byte     see: sio.Algorithm.ID
+//	bufSize   uint32   big endian
+//	kdf       byte     1 = HKDF, 2 = Argon2id
+//	argon2id  [9]byte  time (uint32), memory (uint32), threads (uint8) - only if kdf = 2
+//	salt      [32]byte
+//	nonce     []byte   sio.Algorithm.StreamNonceSize bytes
+type header struct {
+	Algorithm sio.Algorithm
+	BufSize   int
+
+	KDF           byte
+	Argon2Time    uint32
+	Argon2Memory  uint32 // in KiB
+	Argon2Threads uint8
+
+	Salt  [saltSize]byte
+	Nonce []byte
+}
+
+// MarshalBinary returns the binary encoding of the header.
+func (h *header) MarshalBinary() ([]byte, error) {
+	id := h.Algorithm.ID()
+	if id == 0 {
+		return nil, sio.UnknownAlgorithmError{Name: h.Algorithm.String()}
+	}
+	if h.BufSize <= 0 || h.BufSize > sio.MaxBufSize {
+		return nil, errors.New("sio: invalid buffer size " + strconv.Itoa(h.BufSize))
+	}
+	if len(h.Nonce) != h.Algorithm.StreamNonceSize() {
+		return nil, errors.New("sio: invalid nonce size " + strconv.Itoa(len(h.Nonce)))
+	}
+
+	b := make([]byte, 0, len(headerMagic)+1+4+1+9+saltSize+len(h.Nonce))
+	b = append(b, headerMagic...)
+	b = append(b, id)
+	b = binary.BigEndian.AppendUint32(b, uint32(h.BufSize))
+	b = append(b, h.KDF)
+	switch h.KDF {
+	case kdfHKDF:
+	case kdfArgon2id:
+		b = binary.BigEndian.AppendUint32(b, h.Argon2Time)
+		b = binary.BigEndian.AppendUint32(b, h.Argon2Memory)
+		b = append(b, h.Argon2Threads)
+	default:
+		return nil, errors.New("sio: invalid key derivation function " + strconv.Itoa(int(h.KDF)))
+	}
+	b = append(b, h.Salt[:]...)
+	b = append(b, h.Nonce...)
+	return b, nil
+}
+
+// readHeader reads and parses a header from r. It returns
+// the header and its binary encoding.
+func readHeader(r io.Reader) (*header, []byte, error) {
+	raw := make([]byte, len(headerMagic)+1+4+1)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, nil, errHeader(err)
+	}
+	if string(raw[:len(headerMagic)]) != headerMagic {
+		return nil, nil, errors.New("sio: invalid header: data has not been encrypted by sio encrypt or uses an unsupported format")
+	}
+
+	var (
+		h   header
+		err error
+	)
+	if h.Algorithm, err = sio.AlgorithmFromID(raw[4]); err != nil {
+		return nil, nil, err
+	}
+	h.BufSize = int(binary.BigEndian.Uint32(raw[5:]))
+	if h.BufSize <= 0 || h.BufSize > sio.MaxBufSize {
+		return nil, nil, errors.New("sio: invalid header: invalid buffer size " + strconv.Itoa(h.BufSize))
+	}
+
+	h.KDF = raw[9]
+	switch h.KDF {
+	case kdfHKDF:
+	case kdfArgon2id:
+		params := make([]byte, 9)
+		if _, err = io.ReadFull(r, params); err != nil {
+			return nil, nil, errHeader(err)
+		}
+		raw = append(raw, params...)
+
+		h.Argon2Time = binary.BigEndian.Uint32(params[0:])
+		h.Argon2Memory = binary.BigEndian.Uint32(params[4:])
+		h.Argon2Threads = params[8]
+		if h.Argon2Time == 0 || h.Argon2Time > maxArgon2Time {
+			return nil, nil, errors.New("sio: invalid header: invalid Argon2id time parameter " + strconv.Itoa(int(h.Argon2Time)))
+		}
+		if h.Argon2Memory == 0 || h.Argon2Memory > maxArgon2Memory {
+			return nil, nil, errors.New("sio: invalid header: invalid Argon2id memory parameter " + strconv.Itoa(int(h.Argon2Memory)))
+		}
+		if h.Argon2Threads == 0 || h.Argon2Threads > maxArgon2Threads {
+			return nil, nil, errors.New("sio: invalid header: invalid Argon2id threads parameter " + strconv.Itoa(int(h.Argon2Threads)))
+		}
+	default:
+		return nil, nil, errors.New("sio: invalid header: invalid key derivation function " + strconv.Itoa(int(h.KDF)))
+	}
+
+	h.Nonce = make([]byte, h.Algorithm.StreamNonceSize())
+	suffix := make([]byte, saltSize+len(h.Nonce))
+	if _, err = io.ReadFull(r, suffix); err != nil {
+		return nil, nil, errHeader(err)
+	}
+	raw = append(raw, suffix...)
+	copy(h.Salt[:], suffix)
+	copy(h.Nonce, suffix[saltSize:])
+	return &h, raw, nil
+}
+
+func errHeader(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("sio: invalid header: data is too short")
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+var headerTests = []header{
+	{Algorithm: sio.AES_128_GCM, BufSize: sio.BufSize, KDF: kdfHKDF, Nonce: make([]byte, 8)},
+	{Algorithm: sio.XChaCha20Poly1305, BufSize: 1, KDF: kdfHKDF, Nonce: bytes.Repeat([]byte{1}, 20)},
+	{Algorithm: sio.AES_256_GCM, BufSize: sio.MaxBufSize, KDF: kdfArgon2id, Argon2Time: 1, Argon2Memory: 64, Argon2Threads: 1, Nonce: make([]byte, 8)},
+}
+
+func TestHeader(t *testing.T) {
+	for i, test := range headerTests {
+		test.Salt[0] = byte(i)
+		b, err := test.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Test %d: Failed to encode header: %v", i, err)
+		}
+		h, raw, err := readHeader(bytes.NewReader(append(b, "ciphertext"...)))
+		if err != nil {
+			t.Fatalf("Test %d: Failed to read header: %v", i, err)
+		}
+		if !bytes.Equal(raw, b) {
+			t.Fatalf("Test %d: binary header mismatch", i)
+		}
+		if h.Algorithm != test.Algorithm || h.BufSize != test.BufSize || h.KDF != test.KDF || h.Salt != test.Salt || !bytes.Equal(h.Nonce, test.Nonce) {
+			t.Fatalf("Test %d: header mismatch: got %+v - want %+v", i, h, test)
+		}
+		if h.Argon2Time != test.Argon2Time || h.Argon2Memory != test.Argon2Memory || h.Argon2Threads != test.Argon2Threads {
+			t.Fatalf("Test %d: Argon2id parameter mismatch: got %+v - want %+v", i, h, test)
+		}
+
+		for n := 0; n < len(b); n++ {
+			if _, _, err = readHeader(bytes.NewReader(b[:n])); err == nil {
+				t.Fatalf("Test %d: truncated header has been accepted", i)
+			}
+		}
+	}
+}
+
+func TestHeaderLimits(t *testing.T) {
+	h := header{Algorithm: sio.AES_128_GCM, BufSize: sio.BufSize, KDF: kdfArgon2id, Argon2Time: 1, Argon2Memory: maxArgon2Memory + 1, Argon2Threads: 1, Nonce: make([]byte, 8)}
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to encode header: %v", err)
+	}
+	if _, _, err = readHeader(bytes.NewReader(b)); err == nil {
+		t.Fatal("Header exceeding the Argon2id memory limit has been accepted")
+	}
+
+	b[0] = 'X'
+	if _, _, err = readHeader(bytes.NewReader(b)); err == nil {
+		t.Fatal("Header with invalid magic has been accepted")
+	}
+}
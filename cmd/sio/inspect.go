@@ -0,0 +1,330 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/secure-io/sio-go"
+)
+
+const inspectUsage = `Usage: sio inspect [OPTIONS] [FILE]
+
+Prints the header and the fragment structure of encrypted data
+read from FILE or standard input. Inspect does not require a key
+and does not authenticate the data. Use 'sio verify' to check
+whether the data is authentic.
+
+Options:
+  -raw           Inspect data without a header. Requires -a.
+  -a ALGORITHM   The AEAD algorithm. Only with -raw.
+  -b BUFSIZE     The buffer size in bytes. Only with -raw.
+                 (default: 16384)
+`
+
+func inspectCmd(args []string) error {
+	var (
+		algorithm string
+		bufSize   int
+		raw       bool
+	)
+	cli := newFlagSet("inspect", inspectUsage, nil)
+	cli.StringVar(&algorithm, "a", "", "")
+	cli.IntVar(&bufSize, "b", sio.BufSize, "")
+	cli.BoolVar(&raw, "raw", false, "")
+	if err := parseFlagsAndArgs(cli, args, 1); err != nil {
+		return err
+	}
+
+	in, size, err := openInput(cli.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var (
+		r  = bufio.NewReader(in)
+		h  *header
+		a  sio.Algorithm
+		hn int
+	)
+	if raw {
+		if algorithm == "" {
+			return errors.New("sio: -raw requires -a")
+		}
+		if a, err = sio.ParseAlgorithm(algorithm); err != nil {
+			return err
+		}
+	} else {
+		if algorithm != "" || bufSize != sio.BufSize {
+			return errors.New("sio: -a and -b require -raw")
+		}
+		var encHeader []byte
+		if h, encHeader, err = readHeader(r); err != nil {
+			return err
+		}
+		a, bufSize, hn = h.Algorithm, h.BufSize, len(encHeader)
+	}
+
+	if size < 0 { // Standard input - count the bytes
+		n, err := io.Copy(io.Discard, r)
+		if err != nil {
+			return err
+		}
+		size = int64(hn) + n
+	}
+	l, err := newLayout(a, bufSize, size-int64(hn))
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if h != nil {
+		fmt.Fprintf(w, "Header:          %d bytes\n", hn)
+		switch h.KDF {
+		case kdfHKDF:
+			fmt.Fprintf(w, "Key derivation:  HKDF-SHA-256\n")
+		case kdfArgon2id:
+			fmt.Fprintf(w, "Key derivation:  Argon2id (time=%d, memory=%d KiB, threads=%d) + HKDF-SHA-256\n", h.Argon2Time, h.Argon2Memory, h.Argon2Threads)
+		}
+		fmt.Fprintf(w, "Salt:            %s\n", hex.EncodeToString(h.Salt[:]))
+		fmt.Fprintf(w, "Nonce:           %s\n", hex.EncodeToString(h.Nonce))
+	} else {
+		fmt.Fprintf(w, "Header:          none\n")
+	}
+	fmt.Fprintf(w, "Algorithm:       %s\n", a)
+	fmt.Fprintf(w, "Buffer size:     %d bytes\n", bufSize)
+	fmt.Fprintf(w, "Ciphertext:      %d bytes\n", l.CiphertextSize)
+	fmt.Fprintf(w, "Fragments:       %d\n", l.Fragments)
+	if l.Err != nil {
+		fmt.Fprintf(w, "Final fragment:  invalid: %v\n", l.Err)
+	} else {
+		fmt.Fprintf(w, "Final fragment:  %d bytes (%d bytes plaintext)\n", l.FinalSize, l.FinalSize-int64(a.Overhead()))
+		fmt.Fprintf(w, "Plaintext:       %d bytes\n", l.PlaintextSize)
+		fmt.Fprintf(w, "Overhead:        %d bytes\n", l.Overhead)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if l.Err != nil {
+		return errors.New("sio: inspect: data is truncated or corrupted")
+	}
+	return nil
+}
+
+const verifyUsage = `Usage: sio verify [OPTIONS] [FILE]
+
+Verifies that the encrypted data read from FILE or standard input
+is authentic. Verify authenticates every fragment without writing
+any plaintext. If a fragment is not authentic, verify reports the
+first such fragment and its byte offset and exits with a non-zero
+exit code.
+
+Options:
+  -key-file FILE         Read the key from FILE. The file contains
+                         the raw or hex-encoded key.
+  -key-env VAR           Read the hex-encoded key from the environment
+                         variable VAR.
+  -passphrase-file FILE  Read the passphrase from the first line of FILE.
+  -passphrase-env VAR    Read the passphrase from the environment
+                         variable VAR.
+
+  -raw                   Verify data without a header. Requires -a,
+                         -nonce and a key of the algorithm's key size.
+  -a ALGORITHM           The AEAD algorithm. Only with -raw.
+  -b BUFSIZE             The buffer size in bytes. Only with -raw.
+                         (default: 16384)
+  -nonce HEX             The hex-encoded nonce. Only with -raw.
+  -ad HEX                The hex-encoded associated data. Only with -raw.
+`
+
+func verifyCmd(args []string) error {
+	var (
+		keys      keyFlags
+		algorithm string
+		bufSize   int
+		raw       bool
+		rawNonce  string
+		rawAD     string
+	)
+	cli := newFlagSet("verify", verifyUsage, &keys)
+	cli.StringVar(&algorithm, "a", "", "")
+	cli.IntVar(&bufSize, "b", sio.BufSize, "")
+	cli.BoolVar(&raw, "raw", false, "")
+	cli.StringVar(&rawNonce, "nonce", "", "")
+	cli.StringVar(&rawAD, "ad", "", "")
+	if err := parseFlagsAndArgs(cli, args, 1); err != nil {
+		return err
+	}
+
+	secret, err := keys.Load()
+	if err != nil {
+		return err
+	}
+	in, _, err := openInput(cli.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var (
+		r              = bufio.NewReader(in)
+		stream         *sio.Stream
+		nonce          []byte
+		associatedData []byte
+		headerSize     int
+	)
+	if raw {
+		stream, nonce, associatedData, err = rawStream(secret, algorithm, bufSize, rawNonce, rawAD, keys.Passphrase())
+	} else {
+		if algorithm != "" || bufSize != sio.BufSize || rawNonce != "" || rawAD != "" {
+			return errors.New("sio: -a, -b, -nonce and -ad require -raw")
+		}
+		stream, nonce, associatedData, headerSize, err = openStream(r, secret, keys.Passphrase())
+	}
+	if err != nil {
+		return err
+	}
+
+	fragments, size, err := verify(r, stream, nonce, associatedData, headerSize)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("OK: %d fragments, %d bytes plaintext\n", fragments, size)
+	return nil
+}
+
+// verify authenticates the data stream read from r. It returns
+// the number of fragments and the size of the plaintext. If a
+// fragment is not authentic, it returns a *fragmentError.
+func verify(r io.Reader, stream *sio.Stream, nonce, associatedData []byte, headerSize int) (int64, int64, error) {
+	var plaintext counter
+	_, err := stream.DecryptReader(r, nonce, associatedData).WriteTo(&plaintext)
+
+	bufSize := int64(stream.BufSize())
+	fragmentSize := bufSize + stream.Overhead(bufSize)
+	if err == sio.NotAuthentic {
+		fragment := plaintext.N / bufSize
+		return 0, 0, &fragmentError{
+			Fragment: fragment,
+			Offset:   int64(headerSize) + fragment*fragmentSize,
+		}
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	fragments := (plaintext.N + bufSize - 1) / bufSize
+	if fragments == 0 {
+		fragments = 1
+	}
+	return fragments, plaintext.N, nil
+}
+
+// fragmentError describes the first fragment
+// that is not authentic.
+type fragmentError struct {
+	Fragment int64 // The 0-based index of the fragment
+	Offset   int64 // The byte offset of the fragment
+}
+
+func (e *fragmentError) Error() string {
+	msg := fmt.Sprintf("sio: verify: fragment %d at byte offset %d is not authentic, truncated or followed by unexpected data", e.Fragment, e.Offset)
+	if e.Fragment == 0 {
+		msg += " - the data may be corrupted or the key is wrong"
+	}
+	return msg
+}
+
+// layout describes the fragment structure of
+// an encrypted data stream.
+type layout struct {
+	CiphertextSize int64
+	Fragments      int64
+	FinalSize      int64 // Size of the final fragment including the overhead
+	PlaintextSize  int64
+	Overhead       int64
+
+	// Err describes why the size of the data stream
+	// is invalid, if it is.
+	Err error
+}
+
+// newLayout computes the fragment structure of a data stream of
+// the given size encrypted with the algorithm and buffer size.
+func newLayout(a sio.Algorithm, bufSize int, size int64) (layout, error) {
+	if a.ID() == 0 {
+		return layout{}, sio.UnknownAlgorithmError{Name: a.String()}
+	}
+	if bufSize <= 0 || bufSize > sio.MaxBufSize {
+		return layout{}, errors.New("sio: invalid buffer size " + strconv.Itoa(bufSize))
+	}
+
+	l := layout{CiphertextSize: size}
+	overhead := int64(a.Overhead())
+	fragmentSize := int64(bufSize) + overhead
+	if size < overhead {
+		l.Fragments = 0
+		if size > 0 {
+			l.Fragments = 1
+		}
+		l.Err = fmt.Errorf("data stream is %d bytes - shorter than the %d byte authentication tag", size, overhead)
+		return l, nil
+	}
+
+	l.Fragments = (size + fragmentSize - 1) / fragmentSize
+	l.FinalSize = size - (l.Fragments-1)*fragmentSize
+	if l.FinalSize < overhead {
+		l.Err = fmt.Errorf("final fragment is %d bytes - shorter than the %d byte authentication tag", l.FinalSize, overhead)
+		return l, nil
+	}
+	if l.Fragments > math.MaxUint32 {
+		l.Err = fmt.Errorf("data stream consists of more than %d fragments", uint32(math.MaxUint32))
+		return l, nil
+	}
+
+	// Each fragment - including the final one - carries
+	// one authentication tag.
+	l.Overhead = l.Fragments * overhead
+	l.PlaintextSize = size - l.Overhead
+	return l, nil
+}
+
+// openInput opens the file or returns standard input if the
+// path is empty. It returns the size of the file or -1 if
+// the size is not known.
+func openInput(path string) (io.ReadCloser, int64, error) {
+	if path == "" {
+		return io.NopCloser(os.Stdin), -1, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if !stat.Mode().IsRegular() {
+		return f, -1, nil
+	}
+	return f, stat.Size(), nil
+}
+
+// counter is an io.Writer that discards all
+// data and counts the number of bytes written.
+type counter struct{ N int64 }
+
+func (c *counter) Write(p []byte) (int, error) {
+	c.N += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+func TestLayout(t *testing.T) {
+	const bufSize = 64
+	stream, err := sio.AES_128_GCM.StreamWithBufSize(make([]byte, 16), bufSize)
+	if err != nil {
+		t.Fatalf("Failed to create Stream: %v", err)
+	}
+
+	for size := int64(0); size < 5*bufSize; size++ {
+		ciphertextSize := size + stream.Overhead(size)
+		l, err := newLayout(sio.AES_128_GCM, bufSize, ciphertextSize)
+		if err != nil {
+			t.Fatalf("Size %d: Failed to compute layout: %v", size, err)
+		}
+		if l.Err != nil {
+			t.Fatalf("Size %d: valid data stream is invalid: %v", size, l.Err)
+		}
+		if l.PlaintextSize != size {
+			t.Fatalf("Size %d: got plaintext size %d", size, l.PlaintextSize)
+		}
+		if l.Overhead != stream.Overhead(size) {
+			t.Fatalf("Size %d: got overhead %d - want %d", size, l.Overhead, stream.Overhead(size))
+		}
+		if want := max(1, (size+bufSize-1)/bufSize); l.Fragments != want {
+			t.Fatalf("Size %d: got %d fragments - want %d", size, l.Fragments, want)
+		}
+	}
+
+	for _, size := range []int64{0, 15, bufSize + 16 + 15} {
+		l, err := newLayout(sio.AES_128_GCM, bufSize, size)
+		if err != nil {
+			t.Fatalf("Size %d: Failed to compute layout: %v", size, err)
+		}
+		if l.Err == nil {
+			t.Fatalf("Size %d: invalid data stream is valid", size)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const bufSize = 100
+	key := make([]byte, 32)
+	nonce := make([]byte, sio.AES_256_GCM.StreamNonceSize())
+	stream, err := sio.AES_256_GCM.StreamWithBufSize(key, bufSize)
+	if err != nil {
+		t.Fatalf("Failed to create Stream: %v", err)
+	}
+
+	var ciphertext bytes.Buffer
+	w := stream.EncryptWriter(&ciphertext, nonce, nil)
+	if _, err = w.Write(make([]byte, 5*bufSize+1)); err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	fragments, size, err := verify(bytes.NewReader(ciphertext.Bytes()), stream, nonce, nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+	if fragments != 6 || size != 5*bufSize+1 {
+		t.Fatalf("Got %d fragments and %d bytes - want %d fragments and %d bytes", fragments, size, 6, 5*bufSize+1)
+	}
+
+	fragmentSize := int64(bufSize + 16)
+	for fragment := int64(0); fragment < 6; fragment++ {
+		modified := bytes.Clone(ciphertext.Bytes())
+		modified[fragment*fragmentSize] ^= 1
+
+		_, _, err = verify(bytes.NewReader(modified), stream, nonce, nil, 42)
+		var fErr *fragmentError
+		if !errors.As(err, &fErr) {
+			t.Fatalf("Fragment %d: got error %v - want a fragment error", fragment, err)
+		}
+		if fErr.Fragment != fragment || fErr.Offset != 42+fragment*fragmentSize {
+			t.Fatalf("Fragment %d: got fragment %d at offset %d", fragment, fErr.Fragment, fErr.Offset)
+		}
+	}
+}
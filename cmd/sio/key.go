@@ -0,0 +1,119 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// minKeySize is the minimum size of a secret key in bytes.
+const minKeySize = 16
+
+// Default Argon2id parameters for passphrases.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // 64 MiB in KiB
+	argon2Threads = 4
+)
+
+// keyFlags are the command-line flags for specifying
+// a secret key or passphrase. Exactly one of them must
+// be set.
+type keyFlags struct {
+	KeyFile        string
+	KeyEnv         string
+	PassphraseFile string
+	PassphraseEnv  string
+}
+
+// Passphrase reports whether the flags refer to a passphrase
+// instead of a secret key.
+func (f *keyFlags) Passphrase() bool { return f.PassphraseFile != "" || f.PassphraseEnv != "" }
+
+// Load returns the secret key or passphrase.
+//
+// A key file contains either the raw key or the hex-encoded key.
+// A key environment variable contains the hex-encoded key. A
+// passphrase file contains the passphrase on its first line.
+func (f *keyFlags) Load() ([]byte, error) {
+	var n int
+	for _, flag := range []string{f.KeyFile, f.KeyEnv, f.PassphraseFile, f.PassphraseEnv} {
+		if flag != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, errors.New("sio: exactly one of -key-file, -key-env, -passphrase-file and -passphrase-env must be specified")
+	}
+
+	switch {
+	case f.KeyFile != "":
+		data, err := os.ReadFile(f.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if key, err := hex.DecodeString(string(bytes.TrimSpace(data))); err == nil {
+			data = key
+		}
+		return checkKey(data)
+	case f.KeyEnv != "":
+		value, ok := os.LookupEnv(f.KeyEnv)
+		if !ok {
+			return nil, errors.New("sio: environment variable " + f.KeyEnv + " is not set")
+		}
+		key, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, errors.New("sio: environment variable " + f.KeyEnv + " does not contain a hex-encoded key")
+		}
+		return checkKey(key)
+	case f.PassphraseFile != "":
+		data, err := os.ReadFile(f.PassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+			data = data[:i]
+		}
+		return checkPassphrase(data)
+	default:
+		value, ok := os.LookupEnv(f.PassphraseEnv)
+		if !ok {
+			return nil, errors.New("sio: environment variable " + f.PassphraseEnv + " is not set")
+		}
+		return checkPassphrase([]byte(value))
+	}
+}
+
+func checkKey(key []byte) ([]byte, error) {
+	if len(key) < minKeySize {
+		return nil, errors.New("sio: key is too short: " + strconv.Itoa(len(key)) + " bytes - at least " + strconv.Itoa(minKeySize) + " bytes are required")
+	}
+	return key, nil
+}
+
+func checkPassphrase(passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("sio: passphrase is empty")
+	}
+	return passphrase, nil
+}
+
+// deriveKey derives the key of the data stream from the
+// secret key or passphrase using the key derivation function
+// and salt of the header.
+func deriveKey(h *header, secret []byte) ([]byte, error) {
+	if h.KDF == kdfArgon2id {
+		secret = argon2.IDKey(secret, h.Salt[:], h.Argon2Time, h.Argon2Memory, h.Argon2Threads, 32)
+	}
+	return hkdf.Key(sha256.New, secret, h.Salt[:], "sio: file key for "+h.Algorithm.String(), h.Algorithm.KeySize())
+}
@@ -0,0 +1,382 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+// Command sio encrypts and decrypts data streams.
+//
+// It reads data from standard input and writes the result to
+// standard output. The encrypted data starts with a header that
+// describes the algorithm, buffer size, nonce and key derivation.
+// Therefore, decrypting needs no flags except for the key.
+//
+// The secret key is read from a file (-key-file) or from an
+// environment variable (-key-env). Alternatively, a passphrase
+// can be read from a file (-passphrase-file) or from an environment
+// variable (-passphrase-env). A key derivation function derives
+// the actual encryption key from the key resp. passphrase and a
+// random salt. Hence, every data stream is encrypted with a new
+// key.
+//
+// Data streams produced by applications that use the sio package
+// directly have no header. They can be en/decrypted with -raw by
+// specifying the algorithm, buffer size, nonce and associated data
+// explicitly. Then, the key is used as is.
+//
+// Usage:
+//
+//	sio encrypt [OPTIONS] < PLAINTEXT > CIPHERTEXT
+//	sio decrypt [OPTIONS] < CIPHERTEXT > PLAINTEXT
+//	sio inspect [OPTIONS] [FILE]
+//	sio verify  [OPTIONS] [FILE]
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/secure-io/sio-go"
+	"github.com/secure-io/sio-go/sioutil"
+)
+
+const usage = `Usage: sio COMMAND [OPTIONS]
+
+Commands:
+  encrypt   Encrypt data from standard input to standard output.
+  decrypt   Decrypt data from standard input to standard output.
+  inspect   Print the structure of encrypted data.
+  verify    Verify that encrypted data is authentic.
+
+Run 'sio COMMAND -h' for the options of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "encrypt":
+		err = encryptCmd(args)
+	case "decrypt":
+		err = decryptCmd(args)
+	case "inspect":
+		err = inspectCmd(args)
+	case "verify":
+		err = verifyCmd(args)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "sio: unknown command '%s'\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+const encryptUsage = `Usage: sio encrypt [OPTIONS] < PLAINTEXT > CIPHERTEXT
+
+Encrypts data from standard input and writes the header and
+the encrypted data to standard output.
+
+Options:
+  -key-file FILE         Read the key from FILE. The file contains
+                         the raw or hex-encoded key.
+  -key-env VAR           Read the hex-encoded key from the environment
+                         variable VAR.
+  -passphrase-file FILE  Read the passphrase from the first line of FILE.
+  -passphrase-env VAR    Read the passphrase from the environment
+                         variable VAR.
+
+  -a ALGORITHM           Use the AEAD algorithm ALGORITHM. By default,
+                         the best algorithm for this CPU is selected.
+  -b BUFSIZE             Use a buffer size of BUFSIZE bytes.
+                         (default: 16384)
+
+  -raw                   Don't write a header. Requires -a, -nonce and
+                         a key of the algorithm's key size.
+  -nonce HEX             Use the hex-encoded nonce. Only with -raw.
+  -ad HEX                Use the hex-encoded associated data. Only with -raw.
+`
+
+func encryptCmd(args []string) error {
+	var (
+		keys      keyFlags
+		algorithm string
+		bufSize   int
+		raw       bool
+		rawNonce  string
+		rawAD     string
+	)
+	cli := newFlagSet("encrypt", encryptUsage, &keys)
+	cli.StringVar(&algorithm, "a", "", "")
+	cli.IntVar(&bufSize, "b", sio.BufSize, "")
+	cli.BoolVar(&raw, "raw", false, "")
+	cli.StringVar(&rawNonce, "nonce", "", "")
+	cli.StringVar(&rawAD, "ad", "", "")
+	if err := parseFlags(cli, args); err != nil {
+		return err
+	}
+
+	secret, err := keys.Load()
+	if err != nil {
+		return err
+	}
+	if raw {
+		return encryptRaw(secret, algorithm, bufSize, rawNonce, rawAD, keys.Passphrase())
+	}
+	if rawNonce != "" || rawAD != "" {
+		return errors.New("sio: -nonce and -ad require -raw")
+	}
+
+	h := &header{BufSize: bufSize}
+	if algorithm == "" {
+		selection, err := sioutil.SelectAlgorithm(sioutil.Policy{SecurityLevel: 256})
+		if err != nil {
+			return err
+		}
+		h.Algorithm = selection.Algorithm
+	} else if h.Algorithm, err = sio.ParseAlgorithm(algorithm); err != nil {
+		return err
+	}
+
+	h.KDF = kdfHKDF
+	if keys.Passphrase() {
+		h.KDF, h.Argon2Time, h.Argon2Memory, h.Argon2Threads = kdfArgon2id, argon2Time, argon2Memory, argon2Threads
+	}
+	salt, err := sioutil.Random(saltSize)
+	if err != nil {
+		return err
+	}
+	copy(h.Salt[:], salt)
+	if h.Nonce, err = sioutil.Random(h.Algorithm.StreamNonceSize()); err != nil {
+		return err
+	}
+	encHeader, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	key, err := deriveKey(h, secret)
+	if err != nil {
+		return err
+	}
+	stream, err := h.Algorithm.StreamWithBufSize(key, h.BufSize)
+	if err != nil {
+		return err
+	}
+
+	stdout := bufio.NewWriter(os.Stdout)
+	if _, err = stdout.Write(encHeader); err != nil {
+		return err
+	}
+	return encrypt(stdout, os.Stdin, stream, h.Nonce, encHeader)
+}
+
+const decryptUsage = `Usage: sio decrypt [OPTIONS] < CIPHERTEXT > PLAINTEXT
+
+Decrypts data from standard input and writes the plaintext
+to standard output. The algorithm, buffer size and nonce are
+read from the header.
+
+Decrypt writes plaintext as soon as it has been authenticated.
+If the data has been modified or truncated, decrypt fails with
+a non-zero exit code after having written the plaintext that
+precedes the modification. Therefore, the output must be discarded
+if decrypt fails.
+
+Options:
+  -key-file FILE         Read the key from FILE. The file contains
+                         the raw or hex-encoded key.
+  -key-env VAR           Read the hex-encoded key from the environment
+                         variable VAR.
+  -passphrase-file FILE  Read the passphrase from the first line of FILE.
+  -passphrase-env VAR    Read the passphrase from the environment
+                         variable VAR.
+
+  -raw                   Decrypt data without a header. Requires -a,
+                         -nonce and a key of the algorithm's key size.
+  -a ALGORITHM           The AEAD algorithm. Only with -raw.
+  -b BUFSIZE             The buffer size in bytes. Only with -raw.
+                         (default: 16384)
+  -nonce HEX             The hex-encoded nonce. Only with -raw.
+  -ad HEX                The hex-encoded associated data. Only with -raw.
+`
+
+func decryptCmd(args []string) error {
+	var (
+		keys      keyFlags
+		algorithm string
+		bufSize   int
+		raw       bool
+		rawNonce  string
+		rawAD     string
+	)
+	cli := newFlagSet("decrypt", decryptUsage, &keys)
+	cli.StringVar(&algorithm, "a", "", "")
+	cli.IntVar(&bufSize, "b", sio.BufSize, "")
+	cli.BoolVar(&raw, "raw", false, "")
+	cli.StringVar(&rawNonce, "nonce", "", "")
+	cli.StringVar(&rawAD, "ad", "", "")
+	if err := parseFlags(cli, args); err != nil {
+		return err
+	}
+
+	secret, err := keys.Load()
+	if err != nil {
+		return err
+	}
+	if raw {
+		return decryptRaw(secret, algorithm, bufSize, rawNonce, rawAD, keys.Passphrase())
+	}
+	if algorithm != "" || bufSize != sio.BufSize || rawNonce != "" || rawAD != "" {
+		return errors.New("sio: -a, -b, -nonce and -ad require -raw")
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	stream, nonce, associatedData, _, err := openStream(stdin, secret, keys.Passphrase())
+	if err != nil {
+		return err
+	}
+	return decrypt(os.Stdout, stdin, stream, nonce, associatedData)
+}
+
+// openStream reads the header from r and returns the Stream,
+// nonce and associated data for decrypting the data stream
+// following the header. It also returns the size of the header.
+func openStream(r io.Reader, secret []byte, passphrase bool) (*sio.Stream, []byte, []byte, int, error) {
+	h, encHeader, err := readHeader(r)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if h.KDF == kdfArgon2id && !passphrase {
+		return nil, nil, nil, 0, errors.New("sio: data has been encrypted with a passphrase")
+	}
+	if h.KDF == kdfHKDF && passphrase {
+		return nil, nil, nil, 0, errors.New("sio: data has been encrypted with a key - not a passphrase")
+	}
+	key, err := deriveKey(h, secret)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	stream, err := h.Algorithm.StreamWithBufSize(key, h.BufSize)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	return stream, h.Nonce, encHeader, len(encHeader), nil
+}
+
+// encryptRaw encrypts standard input without writing a header.
+func encryptRaw(key []byte, algorithm string, bufSize int, nonce, associatedData string, passphrase bool) error {
+	stream, rawNonce, rawAD, err := rawStream(key, algorithm, bufSize, nonce, associatedData, passphrase)
+	if err != nil {
+		return err
+	}
+	return encrypt(bufio.NewWriter(os.Stdout), os.Stdin, stream, rawNonce, rawAD)
+}
+
+// decryptRaw decrypts standard input that has no header.
+func decryptRaw(key []byte, algorithm string, bufSize int, nonce, associatedData string, passphrase bool) error {
+	stream, rawNonce, rawAD, err := rawStream(key, algorithm, bufSize, nonce, associatedData, passphrase)
+	if err != nil {
+		return err
+	}
+	return decrypt(os.Stdout, os.Stdin, stream, rawNonce, rawAD)
+}
+
+func rawStream(key []byte, algorithm string, bufSize int, nonce, associatedData string, passphrase bool) (*sio.Stream, []byte, []byte, error) {
+	if passphrase {
+		return nil, nil, nil, errors.New("sio: -raw requires a key - not a passphrase")
+	}
+	if algorithm == "" || nonce == "" {
+		return nil, nil, nil, errors.New("sio: -raw requires -a and -nonce")
+	}
+	a, err := sio.ParseAlgorithm(algorithm)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rawNonce, err := hex.DecodeString(nonce)
+	if err != nil {
+		return nil, nil, nil, errors.New("sio: -nonce is not hex-encoded")
+	}
+	if len(rawNonce) != a.StreamNonceSize() {
+		return nil, nil, nil, fmt.Errorf("sio: invalid nonce size %d - %s requires a %d byte nonce", len(rawNonce), a, a.StreamNonceSize())
+	}
+	rawAD, err := hex.DecodeString(associatedData)
+	if err != nil {
+		return nil, nil, nil, errors.New("sio: -ad is not hex-encoded")
+	}
+	stream, err := a.StreamWithBufSize(key, bufSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return stream, rawNonce, rawAD, nil
+}
+
+// encrypt encrypts r and writes the
+// ciphertext to w.
+func encrypt(w *bufio.Writer, r io.Reader, stream *sio.Stream, nonce, associatedData []byte) error {
+	ew := stream.EncryptWriter(w, nonce, associatedData)
+	if _, err := ew.ReadFrom(r); err != nil {
+		return err
+	}
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// decrypt decrypts r and writes the
+// plaintext to w.
+func decrypt(w io.Writer, r io.Reader, stream *sio.Stream, nonce, associatedData []byte) error {
+	dr := stream.DecryptReader(r, nonce, associatedData)
+	if _, err := dr.WriteTo(w); err != nil {
+		if err == sio.NotAuthentic {
+			return errors.New("sio: decryption failed: data is not authentic or the key is wrong")
+		}
+		return err
+	}
+	return nil
+}
+
+// newFlagSet returns a new flag.FlagSet for the command
+// that contains the key flags - unless keys is nil.
+func newFlagSet(name, usage string, keys *keyFlags) *flag.FlagSet {
+	cli := flag.NewFlagSet(name, flag.ContinueOnError)
+	cli.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	if keys == nil {
+		return cli
+	}
+	cli.StringVar(&keys.KeyFile, "key-file", "", "")
+	cli.StringVar(&keys.KeyEnv, "key-env", "", "")
+	cli.StringVar(&keys.PassphraseFile, "passphrase-file", "", "")
+	cli.StringVar(&keys.PassphraseEnv, "passphrase-env", "", "")
+	return cli
+}
+
+// parseFlags parses the command-line flags and
+// rejects any additional arguments.
+func parseFlags(cli *flag.FlagSet, args []string) error { return parseFlagsAndArgs(cli, args, 0) }
+
+// parseFlagsAndArgs parses the command-line flags and
+// rejects more than maxArgs additional arguments.
+func parseFlagsAndArgs(cli *flag.FlagSet, args []string, maxArgs int) error {
+	if err := cli.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	if cli.NArg() > maxArgs {
+		return fmt.Errorf("sio: %s: unexpected argument '%s'", cli.Name(), cli.Arg(maxArgs))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(make([]byte, 32))+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("correct horse battery staple\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	plaintext := bytes.Repeat([]byte("sio"), 10000)
+
+	for i, test := range []struct {
+		Encrypt, Decrypt []string
+	}{
+		{Encrypt: []string{"-key-file", keyFile}, Decrypt: []string{"-key-file", keyFile}},
+		{Encrypt: []string{"-key-file", keyFile, "-a", sio.AES_128_GCM.String(), "-b", "1000"}, Decrypt: []string{"-key-file", keyFile}},
+		{Encrypt: []string{"-key-file", keyFile, "-a", sio.XChaCha20Poly1305.String(), "-b", "1"}, Decrypt: []string{"-key-file", keyFile}},
+		{Encrypt: []string{"-passphrase-file", passphraseFile}, Decrypt: []string{"-passphrase-file", passphraseFile}},
+		{
+			Encrypt: []string{"-key-file", keyFile, "-raw", "-a", sio.AES_256_GCM.String(), "-nonce", "0102030405060708", "-ad", "ff"},
+			Decrypt: []string{"-key-file", keyFile, "-raw", "-a", sio.AES_256_GCM.String(), "-nonce", "0102030405060708", "-ad", "ff"},
+		},
+	} {
+		ciphertext, err := run(t, encryptCmd, test.Encrypt, plaintext)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to encrypt: %v", i, err)
+		}
+		decrypted, err := run(t, decryptCmd, test.Decrypt, ciphertext)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to decrypt: %v", i, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("Test %d: plaintext does not match original plaintext", i)
+		}
+
+		ciphertext[len(ciphertext)/2] ^= 1
+		if _, err = run(t, decryptCmd, test.Decrypt, ciphertext); err == nil {
+			t.Fatalf("Test %d: modified ciphertext has been decrypted", i)
+		}
+	}
+
+	ciphertext, err := run(t, encryptCmd, []string{"-key-file", keyFile}, plaintext)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if _, err = run(t, decryptCmd, []string{"-passphrase-file", passphraseFile}, ciphertext); err == nil {
+		t.Fatal("Data encrypted with a key has been decrypted with a passphrase")
+	}
+}
+
+// run runs the command with the given input as standard
+// input and returns the standard output.
+func run(t *testing.T, cmd func([]string) error, args []string, input []byte) ([]byte, error) {
+	dir := t.TempDir()
+	stdin, err := os.Create(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	if _, err = stdin.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stdin.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(stdin, stdout *os.File) { os.Stdin, os.Stdout = stdin, stdout }(os.Stdin, os.Stdout)
+	os.Stdin, os.Stdout = stdin, stdout
+	if err = cmd(args); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(stdout.Name())
+}
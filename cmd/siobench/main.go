@@ -0,0 +1,451 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+// Command siobench measures the en/decryption throughput
+// of the sio package on the executing machine.
+//
+// It measures every combination of algorithm, buffer size,
+// API and parallelism level and writes the results as JSON.
+// The APIs are:
+//
+//	Encrypt: Write (EncWriter.Write), ReadFrom (EncWriter.ReadFrom),
+//	         Read (EncReader.Read), WriteTo (EncReader.WriteTo)
+//	Decrypt: Write (DecWriter.Write), ReadFrom (DecWriter.ReadFrom),
+//	         Read (DecReader.Read), WriteTo (DecReader.WriteTo),
+//	         ReadAt (DecReaderAt.ReadAt)
+//
+// For a parallelism level P, siobench en/decrypts P data streams
+// concurrently - each with its own Stream. The throughput is the
+// number of plaintext bytes processed per second by all goroutines.
+//
+// Usage:
+//
+//	siobench [-a ALGORITHMS] [-b BUFSIZES] [-p LEVELS] [-api APIS] [-size SIZE] [-duration DURATION] [-o FILE]
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/secure-io/sio-go"
+	"github.com/secure-io/sio-go/sioutil"
+)
+
+const usage = `Usage: siobench [OPTIONS]
+
+Measures the en/decryption throughput of all combinations of
+algorithms, buffer sizes, APIs and parallelism levels and writes
+the results as JSON to standard output.
+
+Options:
+  -a ALGORITHMS        Comma-separated list of algorithms.
+                       (default: all algorithms)
+  -b BUFSIZES          Comma-separated list of buffer sizes in bytes.
+                       (default: 1024,4096,16384,65536,1048576)
+  -p LEVELS            Comma-separated list of parallelism levels.
+                       (default: 1,2,4,... up to the number of CPUs)
+  -api APIS            Comma-separated list of APIs - e.g. Encrypt.Write
+                       or Decrypt.ReadAt. (default: all APIs)
+  -size SIZE           Size of each en/decrypted data stream in bytes.
+                       (default: 1048576)
+  -duration DURATION   Duration of each measurement. (default: 500ms)
+  -o FILE              Write the results to FILE instead of stdout.
+`
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+
+	var (
+		algorithmsFlag  string
+		bufSizesFlag    string
+		parallelismFlag string
+		apiFlag         string
+		sizeFlag        int
+		durationFlag    time.Duration
+		outFlag         string
+	)
+	flag.StringVar(&algorithmsFlag, "a", "", "")
+	flag.StringVar(&bufSizesFlag, "b", "1024,4096,16384,65536,1048576", "")
+	flag.StringVar(&parallelismFlag, "p", "", "")
+	flag.StringVar(&apiFlag, "api", "", "")
+	flag.IntVar(&sizeFlag, "size", 1<<20, "")
+	flag.DurationVar(&durationFlag, "duration", 500*time.Millisecond, "")
+	flag.StringVar(&outFlag, "o", "", "")
+	flag.Parse()
+	if flag.NArg() > 0 {
+		exit(errors.New("siobench: unexpected argument '" + flag.Arg(0) + "'"))
+	}
+
+	config := Config{
+		Algorithms: sio.Algorithms(),
+		APIs:       APIs,
+		Size:       sizeFlag,
+		Duration:   durationFlag,
+	}
+	if algorithmsFlag != "" {
+		config.Algorithms = nil
+		for _, name := range strings.Split(algorithmsFlag, ",") {
+			a, err := sio.ParseAlgorithm(strings.TrimSpace(name))
+			if err != nil {
+				exit(err)
+			}
+			config.Algorithms = append(config.Algorithms, a)
+		}
+	}
+	var err error
+	if config.BufSizes, err = parseInts(bufSizesFlag); err != nil {
+		exit(err)
+	}
+	if parallelismFlag == "" {
+		for p := 1; p < runtime.NumCPU(); p *= 2 {
+			config.Parallelism = append(config.Parallelism, p)
+		}
+		config.Parallelism = append(config.Parallelism, runtime.NumCPU())
+	} else if config.Parallelism, err = parseInts(parallelismFlag); err != nil {
+		exit(err)
+	}
+	if apiFlag != "" {
+		config.APIs = nil
+		for _, name := range strings.Split(apiFlag, ",") {
+			api, ok := lookupAPI(strings.TrimSpace(name))
+			if !ok {
+				exit(errors.New("siobench: unknown API '" + name + "'"))
+			}
+			config.APIs = append(config.APIs, api)
+		}
+	}
+
+	report, err := Run(config)
+	if err != nil {
+		exit(err)
+	}
+
+	out := os.Stdout
+	if outFlag != "" {
+		if out, err = os.Create(outFlag); err != nil {
+			exit(err)
+		}
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		exit(err)
+	}
+	if err = out.Close(); err != nil {
+		exit(err)
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func parseInts(s string) ([]int, error) {
+	var ints []int
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n <= 0 {
+			return nil, errors.New("siobench: invalid value '" + v + "'")
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+// Config describes which measurements Run performs.
+type Config struct {
+	Algorithms  []sio.Algorithm
+	BufSizes    []int
+	APIs        []API
+	Parallelism []int
+
+	Size     int           // Size of each data stream in bytes
+	Duration time.Duration // Duration of each measurement
+}
+
+// Report contains the results of all measurements and
+// describes the machine that performed them.
+type Report struct {
+	GoVersion string
+	GOOS      string
+	GOARCH    string
+	NumCPU    int
+	NativeAES bool
+	Results   []Result
+}
+
+// Result is the result of a single measurement.
+type Result struct {
+	Algorithm   sio.Algorithm
+	BufSize     int
+	API         string
+	Parallelism int
+	Size        int // Size of each data stream in bytes
+
+	Streams        int64   // Number of en/decrypted data streams
+	Seconds        float64 // Duration of the measurement
+	BytesPerSecond float64 // Plaintext bytes per second
+}
+
+// Run performs all measurements described by the config.
+func Run(config Config) (*Report, error) {
+	if config.Size < 0 {
+		return nil, errors.New("siobench: invalid size " + strconv.Itoa(config.Size))
+	}
+	report := &Report{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		NativeAES: sioutil.NativeAES(),
+	}
+	for _, a := range config.Algorithms {
+		for _, bufSize := range config.BufSizes {
+			for _, api := range config.APIs {
+				for _, p := range config.Parallelism {
+					result, err := measure(a, bufSize, api, p, config.Size, config.Duration)
+					if err != nil {
+						return nil, fmt.Errorf("siobench: %s (bufSize %d) %s: %v", a, bufSize, api.Name, err)
+					}
+					report.Results = append(report.Results, result)
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+// measure en/decrypts data streams using p goroutines until
+// the duration has elapsed.
+func measure(a sio.Algorithm, bufSize int, api API, p, size int, duration time.Duration) (Result, error) {
+	workers := make([]func() error, p)
+	for i := range workers {
+		key := make([]byte, a.KeySize())
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return Result{}, err
+		}
+		stream, err := a.StreamWithBufSize(key, bufSize)
+		if err != nil {
+			return Result{}, err
+		}
+		if workers[i], err = api.New(stream, size); err != nil {
+			return Result{}, err
+		}
+	}
+
+	var (
+		streams  atomic.Int64
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	start := time.Now()
+	deadline := start.Add(duration)
+	for _, worker := range workers {
+		wg.Add(1)
+		go func(worker func() error) {
+			defer wg.Done()
+			for { // Each worker processes at least one data stream
+				if err := worker(); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					return
+				}
+				streams.Add(1)
+				if !time.Now().Before(deadline) {
+					return
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+	if firstErr != nil {
+		return Result{}, firstErr
+	}
+
+	return Result{
+		Algorithm:      a,
+		BufSize:        bufSize,
+		API:            api.Name,
+		Parallelism:    p,
+		Size:           size,
+		Streams:        streams.Load(),
+		Seconds:        elapsed,
+		BytesPerSecond: float64(streams.Load()) * float64(size) / elapsed,
+	}, nil
+}
+
+// API is an en/decryption API. New returns a function that
+// en/decrypts one size bytes long data stream per call.
+type API struct {
+	Name string
+	New  func(stream *sio.Stream, size int) (func() error, error)
+}
+
+func lookupAPI(name string) (API, bool) {
+	for _, api := range APIs {
+		if api.Name == name {
+			return api, true
+		}
+	}
+	return API{}, false
+}
+
+// APIs are all en/decryption APIs measured by siobench.
+var APIs = []API{
+	{Name: "Encrypt.Write", New: func(stream *sio.Stream, size int) (func() error, error) {
+		plaintext := make([]byte, size)
+		w := stream.EncryptWriter(io.Discard, make([]byte, stream.NonceSize()), nil)
+		return func() error {
+			w.Reset(0)
+			if _, err := w.Write(plaintext); err != nil {
+				return err
+			}
+			return w.Close()
+		}, nil
+	}},
+	{Name: "Encrypt.ReadFrom", New: func(stream *sio.Stream, size int) (func() error, error) {
+		plaintext := bytes.NewReader(make([]byte, size))
+		w := stream.EncryptWriter(io.Discard, make([]byte, stream.NonceSize()), nil)
+		return func() error {
+			w.Reset(0)
+			plaintext.Seek(0, io.SeekStart)
+			if _, err := w.ReadFrom(plaintext); err != nil {
+				return err
+			}
+			return w.Close()
+		}, nil
+	}},
+	{Name: "Encrypt.Read", New: func(stream *sio.Stream, size int) (func() error, error) {
+		plaintext := bytes.NewReader(make([]byte, size))
+		buffer := make([]byte, stream.BufSize()+int(stream.Overhead(int64(stream.BufSize()))))
+		r := stream.EncryptReader(plaintext, make([]byte, stream.NonceSize()), nil)
+		return func() error {
+			r.Reset(0)
+			plaintext.Seek(0, io.SeekStart)
+			return readAll(r, buffer)
+		}, nil
+	}},
+	{Name: "Encrypt.WriteTo", New: func(stream *sio.Stream, size int) (func() error, error) {
+		plaintext := bytes.NewReader(make([]byte, size))
+		r := stream.EncryptReader(plaintext, make([]byte, stream.NonceSize()), nil)
+		return func() error {
+			r.Reset(0)
+			plaintext.Seek(0, io.SeekStart)
+			_, err := r.WriteTo(io.Discard)
+			return err
+		}, nil
+	}},
+	{Name: "Decrypt.Write", New: func(stream *sio.Stream, size int) (func() error, error) {
+		ciphertext, nonce, err := encrypt(stream, size)
+		if err != nil {
+			return nil, err
+		}
+		w := stream.DecryptWriter(io.Discard, nonce, nil)
+		return func() error {
+			w.Reset(0)
+			if _, err := w.Write(ciphertext); err != nil {
+				return err
+			}
+			return w.Close()
+		}, nil
+	}},
+	{Name: "Decrypt.ReadFrom", New: func(stream *sio.Stream, size int) (func() error, error) {
+		ciphertext, nonce, err := encrypt(stream, size)
+		if err != nil {
+			return nil, err
+		}
+		src := bytes.NewReader(ciphertext)
+		w := stream.DecryptWriter(io.Discard, nonce, nil)
+		return func() error {
+			w.Reset(0)
+			src.Seek(0, io.SeekStart)
+			if _, err := w.ReadFrom(src); err != nil {
+				return err
+			}
+			return w.Close()
+		}, nil
+	}},
+	{Name: "Decrypt.Read", New: func(stream *sio.Stream, size int) (func() error, error) {
+		ciphertext, nonce, err := encrypt(stream, size)
+		if err != nil {
+			return nil, err
+		}
+		src := bytes.NewReader(ciphertext)
+		buffer := make([]byte, stream.BufSize())
+		r := stream.DecryptReader(src, nonce, nil)
+		return func() error {
+			r.Reset(0)
+			src.Seek(0, io.SeekStart)
+			return readAll(r, buffer)
+		}, nil
+	}},
+	{Name: "Decrypt.WriteTo", New: func(stream *sio.Stream, size int) (func() error, error) {
+		ciphertext, nonce, err := encrypt(stream, size)
+		if err != nil {
+			return nil, err
+		}
+		src := bytes.NewReader(ciphertext)
+		r := stream.DecryptReader(src, nonce, nil)
+		return func() error {
+			r.Reset(0)
+			src.Seek(0, io.SeekStart)
+			_, err := r.WriteTo(io.Discard)
+			return err
+		}, nil
+	}},
+	{Name: "Decrypt.ReadAt", New: func(stream *sio.Stream, size int) (func() error, error) {
+		ciphertext, nonce, err := encrypt(stream, size)
+		if err != nil {
+			return nil, err
+		}
+		plaintext := make([]byte, size)
+		r := stream.DecryptReaderAt(bytes.NewReader(ciphertext), nonce, nil)
+		return func() error {
+			if _, err := r.ReadAt(plaintext, 0); err != nil && err != io.EOF {
+				return err
+			}
+			return nil
+		}, nil
+	}},
+}
+
+// encrypt returns the ciphertext of a size
+// bytes long data stream and its nonce.
+func encrypt(stream *sio.Stream, size int) ([]byte, []byte, error) {
+	nonce := make([]byte, stream.NonceSize())
+	var ciphertext bytes.Buffer
+	w := stream.EncryptWriter(&ciphertext, nonce, nil)
+	if _, err := w.Write(make([]byte, size)); err != nil {
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, nil, err
+	}
+	return ciphertext.Bytes(), nonce, nil
+}
+
+// readAll reads from r until io.EOF using the buffer.
+func readAll(r io.Reader, buffer []byte) error {
+	for {
+		if _, err := r.Read(buffer); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
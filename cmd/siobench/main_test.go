@@ -0,0 +1,59 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/secure-io/sio-go"
+)
+
+func TestRun(t *testing.T) {
+	config := Config{
+		Algorithms:  sio.Algorithms(),
+		BufSizes:    []int{1, 1024},
+		APIs:        APIs,
+		Parallelism: []int{1, 2},
+		Size:        4 * 1024,
+		Duration:    time.Millisecond,
+	}
+	report, err := Run(config)
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+	if n := len(config.Algorithms) * len(config.BufSizes) * len(config.APIs) * len(config.Parallelism); len(report.Results) != n {
+		t.Fatalf("Invalid number of results: got %d - want %d", len(report.Results), n)
+	}
+	for i, result := range report.Results {
+		if result.Streams <= 0 || result.BytesPerSecond <= 0 {
+			t.Fatalf("Result %d: %s (bufSize %d) %s did not process any data", i, result.Algorithm, result.BufSize, result.API)
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Failed to encode report: %v", err)
+	}
+	var parsed Report
+	if err = json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(parsed.Results) != len(report.Results) || parsed.Results[0].Algorithm != report.Results[0].Algorithm {
+		t.Fatal("Decoded report does not match the original report")
+	}
+}
+
+func TestLookupAPI(t *testing.T) {
+	for _, api := range APIs {
+		if _, ok := lookupAPI(api.Name); !ok {
+			t.Fatalf("API %s not found", api.Name)
+		}
+	}
+	if _, ok := lookupAPI("Encrypt.Seek"); ok {
+		t.Fatal("Unknown API found")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+// Command testvectors generates known-answer test vectors
+// for the sio stream construction.
+//
+// It generates test vectors for every algorithm, for several
+// buffer sizes - including the edge cases 1 and MaxBufSize -
+// and for empty, single-fragment, exact-fragment and multi-fragment
+// plaintexts. Further, it generates test vectors for data streams
+// that start at a later fragment - see EncWriter.Reset.
+//
+// The test vectors use the JSON format of the test_vectors.json file:
+//
+//	[
+//		{"Algorithm":"AES-128-GCM","BufSize":16,"Key":"...","Nonce":"...","AssociatedData":"...","Plaintext":"...","Ciphertext":"..."},
+//		...
+//	]
+//
+// All binary values are hex-encoded. Test vectors for data streams
+// that start at a later fragment contain a non-zero "BlockNum" field.
+// Their plaintext resp. ciphertext starts at the fragment BlockNum.
+//
+// The generated test vectors are deterministic. The key, nonce,
+// associated data and plaintext of each test vector are generated
+// by a ChaCha8 PRNG seeded with the SHA-256 hash of a test vector
+// label. Hence, running testvectors twice produces the same output.
+//
+// Usage:
+//
+//	testvectors [-o FILE] [-max-size SIZE] [ALGORITHM ...]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/secure-io/sio-go"
+)
+
+const usage = `Usage: testvectors [-o FILE] [-max-size SIZE] [ALGORITHM ...]
+
+Generates deterministic known-answer test vectors for the given
+algorithms. If no algorithm is specified, it generates test vectors
+for all algorithms.
+
+Options:
+  -o FILE        Write the test vectors to FILE instead of stdout.
+  -max-size SIZE Skip test vectors with plaintexts larger than SIZE
+                 bytes. (default: 65536)
+`
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+
+	var (
+		outFlag     string
+		maxSizeFlag int
+	)
+	flag.StringVar(&outFlag, "o", "", "")
+	flag.IntVar(&maxSizeFlag, "max-size", 1<<16, "")
+	flag.Parse()
+
+	algorithms := sio.Algorithms()
+	if flag.NArg() > 0 {
+		algorithms = algorithms[:0]
+		for _, name := range flag.Args() {
+			a, err := sio.ParseAlgorithm(name)
+			if err != nil {
+				exit(err)
+			}
+			algorithms = append(algorithms, a)
+		}
+	}
+	if maxSizeFlag < 0 {
+		exit(errors.New("testvectors: invalid max. plaintext size " + strconv.Itoa(maxSizeFlag)))
+	}
+
+	vectors, err := generate(algorithms, maxSizeFlag)
+	if err != nil {
+		exit(err)
+	}
+
+	out := os.Stdout
+	if outFlag != "" {
+		if out, err = os.Create(outFlag); err != nil {
+			exit(err)
+		}
+	}
+	if err = writeVectors(out, vectors); err != nil {
+		exit(err)
+	}
+	if err = out.Close(); err != nil {
+		exit(err)
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// BufSizes are the buffer sizes of the generated test vectors.
+var BufSizes = []int{1, 2, 15, 16, 17, 64, 1000, sio.BufSize, sio.MaxBufSize}
+
+// BlockNums are the fragment numbers at which the data streams
+// of the generated Reset test vectors start.
+var BlockNums = []uint32{1, 7, math.MaxUint32 - 1}
+
+// Vector is a test vector. Its JSON encoding
+// matches the format of test_vectors.json.
+type Vector struct {
+	Algorithm      sio.Algorithm
+	BufSize        int
+	BlockNum       uint32 `json:",omitempty"`
+	Key            hexBytes
+	Nonce          hexBytes
+	AssociatedData hexBytes
+	Plaintext      hexBytes
+	Ciphertext     hexBytes
+}
+
+type hexBytes []byte
+
+func (b hexBytes) MarshalText() ([]byte, error) { return []byte(hex.EncodeToString(b)), nil }
+
+func (b *hexBytes) UnmarshalText(text []byte) (err error) {
+	*b, err = hex.DecodeString(string(text))
+	return err
+}
+
+// generate generates the test vectors for all algorithms.
+func generate(algorithms []sio.Algorithm, maxSize int) ([]Vector, error) {
+	var vectors []Vector
+	for _, a := range algorithms {
+		for _, bufSize := range BufSizes {
+			sizes := []int{0, 1, bufSize - 1, bufSize, bufSize + 1, 2 * bufSize, 3*bufSize + 1}
+			for i, size := range sizes {
+				if size < 0 || size > maxSize || slices.Contains(sizes[:i], size) {
+					continue
+				}
+				v, err := generateVector(a, bufSize, 0, size)
+				if err != nil {
+					return nil, err
+				}
+				vectors = append(vectors, v)
+			}
+
+			for _, blockNum := range BlockNums {
+				size := 2*bufSize + 1
+				if blockNum == math.MaxUint32-1 {
+					size = bufSize // Only one fragment can be encrypted after the fragment MaxUint32-1.
+				}
+				if size > maxSize {
+					continue
+				}
+				v, err := generateVector(a, bufSize, blockNum, size)
+				if err != nil {
+					return nil, err
+				}
+				vectors = append(vectors, v)
+			}
+		}
+	}
+	return vectors, nil
+}
+
+// generateVector generates a single test vector. It encrypts
+// the plaintext using an EncWriter and verifies that a DecReader
+// can decrypt the ciphertext again.
+func generateVector(a sio.Algorithm, bufSize int, blockNum uint32, size int) (Vector, error) {
+	label := fmt.Sprintf("%s/%d/%d/%d", a, bufSize, blockNum, size)
+	seed := sha256.Sum256([]byte(label))
+	rng := rand.New(rand.NewChaCha8(seed))
+
+	v := Vector{
+		Algorithm:      a,
+		BufSize:        bufSize,
+		BlockNum:       blockNum,
+		Key:            randomBytes(rng, a.KeySize()),
+		Nonce:          randomBytes(rng, a.StreamNonceSize()),
+		AssociatedData: randomBytes(rng, rng.IntN(33)),
+		Plaintext:      randomBytes(rng, size),
+	}
+	stream, err := a.StreamWithBufSize(v.Key, bufSize)
+	if err != nil {
+		return Vector{}, fmt.Errorf("testvectors: %s: %v", label, err)
+	}
+
+	var ciphertext bytes.Buffer
+	w := stream.EncryptWriter(&ciphertext, v.Nonce, v.AssociatedData)
+	w.Reset(blockNum)
+	if _, err = w.Write(v.Plaintext); err != nil {
+		return Vector{}, fmt.Errorf("testvectors: %s: %v", label, err)
+	}
+	if err = w.Close(); err != nil {
+		return Vector{}, fmt.Errorf("testvectors: %s: %v", label, err)
+	}
+	v.Ciphertext = ciphertext.Bytes()
+
+	r := stream.DecryptReader(bytes.NewReader(v.Ciphertext), v.Nonce, v.AssociatedData)
+	r.Reset(blockNum)
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return Vector{}, fmt.Errorf("testvectors: %s: failed to decrypt ciphertext: %v", label, err)
+	}
+	if !bytes.Equal(plaintext, v.Plaintext) {
+		return Vector{}, fmt.Errorf("testvectors: %s: plaintext does not match original plaintext", label)
+	}
+	return v, nil
+}
+
+// writeVectors writes the test vectors as JSON array
+// with one test vector per line.
+func writeVectors(w io.Writer, vectors []Vector) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[\n")
+	for i, v := range vectors {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		bw.WriteByte('\t')
+		bw.Write(b)
+		if i < len(vectors)-1 {
+			bw.WriteByte(',')
+		}
+		bw.WriteByte('\n')
+	}
+	bw.WriteString("]\n")
+	return bw.Flush()
+}
+
+func randomBytes(rng *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(rng.Uint32())
+	}
+	return b
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+func TestGenerate(t *testing.T) {
+	const maxSize = 1 << 10
+
+	vectors, err := generate(sio.Algorithms(), maxSize)
+	if err != nil {
+		t.Fatalf("Failed to generate test vectors: %v", err)
+	}
+	var a, b bytes.Buffer
+	if err = writeVectors(&a, vectors); err != nil {
+		t.Fatalf("Failed to write test vectors: %v", err)
+	}
+
+	vectors, err = generate(sio.Algorithms(), maxSize)
+	if err != nil {
+		t.Fatalf("Failed to generate test vectors: %v", err)
+	}
+	if err = writeVectors(&b, vectors); err != nil {
+		t.Fatalf("Failed to write test vectors: %v", err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatal("Test vectors are not deterministic")
+	}
+
+	var parsed []Vector
+	if err = json.Unmarshal(a.Bytes(), &parsed); err != nil {
+		t.Fatalf("Failed to parse test vectors: %v", err)
+	}
+	if len(parsed) != len(vectors) {
+		t.Fatalf("Invalid number of test vectors: got %d - want %d", len(parsed), len(vectors))
+	}
+	for i, v := range parsed {
+		if v.BlockNum != 0 {
+			continue
+		}
+		stream, err := v.Algorithm.StreamWithBufSize(v.Key, v.BufSize)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create Stream: %v", i, err)
+		}
+		if n := int64(len(v.Ciphertext)); n != int64(len(v.Plaintext))+stream.Overhead(int64(len(v.Plaintext))) {
+			t.Fatalf("Test %d: Invalid ciphertext length %d", i, n)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+	"math"
+	"sync"
+)
+
+// CommitmentSize is the size of a key commitment in bytes.
+const CommitmentSize = 32
+
+// A CommittingStream is a key-committing Stream.
+//
+// AEAD algorithms like AES-GCM and ChaCha20-Poly1305 are not
+// key-committing. An attacker can craft a ciphertext that
+// decrypts successfully under two (or more) different keys.
+// This enables partitioning oracle attacks against applications
+// that try multiple keys - e.g. multiple passwords or recipients.
+//
+// A CommittingStream derives a commitment value from the key and
+// nonce and writes it in front of the encrypted data stream. When
+// decrypting, the commitment is read and verified before any
+// fragment is decrypted such that a data stream can only be
+// decrypted with the key and nonce that encrypted it.
+// Therefore, an encrypted data stream is CommitmentSize bytes
+// larger than the one produced by a Stream.
+//
+// The Stream key and the commitment key are derived from the key
+// using HKDF-SHA-256. Hence, data streams encrypted by a
+// CommittingStream cannot be decrypted by a Stream with the same
+// key and vice versa.
+type CommittingStream struct {
+	stream    *Stream
+	commitKey []byte
+}
+
+// CommittingStream returns a new CommittingStream using the
+// given secret key and AEAD algorithm. The Streams returned by
+// the CommittingStream use the default buffer size: BufSize.
+func (a Algorithm) CommittingStream(key []byte) (*CommittingStream, error) {
+	return a.CommittingStreamWithBufSize(key, BufSize)
+}
+
+// CommittingStreamWithBufSize returns a new CommittingStream
+// using the given secret key and AEAD algorithm. The Streams
+// returned by the CommittingStream use the given buffer size
+// which must be between 1 (inclusive) and MaxBufSize (inclusive).
+func (a Algorithm) CommittingStreamWithBufSize(key []byte, bufSize int) (*CommittingStream, error) {
+	// Check the key size, buffer size and algorithm
+	// before deriving any keys.
+	if _, err := a.StreamWithBufSize(key, bufSize); err != nil {
+		return nil, err
+	}
+
+	streamKey, err := hkdf.Key(sha256.New, key, nil, "sio: key committing stream: "+string(a), len(key))
+	if err != nil {
+		return nil, err
+	}
+	stream, err := a.StreamWithBufSize(streamKey, bufSize)
+	if err != nil {
+		return nil, err
+	}
+	commitKey, err := hkdf.Key(sha256.New, key, nil, "sio: key committing stream: "+string(a)+": commitment", sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &CommittingStream{
+		stream:    stream,
+		commitKey: commitKey,
+	}, nil
+}
+
+// NonceSize returns the size of the unique nonce that must be
+// provided when encrypting or decrypting a data stream.
+func (s *CommittingStream) NonceSize() int { return s.stream.NonceSize() }
+
+// Overhead returns the overhead added when encrypting a
+// data stream. It is the overhead of the underlying Stream
+// plus CommitmentSize. Like Stream.Overhead, it returns 0 if
+// size is too large and -1 if size is negative.
+func (s *CommittingStream) Overhead(size int64) int64 {
+	overhead := s.stream.Overhead(size)
+	if overhead <= 0 {
+		return overhead
+	}
+	return overhead + CommitmentSize
+}
+
+// EncryptWriter returns a new EncWriter that wraps w and
+// encrypts and authenticates everything before writing
+// it to w. The EncWriter writes the key commitment before
+// the first encrypted fragment.
+//
+// The nonce must be NonceSize() bytes long and unique for the
+// same key. The associatedData is only authenticated but not
+// encrypted. See: Stream.EncryptWriter
+func (s *CommittingStream) EncryptWriter(w io.Writer, nonce, associatedData []byte) *EncWriter {
+	return s.stream.EncryptWriter(&commitWriter{
+		w:          w,
+		commitment: s.commitment(nonce),
+	}, nonce, associatedData)
+}
+
+// DecryptReader returns a new DecReader that wraps r and
+// decrypts and verifies everything it reads from r. The
+// DecReader reads and verifies the key commitment before
+// decrypting the first fragment. It returns ErrKeyCommitment
+// if the commitment does not match - i.e. if the data stream
+// has not been encrypted with the key and nonce.
+//
+// The nonce and associatedData must match the values used when
+// encrypting the data stream. See: Stream.DecryptReader
+func (s *CommittingStream) DecryptReader(r io.Reader, nonce, associatedData []byte) *DecReader {
+	return s.stream.DecryptReader(&commitReader{
+		r:          r,
+		commitment: s.commitment(nonce),
+	}, nonce, associatedData)
+}
+
+// DecryptReaderAt returns a new DecReaderAt that wraps r and
+// decrypts and verifies everything it reads from r. The
+// DecReaderAt reads and verifies the key commitment before
+// decrypting any fragment. It returns ErrKeyCommitment if
+// the commitment does not match - i.e. if the data stream
+// has not been encrypted with the key and nonce.
+//
+// The nonce and associatedData must match the values used when
+// encrypting the data stream. See: Stream.DecryptReaderAt
+func (s *CommittingStream) DecryptReaderAt(r io.ReaderAt, nonce, associatedData []byte) *DecReaderAt {
+	return s.stream.DecryptReaderAt(&commitReaderAt{
+		r:          r,
+		commitment: s.commitment(nonce),
+	}, nonce, associatedData)
+}
+
+// commitment returns the key commitment for the nonce.
+func (s *CommittingStream) commitment(nonce []byte) []byte {
+	if len(nonce) != s.NonceSize() {
+		panic("sio: nonce has invalid length")
+	}
+	mac := hmac.New(sha256.New, s.commitKey)
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// commitWriter writes the key commitment
+// before the first write to w.
+type commitWriter struct {
+	w          io.Writer
+	commitment []byte
+}
+
+func (w *commitWriter) Write(p []byte) (int, error) {
+	if len(w.commitment) > 0 {
+		n, err := w.w.Write(w.commitment)
+		w.commitment = w.commitment[n:]
+		if err != nil {
+			return 0, err
+		}
+	}
+	return w.w.Write(p)
+}
+
+// commitReader reads and verifies the key
+// commitment before the first read from r.
+type commitReader struct {
+	r          io.Reader
+	commitment []byte
+	verified   bool
+	err        error
+}
+
+func (r *commitReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if !r.verified {
+		if r.err = verifyCommitment(r.r, r.commitment); r.err != nil {
+			return 0, r.err
+		}
+		r.verified = true
+	}
+	return r.r.Read(p)
+}
+
+// commitReaderAt reads and verifies the key commitment
+// before the first read from r. All reads are shifted by
+// CommitmentSize bytes.
+type commitReaderAt struct {
+	r          io.ReaderAt
+	commitment []byte
+
+	once sync.Once
+	err  error
+}
+
+func (r *commitReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	r.once.Do(func() {
+		r.err = verifyCommitment(io.NewSectionReader(r.r, 0, CommitmentSize), r.commitment)
+	})
+	if r.err != nil {
+		return 0, r.err
+	}
+	if offset > math.MaxInt64-CommitmentSize {
+		return 0, ErrExceeded
+	}
+	return r.r.ReadAt(p, offset+CommitmentSize)
+}
+
+// verifyCommitment reads a key commitment from r and
+// compares it with the given commitment in constant time.
+func verifyCommitment(r io.Reader, commitment []byte) error {
+	var buffer [CommitmentSize]byte
+	if _, err := io.ReadFull(r, buffer[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrKeyCommitment
+		}
+		return err
+	}
+	if !hmac.Equal(buffer[:], commitment) {
+		return ErrKeyCommitment
+	}
+	return nil
+}
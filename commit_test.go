@@ -0,0 +1,117 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCommittingStream(t *testing.T) {
+	for i, a := range Algorithms() {
+		committing, err := a.CommittingStreamWithBufSize(random(a.KeySize()), 64)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create CommittingStream: %v", i, err)
+		}
+		nonce, associatedData := random(committing.NonceSize()), random(32)
+		plaintext := random(1000)
+
+		var ciphertext bytes.Buffer
+		w := committing.EncryptWriter(&ciphertext, nonce, associatedData)
+		if _, err = w.Write(plaintext); err != nil {
+			t.Fatalf("Test %d: Failed to encrypt plaintext: %v", i, err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Test %d: Failed to close EncWriter: %v", i, err)
+		}
+		if size := int64(len(plaintext)) + committing.Overhead(int64(len(plaintext))); size != int64(ciphertext.Len()) {
+			t.Fatalf("Test %d: Invalid ciphertext size: got %d - want %d", i, ciphertext.Len(), size)
+		}
+
+		var output bytes.Buffer
+		if _, err = committing.DecryptReader(bytes.NewReader(ciphertext.Bytes()), nonce, associatedData).WriteTo(&output); err != nil {
+			t.Fatalf("Test %d: Failed to decrypt data stream: %v", i, err)
+		}
+		if !bytes.Equal(output.Bytes(), plaintext) {
+			t.Fatalf("Test %d: Plaintext does not match original plaintext", i)
+		}
+
+		output.Reset()
+		r := io.NewSectionReader(committing.DecryptReaderAt(bytes.NewReader(ciphertext.Bytes()), nonce, associatedData), 100, 500)
+		if _, err = io.Copy(&output, r); err != nil {
+			t.Fatalf("Test %d: Failed to decrypt data stream: %v", i, err)
+		}
+		if !bytes.Equal(output.Bytes(), plaintext[100:600]) {
+			t.Fatalf("Test %d: Plaintext does not match original plaintext", i)
+		}
+	}
+}
+
+func TestCommittingStreamMismatch(t *testing.T) {
+	key := random(32)
+	committing, err := AES_256_GCM.CommittingStream(key)
+	if err != nil {
+		t.Fatalf("Failed to create CommittingStream: %v", err)
+	}
+	other, err := AES_256_GCM.CommittingStream(random(32))
+	if err != nil {
+		t.Fatalf("Failed to create CommittingStream: %v", err)
+	}
+	nonce := random(committing.NonceSize())
+
+	var buffer bytes.Buffer
+	w := committing.EncryptWriter(&buffer, nonce, nil)
+	if _, err = w.Write(random(100)); err != nil {
+		t.Fatalf("Failed to encrypt plaintext: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Failed to close EncWriter: %v", err)
+	}
+	ciphertext := buffer.Bytes()
+
+	decrypt := func(s *CommittingStream, nonce, ciphertext []byte) (error, error) {
+		_, err := s.DecryptReader(bytes.NewReader(ciphertext), nonce, nil).Read(make([]byte, 100))
+		_, errAt := s.DecryptReaderAt(bytes.NewReader(ciphertext), nonce, nil).ReadAt(make([]byte, 100), 0)
+		return err, errAt
+	}
+	if err, errAt := decrypt(other, nonce, ciphertext); err != ErrKeyCommitment || errAt != ErrKeyCommitment {
+		t.Fatalf("Decrypting with a different key should fail with ErrKeyCommitment: got %v and %v", err, errAt)
+	}
+	if err, errAt := decrypt(committing, random(committing.NonceSize()), ciphertext); err != ErrKeyCommitment || errAt != ErrKeyCommitment {
+		t.Fatalf("Decrypting with a different nonce should fail with ErrKeyCommitment: got %v and %v", err, errAt)
+	}
+	for i := 0; i < CommitmentSize; i++ {
+		modified := append([]byte(nil), ciphertext...)
+		modified[i] ^= 1
+		if err, errAt := decrypt(committing, nonce, modified); err != ErrKeyCommitment || errAt != ErrKeyCommitment {
+			t.Fatalf("Test %d: Decrypting a modified commitment should fail with ErrKeyCommitment: got %v and %v", i, err, errAt)
+		}
+	}
+	if err, errAt := decrypt(committing, nonce, ciphertext[:CommitmentSize-1]); err != ErrKeyCommitment || errAt != ErrKeyCommitment {
+		t.Fatalf("Decrypting a truncated commitment should fail with ErrKeyCommitment: got %v and %v", err, errAt)
+	}
+
+	// The Stream key of a CommittingStream is derived from the key.
+	plain, err := AES_256_GCM.Stream(key)
+	if err != nil {
+		t.Fatalf("Failed to create Stream: %v", err)
+	}
+	if _, err = plain.DecryptReader(bytes.NewReader(ciphertext[CommitmentSize:]), nonce, nil).Read(make([]byte, 100)); err != NotAuthentic {
+		t.Fatalf("Decrypting with the key instead of the derived Stream key should fail with NotAuthentic: got %v", err)
+	}
+}
+
+func TestCommittingStreamInvalid(t *testing.T) {
+	if _, err := AES_128_GCM.CommittingStream(random(32)); err == nil {
+		t.Fatal("Creating a CommittingStream with an invalid key size should fail")
+	}
+	if _, err := AES_128_GCM.CommittingStreamWithBufSize(random(16), 0); err == nil {
+		t.Fatal("Creating a CommittingStream with an invalid buffer size should fail")
+	}
+	if _, err := Algorithm("unknown").CommittingStream(nil); err == nil {
+		t.Fatal("Creating a CommittingStream for an unknown algorithm should fail")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+)
+
+const (
+	ctrHMACNonceSize = 12
+	ctrHMACTagSize   = sha256.Size
+
+	// The CTR counter occupies the last 4 bytes of the
+	// 16 byte IV. Therefore, at most 2³² blocks can be
+	// en/decrypted using the same nonce.
+	ctrHMACMaxPlaintext = (1 << 32) * aes.BlockSize
+)
+
+// aesCTRHMAC implements the cipher.AEAD interface using
+// the encrypt-then-MAC composition of AES-256-CTR and
+// HMAC-SHA-256.
+//
+// The encryption and MAC keys are derived from the secret
+// key using HKDF-SHA-256. The CTR IV is the 12 byte nonce
+// followed by a 32 bit big-endian block counter - similar to
+// AES-GCM. The HMAC is computed over the nonce, the associated
+// data and the ciphertext followed by the length of the
+// associated data and the ciphertext. The entire 32 byte
+// HMAC-SHA-256 value is used as authentication tag.
+type aesCTRHMAC struct {
+	block  cipher.Block
+	macKey []byte
+}
+
+func newAESCTRHMAC(key []byte) (cipher.AEAD, error) {
+	if len(key) != 256/8 {
+		return nil, aes.KeySizeError(len(key))
+	}
+	keys, err := hkdf.Key(sha256.New, key, nil, "sio: AES-256-CTR-HMAC-SHA256", 2*32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, err
+	}
+	return &aesCTRHMAC{
+		block:  block,
+		macKey: keys[32:],
+	}, nil
+}
+
+func (c *aesCTRHMAC) NonceSize() int { return ctrHMACNonceSize }
+
+func (c *aesCTRHMAC) Overhead() int { return ctrHMACTagSize }
+
+func (c *aesCTRHMAC) Seal(dst, nonce, plaintext, associatedData []byte) []byte {
+	if len(nonce) != ctrHMACNonceSize {
+		panic("sio: incorrect nonce length given to AES-CTR-HMAC")
+	}
+	if uint64(len(plaintext)) > ctrHMACMaxPlaintext {
+		panic("sio: message too large for AES-CTR-HMAC")
+	}
+
+	ret, out := sliceForAppend(dst, len(plaintext)+ctrHMACTagSize)
+	if inexactOverlap(out, plaintext) {
+		panic("sio: invalid buffer overlap")
+	}
+	ciphertext := out[:len(plaintext)]
+	c.xorKeyStream(ciphertext, nonce, plaintext)
+	c.tag(ciphertext[len(ciphertext):], nonce, ciphertext, associatedData)
+	return ret
+}
+
+func (c *aesCTRHMAC) Open(dst, nonce, ciphertext, associatedData []byte) ([]byte, error) {
+	if len(nonce) != ctrHMACNonceSize {
+		panic("sio: incorrect nonce length given to AES-CTR-HMAC")
+	}
+	if len(ciphertext) < ctrHMACTagSize {
+		return nil, errOpen
+	}
+	if uint64(len(ciphertext)) > ctrHMACMaxPlaintext+ctrHMACTagSize {
+		return nil, errOpen
+	}
+
+	tag := ciphertext[len(ciphertext)-ctrHMACTagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-ctrHMACTagSize]
+
+	var expectedTag [ctrHMACTagSize]byte
+	c.tag(expectedTag[:0], nonce, ciphertext, associatedData)
+	if subtle.ConstantTimeCompare(expectedTag[:], tag) != 1 {
+		return nil, errOpen
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	if inexactOverlap(out, ciphertext) {
+		panic("sio: invalid buffer overlap")
+	}
+	c.xorKeyStream(out, nonce, ciphertext)
+	return ret, nil
+}
+
+func (c *aesCTRHMAC) xorKeyStream(dst, nonce, src []byte) {
+	var iv [aes.BlockSize]byte
+	copy(iv[:], nonce)
+	cipher.NewCTR(c.block, iv[:]).XORKeyStream(dst, src)
+}
+
+// tag appends the HMAC-SHA-256 of the nonce, associatedData and
+// ciphertext to dst. The lengths of the associated data and of
+// the ciphertext are appended as 64 bit big-endian integers such
+// that the MAC input cannot be split ambiguously.
+func (c *aesCTRHMAC) tag(dst, nonce, ciphertext, associatedData []byte) []byte {
+	var lengths [16]byte
+	binary.BigEndian.PutUint64(lengths[:8], uint64(len(associatedData)))
+	binary.BigEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+
+	h := hmac.New(sha256.New, c.macKey)
+	h.Write(nonce)
+	h.Write(associatedData)
+	h.Write(ciphertext)
+	h.Write(lengths[:])
+	return h.Sum(dst)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"bytes"
+	"encoding/hex"
+	mrand "math/rand"
+	"testing"
+)
+
+func TestAESCTRHMACVectors(t *testing.T) {
+	for i, test := range ctrHMACTests {
+		key, _ := hex.DecodeString(test.Key)
+		nonce, _ := hex.DecodeString(test.Nonce)
+		plaintext, _ := hex.DecodeString(test.Plaintext)
+		associatedData, _ := hex.DecodeString(test.AssociatedData)
+		ciphertext, _ := hex.DecodeString(test.Ciphertext)
+
+		c, err := newAESCTRHMAC(key)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create AES-CTR-HMAC: %v", i, err)
+		}
+		if sealed := c.Seal(nil, nonce, plaintext, associatedData); !bytes.Equal(sealed, ciphertext) {
+			t.Fatalf("Test %d: ciphertext mismatch: got %x - want %x", i, sealed, ciphertext)
+		}
+		opened, err := c.Open(nil, nonce, ciphertext, associatedData)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to open ciphertext: %v", i, err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Test %d: plaintext mismatch: got %x - want %x", i, opened, plaintext)
+		}
+	}
+}
+
+func TestAESCTRHMAC(t *testing.T) {
+	for i := 0; i < 64; i++ {
+		key, nonce := random(32), random(ctrHMACNonceSize)
+		plaintext, associatedData := randomN(1024), randomN(64)
+
+		c, err := newAESCTRHMAC(key)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create AES-CTR-HMAC: %v", i, err)
+		}
+		ciphertext := c.Seal(nil, nonce, plaintext, associatedData)
+
+		// Seal and Open must work in-place.
+		buffer := make([]byte, len(plaintext), len(plaintext)+c.Overhead())
+		copy(buffer, plaintext)
+		if sealed := c.Seal(buffer[:0], nonce, buffer, associatedData); !bytes.Equal(sealed, ciphertext) {
+			t.Fatalf("Test %d: in-place Seal does not match ciphertext", i)
+		}
+		opened, err := c.Open(buffer[:0], nonce, buffer[:len(ciphertext)], associatedData)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to open ciphertext in-place: %v", i, err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Test %d: plaintext does not match original plaintext", i)
+		}
+
+		ciphertext[mrand.Intn(len(ciphertext))] ^= 1
+		if _, err = c.Open(nil, nonce, ciphertext, associatedData); err == nil {
+			t.Fatalf("Test %d: modified ciphertext is authentic", i)
+		}
+	}
+}
+
+func TestAESCTRHMACKeySize(t *testing.T) {
+	for _, size := range []int{0, 16, 24, 31, 33, 64} {
+		if _, err := newAESCTRHMAC(make([]byte, size)); err == nil {
+			t.Fatalf("Created AES-CTR-HMAC with %d byte key", size)
+		}
+	}
+}
+
+// ctrHMACTests are known-answer tests for AES-256-CTR-HMAC-SHA256.
+// They have been computed independently of this package using
+// OpenSSL (AES-256-CTR) and Python (HKDF-SHA-256, HMAC-SHA-256).
+var ctrHMACTests = []struct {
+	Key, Nonce, Plaintext, AssociatedData, Ciphertext string
+}{
+	{
+		Key:            "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		Nonce:          "202122232425262728292a2b",
+		Plaintext:      "",
+		AssociatedData: "",
+		Ciphertext:     "389560bd4dd48be02ea0fa4a39055402c220bbde5af4a423632231de9c7f936b",
+	},
+	{
+		Key:            "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		Nonce:          "202122232425262728292a2b",
+		Plaintext:      "",
+		AssociatedData: "404142434445464748494a4b4c",
+		Ciphertext:     "4b32f278a533f5c3b9864db5f5544f9ccd6fe15ffd40eb3c21ff387398a997c6",
+	},
+	{
+		Key:            "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+		Nonce:          "000000000000000000000000",
+		Plaintext:      "000102030405060708090a0b0c0d0e0f",
+		AssociatedData: "",
+		Ciphertext:     "8218a76b1d088a4c76ed61f26923c017155fce92ff25180bf7df9108f531fda418c3879273b37eb6f13dbe6329019512",
+	},
+	{
+		Key:            "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+		Nonce:          "303132333435363738393a3b",
+		Plaintext:      "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f404142",
+		AssociatedData: "404142434445464748494a4b4c",
+		Ciphertext:     "5909dc5a192b5767f4ef8b377f8bd36aa22f47c17f21936917f15135ca1d66bfa4234ebdf02cb09b9e4da5ef6d7470114eab07d2bb4a73cef4fe2e7c8cc44ff492a6f303ec17998f793867cfcfef3ced1f77e5939a6403141104d5daf9d6119d628a59",
+	},
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import "crypto/fips140"
+
+// fipsEnabled reports whether the program runs in
+// FIPS 140-3 mode. It is a variable such that tests
+// can enable the FIPS 140-3 checks.
+var fipsEnabled = fips140.Enabled
+
+// FIPSApproved reports whether the algorithm only uses
+// FIPS 140-3 approved cryptographic primitives and
+// constructions. In FIPS 140-3 mode, Algorithm.Stream
+// returns ErrNotFIPSApproved for all other algorithms and
+// NewStream panics since it cannot verify arbitrary ciphers.
+//
+// The FIPS 140-3 approved algorithms are AES_128_GCM,
+// AES_256_GCM and AES_256_CTR_HMAC_SHA256. Custom algorithms
+// registered via RegisterAlgorithm are never approved.
+//
+// Streams use a deterministic nonce construction - a fixed
+// nonce followed by a fragment counter - as described by
+// NIST SP 800-38D, Section 8.2.1. However, when FIPS 140-3 mode
+// is enforced strictly (GODEBUG=fips140=only) the Go standard
+// library refuses to use AES-GCM with externally generated nonces.
+// Then, AES_256_CTR_HMAC_SHA256 is the only algorithm available.
+func (a Algorithm) FIPSApproved() bool {
+	alg, ok := lookupAlgorithm(a)
+	return ok && alg.fipsApproved
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import "testing"
+
+func TestFIPSApproved(t *testing.T) {
+	approved := map[Algorithm]bool{
+		AES_128_GCM:             true,
+		AES_256_GCM:             true,
+		AES_256_CTR_HMAC_SHA256: true,
+	}
+	for _, a := range testAlgorithms() {
+		if a.FIPSApproved() != approved[a] {
+			t.Fatalf("%s: got FIPSApproved() = %v - want %v", a, a.FIPSApproved(), approved[a])
+		}
+	}
+	if AES_512_GCM.FIPSApproved() {
+		t.Fatalf("%s: unknown algorithm is FIPS 140-3 approved", AES_512_GCM)
+	}
+}
+
+func TestFIPSMode(t *testing.T) {
+	defer func(f func() bool) { fipsEnabled = f }(fipsEnabled)
+	fipsEnabled = func() bool { return true }
+
+	for _, a := range testAlgorithms() {
+		_, err := a.Stream(make([]byte, a.KeySize()))
+		if a.FIPSApproved() && err != nil {
+			t.Fatalf("%s: Failed to create Stream in FIPS 140-3 mode: %v", a, err)
+		}
+		if !a.FIPSApproved() && err != ErrNotFIPSApproved {
+			t.Fatalf("%s: got %v - want %v", a, err, ErrNotFIPSApproved)
+		}
+	}
+
+	gcm, err := newAESGCM(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("Failed to create AES-GCM: %v", err)
+	}
+	defer func() {
+		if err := recover(); err != ErrNotFIPSApproved {
+			t.Fatalf("NewStream: got panic %v - want %v", err, ErrNotFIPSApproved)
+		}
+	}()
+	NewStream(gcm, BufSize)
+}
@@ -51,7 +51,7 @@ func copyBytes(dst io.ByteWriter, src io.ByteReader) error {
 	}
 }
 
-func loadTestVectors(filename string) []TestVector {
+func loadTestVectors(filename string) (testVectors, blockNumTestVectors []TestVector) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		panic(err)
@@ -60,6 +60,7 @@ func loadTestVectors(filename string) []TestVector {
 	var vec []struct {
 		Algorithm      Algorithm
 		BufSize        int
+		BlockNum       uint32
 		Key            string
 		Nonce          string
 		AssociatedData string
@@ -70,8 +71,7 @@ func loadTestVectors(filename string) []TestVector {
 		panic(err)
 	}
 
-	testVectors := make([]TestVector, len(vec))
-	for i, v := range vec {
+	for _, v := range vec {
 		key, err := hex.DecodeString(v.Key)
 		if err != nil {
 			panic(err)
@@ -92,15 +92,21 @@ func loadTestVectors(filename string) []TestVector {
 		if err != nil {
 			panic(err)
 		}
-		testVectors[i] = TestVector{
+		testVector := TestVector{
 			Algorithm:      v.Algorithm,
 			BufSize:        v.BufSize,
+			BlockNum:       v.BlockNum,
 			Key:            key,
 			Nonce:          nonce,
 			AssociatedData: associatedData,
 			Plaintext:      plaintext,
 			Ciphertext:     ciphertext,
 		}
+		if v.BlockNum != 0 {
+			blockNumTestVectors = append(blockNumTestVectors, testVector)
+		} else {
+			testVectors = append(testVectors, testVector)
+		}
 	}
-	return testVectors
+	return testVectors, blockNumTestVectors
 }
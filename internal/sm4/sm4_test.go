@@ -0,0 +1,99 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sm4
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+)
+
+var blockTests = []struct {
+	Key, Plaintext, Ciphertext string
+	Iterations                 int
+}{
+	{ // GB/T 32907-2016, Appendix A.1
+		Key:        "0123456789abcdeffedcba9876543210",
+		Plaintext:  "0123456789abcdeffedcba9876543210",
+		Ciphertext: "681edf34d206965e86b3e94f536e4246",
+		Iterations: 1,
+	},
+	{ // GB/T 32907-2016, Appendix A.2
+		Key:        "0123456789abcdeffedcba9876543210",
+		Plaintext:  "0123456789abcdeffedcba9876543210",
+		Ciphertext: "595298c7c6fd271f0402f804c33d3f66",
+		Iterations: 1000000,
+	},
+}
+
+func TestBlock(t *testing.T) {
+	for i, test := range blockTests {
+		if test.Iterations > 1 && testing.Short() {
+			continue
+		}
+		key, _ := hex.DecodeString(test.Key)
+		plaintext, _ := hex.DecodeString(test.Plaintext)
+		ciphertext, _ := hex.DecodeString(test.Ciphertext)
+
+		block, err := NewCipher(key)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create SM4 cipher: %v", i, err)
+		}
+		buffer := make([]byte, BlockSize)
+		copy(buffer, plaintext)
+		for j := 0; j < test.Iterations; j++ {
+			block.Encrypt(buffer, buffer)
+		}
+		if !bytes.Equal(buffer, ciphertext) {
+			t.Fatalf("Test %d: ciphertext mismatch: got %x - want %x", i, buffer, ciphertext)
+		}
+		for j := 0; j < test.Iterations; j++ {
+			block.Decrypt(buffer, buffer)
+		}
+		if !bytes.Equal(buffer, plaintext) {
+			t.Fatalf("Test %d: plaintext mismatch: got %x - want %x", i, buffer, plaintext)
+		}
+	}
+}
+
+func TestGCM(t *testing.T) {
+	// RFC 8998, Appendix A.1
+	key, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+	nonce, _ := hex.DecodeString("00001234567800000000abcd")
+	associatedData, _ := hex.DecodeString("feedfacedeadbeeffeedfacedeadbeefabaddad2")
+	plaintext, _ := hex.DecodeString("aaaaaaaaaaaaaaaabbbbbbbbbbbbbbbbccccccccccccccccddddddddddddddddeeeeeeeeeeeeeeeeffffffffffffffffeeeeeeeeeeeeeeeeaaaaaaaaaaaaaaaa")
+	ciphertext, _ := hex.DecodeString("17f399f08c67d5ee19d0dc9969c4bb7d5fd46fd3756489069157b282bb200735d82710ca5c22f0ccfa7cbf93d496ac15a56834cbcf98c397b4024a2691233b8d" + "83de3541e4c2b58177e065a9bf7b62ec")
+
+	block, err := NewCipher(key)
+	if err != nil {
+		t.Fatalf("Failed to create SM4 cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("Failed to create SM4-GCM: %v", err)
+	}
+	if sealed := gcm.Seal(nil, nonce, plaintext, associatedData); !bytes.Equal(sealed, ciphertext) {
+		t.Fatalf("ciphertext mismatch: got %x - want %x", sealed, ciphertext)
+	}
+}
+
+func TestSBox(t *testing.T) {
+	var seen [256]bool
+	for _, b := range sbox {
+		if seen[b] {
+			t.Fatalf("S-box is not a permutation: %#x appears twice", b)
+		}
+		seen[b] = true
+	}
+}
+
+func TestKeySize(t *testing.T) {
+	for _, size := range []int{0, 8, 15, 17, 24, 32} {
+		if _, err := NewCipher(make([]byte, size)); err == nil {
+			t.Fatalf("Created SM4 cipher with %d byte key", size)
+		}
+	}
+}
@@ -95,6 +95,9 @@ func (r *EncReader) read(p []byte) (n int, err error) {
 			return n, err
 		}
 		p = p[n:]
+		if len(p) == 0 { // Don't encrypt the next fragment into the ciphertextBuffer without returning it
+			return n, nil
+		}
 	}
 	if r.offset > 0 {
 		nn := copy(p, r.ciphertextBuffer[r.offset:])
@@ -343,6 +346,9 @@ func (r *DecReader) read(p []byte) (n int, err error) {
 			return n, err
 		}
 		p = p[n:]
+		if len(p) == 0 { // Don't decrypt the next fragment into the plaintextBuffer without returning it
+			return n, nil
+		}
 	}
 	if r.offset > 0 {
 		nn := copy(p, r.plaintextBuffer[r.offset:])
@@ -578,7 +584,7 @@ func (r *DecReaderAt) ReadAt(p []byte, offset int64) (int, error) {
 		return 0, errorType("sio: DecReaderAt.ReadAt: offset is negative")
 	}
 
-	t := offset / int64(r.bufSize)
+	t, k := offset/int64(r.bufSize), offset%int64(r.bufSize)
 	if t+1 > math.MaxUint32 {
 		return 0, ErrExceeded
 	}
@@ -586,6 +592,16 @@ func (r *DecReaderAt) ReadAt(p []byte, offset int64) (int, error) {
 	buffer := r.bufPool.Get().(*[]byte)
 	defer r.bufPool.Put(buffer)
 
+	if t > 0 && k == 0 {
+		// There is no fragment at the offset if the previous
+		// fragment is the final one. Then, start at the previous
+		// fragment such that it gets verified and ReadAt returns
+		// io.EOF.
+		if n, _ := r.r.ReadAt((*buffer)[:1], t*int64(r.bufSize+r.cipher.Overhead())); n == 0 {
+			t, k = t-1, int64(r.bufSize)
+		}
+	}
+
 	decReader := DecReader{
 		r:              &sectionReader{r: r.r, off: t * int64(r.bufSize+r.cipher.Overhead())},
 		cipher:         r.cipher,
@@ -599,7 +615,7 @@ func (r *DecReaderAt) ReadAt(p []byte, offset int64) (int, error) {
 	copy(decReader.nonce, r.nonce)
 	copy(decReader.associatedData, r.associatedData)
 
-	if k := offset % int64(r.bufSize); k > 0 {
+	if k > 0 {
 		if _, err := io.CopyN(io.Discard, &decReader, k); err != nil {
 			return 0, err
 		}
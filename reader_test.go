@@ -438,3 +438,60 @@ func TestSimpleReadAtSection(t *testing.T) {
 		}
 	}
 }
+
+func TestReadFragmentBoundary(t *testing.T) {
+	const bufSize = 16
+	stream, err := AES_128_GCM.streamWithBufSize(make([]byte, 16), bufSize)
+	if err != nil {
+		t.Fatalf("Failed to create new Stream: %v", err)
+	}
+	nonce := make([]byte, stream.NonceSize())
+
+	for _, size := range []int{bufSize, bufSize + 1, 2 * bufSize, 3*bufSize + 1} {
+		plaintext := random(size)
+		ciphertext := bytes.NewBuffer(nil)
+		ew := stream.EncryptWriter(ciphertext, nonce, nil)
+		if _, err = ew.Write(plaintext); err != nil {
+			t.Fatalf("Size %d: Failed to encrypt plaintext: %v", size, err)
+		}
+		if err = ew.Close(); err != nil {
+			t.Fatalf("Size %d: Failed to close EncWriter: %v", size, err)
+		}
+
+		// Read exactly one fragment at a time.
+		var decrypted []byte
+		dr := stream.DecryptReader(bytes.NewReader(ciphertext.Bytes()), nonce, nil)
+		for {
+			p := make([]byte, bufSize)
+			n, err := dr.Read(p)
+			decrypted = append(decrypted, p[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Size %d: Failed to decrypt ciphertext: %v", size, err)
+			}
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("Size %d: plaintext does not match original plaintext", size)
+		}
+
+		// Reading at the end of the stream must return io.EOF.
+		dra := stream.DecryptReaderAt(bytes.NewReader(ciphertext.Bytes()), nonce, nil)
+		if n, err := dra.ReadAt(make([]byte, 1), int64(size)); n != 0 || err != io.EOF {
+			t.Fatalf("Size %d: got %d bytes and error %v - want 0 bytes and %v", size, n, err, io.EOF)
+		}
+
+		// Reading at a fragment boundary within the stream
+		// must start at the fragment at the offset.
+		for offset := bufSize; offset < size; offset += bufSize {
+			p := make([]byte, 1)
+			if _, err := dra.ReadAt(p, int64(offset)); err != nil && err != io.EOF {
+				t.Fatalf("Size %d: Failed to read at offset %d: %v", size, offset, err)
+			}
+			if p[0] != plaintext[offset] {
+				t.Fatalf("Size %d: plaintext at offset %d does not match original plaintext", size, offset)
+			}
+		}
+	}
+}
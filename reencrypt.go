@@ -0,0 +1,306 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// A Reencrypter decrypts a data stream and encrypts the
+// plaintext again - e.g. under a new key, nonce, algorithm
+// or buffer size. The plaintext is never exposed to the caller.
+//
+// The From Stream, nonce and associated data must match the
+// values used when encrypting the data stream. The To nonce
+// must be unique for the key of the To Stream.
+type Reencrypter struct {
+	From               *Stream
+	FromNonce          []byte
+	FromAssociatedData []byte
+
+	To               *Stream
+	ToNonce          []byte
+	ToAssociatedData []byte
+
+	// Parallelism is the max. number of goroutines that
+	// decrypt resp. encrypt fragments concurrently. If
+	// Parallelism <= 1, the data stream is re-encrypted
+	// sequentially.
+	//
+	// Re-encrypting in parallel requires memory for
+	// Parallelism fragments of the From Stream and the
+	// corresponding fragments of the To Stream. Further,
+	// the AEADs of both Streams must be safe for concurrent
+	// use - as the AEADs of all Algorithms are.
+	Parallelism int
+}
+
+// Reencrypt reads the encrypted data stream from src and writes
+// the re-encrypted data stream to dst. It returns the number of
+// plaintext bytes re-encrypted and the first error encountered,
+// if any. Reencrypt does not close dst.
+//
+// Reencrypt uses a bounded amount of memory, independent of the
+// size of the data stream. It writes the final fragment of the
+// re-encrypted data stream only after verifying the entire data
+// stream read from src - including its final fragment. If the
+// data stream read from src is not authentic, Reencrypt returns
+// NotAuthentic and dst does not contain a complete data stream.
+// If the re-encrypted data stream would be too large for the To
+// Stream, Reencrypt returns ErrExceeded.
+func (r *Reencrypter) Reencrypt(dst io.Writer, src io.Reader) (int64, error) {
+	if len(r.FromNonce) != r.From.NonceSize() || len(r.ToNonce) != r.To.NonceSize() {
+		panic("sio: nonce has invalid length")
+	}
+	if r.Parallelism <= 1 {
+		return r.reencrypt(dst, src)
+	}
+	return r.reencryptParallel(dst, src)
+}
+
+func (r *Reencrypter) reencrypt(dst io.Writer, src io.Reader) (int64, error) {
+	// The EncWriter must not close dst.
+	w := r.To.EncryptWriter(struct{ io.Writer }{dst}, r.ToNonce, r.ToAssociatedData)
+
+	// The DecReader only returns authentic plaintext and returns
+	// io.EOF only after verifying the final fragment. Therefore,
+	// closing the EncWriter after a successful WriteTo writes the
+	// final fragment only if the entire data stream is authentic.
+	n, err := r.From.DecryptReader(src, r.FromNonce, r.FromAssociatedData).WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+	return n, w.Close()
+}
+
+func (r *Reencrypter) reencryptParallel(dst io.Writer, src io.Reader) (int64, error) {
+	var (
+		from = newFragmentCipher(r.From, r.FromNonce, r.FromAssociatedData)
+		to   = newFragmentCipher(r.To, r.ToNonce, r.ToAssociatedData)
+
+		fromBufSize  = r.From.bufSize
+		fromOverhead = r.From.cipher.Overhead()
+		fromSize     = fromBufSize + fromOverhead
+		toBufSize    = r.To.bufSize
+		toOverhead   = r.To.cipher.Overhead()
+	)
+
+	// The ciphertext contains up to Parallelism fragments and one
+	// additional byte to detect whether the last fragment is the
+	// final one. The plaintext contains the decrypted fragments
+	// and up to one buffer of the To Stream that has not been
+	// encrypted yet.
+	ciphertext := make([]byte, r.Parallelism*fromSize+1)
+	plaintext := make([]byte, 0, r.Parallelism*fromBufSize+toBufSize)
+	output := make([]byte, 0, (cap(plaintext)/toBufSize+1)*(toBufSize+toOverhead))
+
+	var (
+		fromSeqNum uint64 = 1
+		toSeqNum   uint64 = 1
+		carry      int
+		n          int64
+	)
+	for {
+		m, err := readFrom(src, ciphertext[carry:])
+		m += carry
+		final := err == io.EOF
+		if err != nil && !final {
+			return n, err
+		}
+		if !final {
+			m = len(ciphertext) - 1
+		}
+
+		// Decrypt all fragments of the ciphertext. If it is the final
+		// ciphertext then its last fragment is the final fragment.
+		fragments := (m + fromSize - 1) / fromSize
+		if final && (m == 0 || (m-1)%fromSize+1 < fromOverhead) {
+			return n, NotAuthentic
+		}
+		if fromSeqNum+uint64(fragments)-1 > math.MaxUint32 {
+			return n, ErrExceeded
+		}
+		offset := len(plaintext)
+		plaintext = plaintext[:offset+m-fragments*fromOverhead]
+		err = parallelize(r.Parallelism, fragments, func(i, j int) error {
+			c := from.clone()
+			for ; i < j; i++ {
+				fragment := ciphertext[i*fromSize : min((i+1)*fromSize, m)]
+				dst := plaintext[offset+i*fromBufSize : offset+i*fromBufSize+len(fragment)-fromOverhead]
+				p, err := c.open(dst[:0], fragment, uint32(fromSeqNum)+uint32(i), final && i == fragments-1)
+				if err != nil {
+					return NotAuthentic
+				}
+				copy(dst, p) // The AEAD may not decrypt into dst
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		fromSeqNum += uint64(fragments)
+		if !final {
+			ciphertext[0], carry = ciphertext[m], 1
+		}
+
+		// Encrypt all complete buffers of the To Stream except
+		// the last one - which may be the final fragment.
+		k := 0
+		if len(plaintext) > 0 {
+			k = (len(plaintext) - 1) / toBufSize
+		}
+		if toSeqNum+uint64(k) > math.MaxUint32 {
+			return n, ErrExceeded
+		}
+		output = output[:k*(toBufSize+toOverhead)]
+		err = parallelize(r.Parallelism, k, func(i, j int) error {
+			c := to.clone()
+			for ; i < j; i++ {
+				dst := output[i*(toBufSize+toOverhead) : (i+1)*(toBufSize+toOverhead)]
+				copy(dst, c.seal(dst[:0], plaintext[i*toBufSize:(i+1)*toBufSize], uint32(toSeqNum)+uint32(i), false)) // The AEAD may not encrypt into dst
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		toSeqNum += uint64(k)
+		if _, err = writeTo(dst, output); err != nil {
+			return n, err
+		}
+		n += int64(k * toBufSize)
+		plaintext = plaintext[:copy(plaintext, plaintext[k*toBufSize:])]
+
+		if final {
+			output = to.seal(output[:0], plaintext, uint32(toSeqNum), true)
+			if _, err = writeTo(dst, output); err != nil {
+				return n, err
+			}
+			return n + int64(len(plaintext)), nil
+		}
+	}
+}
+
+// fragmentCipher en/decrypts individual fragments
+// of a data stream.
+type fragmentCipher struct {
+	cipher         cipher.AEAD
+	nonce          []byte
+	associatedData []byte
+}
+
+func newFragmentCipher(s *Stream, nonce, associatedData []byte) *fragmentCipher {
+	c := &fragmentCipher{
+		cipher:         s.cipher,
+		nonce:          make([]byte, s.cipher.NonceSize()),
+		associatedData: make([]byte, 1+s.cipher.Overhead()),
+	}
+	copy(c.nonce, nonce)
+	copy(c.associatedData[1:], c.cipher.Seal(c.associatedData[1:1], c.nonce, nil, associatedData))
+	return c
+}
+
+// clone returns a copy of c that can be used
+// concurrently to c.
+func (c *fragmentCipher) clone() *fragmentCipher {
+	return &fragmentCipher{
+		cipher:         c.cipher,
+		nonce:          append([]byte(nil), c.nonce...),
+		associatedData: append([]byte(nil), c.associatedData...),
+	}
+}
+
+func (c *fragmentCipher) seal(dst, plaintext []byte, seqNum uint32, final bool) []byte {
+	c.setFragment(seqNum, final)
+	return c.cipher.Seal(dst, c.nonce, plaintext, c.associatedData)
+}
+
+func (c *fragmentCipher) open(dst, ciphertext []byte, seqNum uint32, final bool) ([]byte, error) {
+	c.setFragment(seqNum, final)
+	return c.cipher.Open(dst, c.nonce, ciphertext, c.associatedData)
+}
+
+func (c *fragmentCipher) setFragment(seqNum uint32, final bool) {
+	binary.LittleEndian.PutUint32(c.nonce[len(c.nonce)-4:], seqNum)
+	c.associatedData[0] = 0x00
+	if final {
+		c.associatedData[0] = 0x80
+	}
+}
+
+// parallelize splits the range [0, n) into at most p
+// sub-ranges and calls f for each sub-range [i, j)
+// concurrently. It returns the first error returned
+// by f, if any.
+func parallelize(p, n int, f func(i, j int) error) error {
+	if n == 0 {
+		return nil
+	}
+	p = min(p, n)
+	if p == 1 {
+		return f(0, n)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for k := 0; k < p; k++ {
+		wg.Add(1)
+		go func(i, j int) {
+			defer wg.Done()
+			if err := f(i, j); err != nil {
+				errOnce.Do(func() { firstErr = err })
+			}
+		}(k*n/p, (k+1)*n/p)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// Transcode reads a data stream encrypted with s, the nonce and the
+// associatedData from src and writes an equivalent data stream that
+// uses the buffer size bufSize and a new, randomly generated, nonce
+// to dst. It returns the number of plaintext bytes transcoded, the
+// new nonce and the first error encountered, if any. Transcode does
+// not close dst.
+//
+// The transcoded data stream is encrypted with the same AEAD and
+// key as s. Therefore, Transcode generates the new nonce using
+// crypto/rand such that it is unique for the key. Decrypting the
+// transcoded data stream requires a Stream with the same key and
+// the buffer size bufSize, the new nonce and the associatedData.
+//
+// Transcode processes the data stream in a single pass and holds
+// at most one fragment of the original and one fragment of the
+// transcoded data stream in memory. As Reencrypt, it writes the
+// final fragment only if the entire data stream read from src
+// is authentic.
+func (s *Stream) Transcode(dst io.Writer, src io.Reader, nonce, associatedData []byte, bufSize int) (int64, []byte, error) {
+	if bufSize <= 0 || bufSize > MaxBufSize {
+		return 0, nil, errorType("sio: invalid buffer size " + strconv.Itoa(bufSize))
+	}
+	newNonce := make([]byte, s.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, newNonce); err != nil {
+		return 0, nil, err
+	}
+	r := Reencrypter{
+		From:               s,
+		FromNonce:          nonce,
+		FromAssociatedData: associatedData,
+		To:                 &Stream{cipher: s.cipher, bufSize: bufSize},
+		ToNonce:            newNonce,
+		ToAssociatedData:   associatedData,
+	}
+	n, err := r.Reencrypt(dst, src)
+	return n, newNonce, err
+}
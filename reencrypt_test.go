@@ -0,0 +1,261 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"testing"
+)
+
+var reencryptTests = []struct {
+	From, To               Algorithm
+	FromBufSize, ToBufSize int
+	Size                   int
+}{
+	{From: AES_128_GCM, To: AES_256_GCM, FromBufSize: BufSize, ToBufSize: BufSize, Size: 0},                    // 0
+	{From: AES_128_GCM, To: AES_256_GCM, FromBufSize: BufSize, ToBufSize: BufSize, Size: 1},                    // 1
+	{From: AES_128_GCM, To: AES_256_GCM, FromBufSize: BufSize, ToBufSize: BufSize, Size: 3*BufSize + 5},        // 2
+	{From: AES_128_GCM, To: ChaCha20Poly1305, FromBufSize: 64, ToBufSize: 64, Size: 64},                        // 3
+	{From: AES_128_GCM, To: ChaCha20Poly1305, FromBufSize: 64, ToBufSize: 64, Size: 64 * 17},                   // 4
+	{From: ChaCha20Poly1305, To: XChaCha20Poly1305, FromBufSize: 64, ToBufSize: 17, Size: 1000},                // 5
+	{From: XChaCha20Poly1305, To: AES_128_GCM, FromBufSize: 17, ToBufSize: 64, Size: 1000},                     // 6
+	{From: AES_256_GCM, To: AES_256_CTR_HMAC_SHA256, FromBufSize: 1, ToBufSize: 33, Size: 333},                 // 7
+	{From: AES_256_CTR_HMAC_SHA256, To: AES_SIV_CMAC_256, FromBufSize: 33, ToBufSize: 1, Size: 333},            // 8
+	{From: AES_SIV_CMAC_512, To: XChaCha20Poly1305_AES_256_GCM, FromBufSize: 100, ToBufSize: 1000, Size: 5555}, // 9
+	{From: AES_128_GCM, To: AES_128_GCM, FromBufSize: BufSize, ToBufSize: 1 << 20, Size: 2<<20 + 1},            // 10
+	{From: AES_128_GCM, To: AES_128_GCM, FromBufSize: 1 << 20, ToBufSize: BufSize, Size: 2 << 20},              // 11
+}
+
+func TestReencrypt(t *testing.T) {
+	for i, test := range reencryptTests {
+		from, fromNonce, fromAD := newReencryptStream(t, test.From, test.FromBufSize)
+		to, toNonce, toAD := newReencryptStream(t, test.To, test.ToBufSize)
+
+		plaintext := random(test.Size)
+		ciphertext := encryptBytes(t, from, fromNonce, fromAD, plaintext)
+		expected := encryptBytes(t, to, toNonce, toAD, plaintext)
+
+		for _, parallelism := range []int{0, 1, 2, 3, 8} {
+			r := &Reencrypter{
+				From:               from,
+				FromNonce:          fromNonce,
+				FromAssociatedData: fromAD,
+				To:                 to,
+				ToNonce:            toNonce,
+				ToAssociatedData:   toAD,
+				Parallelism:        parallelism,
+			}
+			var output bytes.Buffer
+			n, err := r.Reencrypt(&output, bytes.NewReader(ciphertext))
+			if err != nil {
+				t.Fatalf("Test %d: Parallelism %d: Failed to re-encrypt data stream: %v", i, parallelism, err)
+			}
+			if n != int64(len(plaintext)) {
+				t.Fatalf("Test %d: Parallelism %d: Re-encrypted %d bytes - want %d", i, parallelism, n, len(plaintext))
+			}
+			if !bytes.Equal(output.Bytes(), expected) {
+				t.Fatalf("Test %d: Parallelism %d: Re-encrypted data stream does not match the expected data stream", i, parallelism)
+			}
+		}
+	}
+}
+
+func TestReencryptNotAuthentic(t *testing.T) {
+	from, fromNonce, fromAD := newReencryptStream(t, AES_128_GCM, 64)
+	to, toNonce, toAD := newReencryptStream(t, AES_128_GCM, 64)
+	ciphertext := encryptBytes(t, from, fromNonce, fromAD, random(64*10))
+
+	fragmentSize := 64 + from.cipher.Overhead()
+	modifications := map[string][]byte{
+		"empty":                   nil,
+		"truncated tag":           ciphertext[:from.cipher.Overhead()-1],
+		"truncated":               ciphertext[:len(ciphertext)-1],
+		"final fragment removed":  ciphertext[:len(ciphertext)-fragmentSize],
+		"first fragment modified": append([]byte{ciphertext[0] ^ 1}, ciphertext[1:]...),
+		"final fragment modified": append(append([]byte(nil), ciphertext[:len(ciphertext)-1]...), ciphertext[len(ciphertext)-1]^1),
+		"appended":                append(append([]byte(nil), ciphertext...), 0),
+	}
+	for name, modified := range modifications {
+		for _, parallelism := range []int{1, 2, 4} {
+			r := &Reencrypter{
+				From:               from,
+				FromNonce:          fromNonce,
+				FromAssociatedData: fromAD,
+				To:                 to,
+				ToNonce:            toNonce,
+				ToAssociatedData:   toAD,
+				Parallelism:        parallelism,
+			}
+			var output bytes.Buffer
+			if _, err := r.Reencrypt(&output, bytes.NewReader(modified)); err != NotAuthentic {
+				t.Fatalf("%s: Parallelism %d: Re-encryption should fail with NotAuthentic - got: %v", name, parallelism, err)
+			}
+
+			// The output must not be a complete data stream.
+			if _, err := to.DecryptReader(&output, toNonce, toAD).WriteTo(DevNull); err != NotAuthentic {
+				t.Fatalf("%s: Parallelism %d: Partial output is authentic", name, parallelism)
+			}
+		}
+	}
+}
+
+func TestReencryptDoesNotClose(t *testing.T) {
+	from, fromNonce, fromAD := newReencryptStream(t, AES_128_GCM, BufSize)
+	to, toNonce, toAD := newReencryptStream(t, AES_256_GCM, BufSize)
+	ciphertext := encryptBytes(t, from, fromNonce, fromAD, random(100))
+
+	r := &Reencrypter{
+		From:               from,
+		FromNonce:          fromNonce,
+		FromAssociatedData: fromAD,
+		To:                 to,
+		ToNonce:            toNonce,
+		ToAssociatedData:   toAD,
+	}
+	var output closeRecorder
+	if _, err := r.Reencrypt(&output, bytes.NewReader(ciphertext)); err != nil {
+		t.Fatalf("Failed to re-encrypt data stream: %v", err)
+	}
+	if output.closed {
+		t.Fatal("Reencrypt closed the underlying io.Writer")
+	}
+}
+
+func TestReencryptAllocatingAEAD(t *testing.T) {
+	from, fromNonce, fromAD := newReencryptStream(t, AES_128_GCM, 64)
+	to, toNonce, toAD := newReencryptStream(t, AES_256_GCM, 17)
+
+	plaintext := random(64*10 + 1)
+	ciphertext := encryptBytes(t, from, fromNonce, fromAD, plaintext)
+	expected := encryptBytes(t, to, toNonce, toAD, plaintext)
+
+	// The AEADs do not en/decrypt into the provided dst slice.
+	from = NewStream(allocatingAEAD{from.cipher}, from.bufSize)
+	to = NewStream(allocatingAEAD{to.cipher}, to.bufSize)
+	for _, parallelism := range []int{2, 4} {
+		r := &Reencrypter{
+			From:               from,
+			FromNonce:          fromNonce,
+			FromAssociatedData: fromAD,
+			To:                 to,
+			ToNonce:            toNonce,
+			ToAssociatedData:   toAD,
+			Parallelism:        parallelism,
+		}
+		var output bytes.Buffer
+		if _, err := r.Reencrypt(&output, bytes.NewReader(ciphertext)); err != nil {
+			t.Fatalf("Parallelism %d: Failed to re-encrypt data stream: %v", parallelism, err)
+		}
+		if !bytes.Equal(output.Bytes(), expected) {
+			t.Fatalf("Parallelism %d: Re-encrypted data stream does not match the expected data stream", parallelism)
+		}
+	}
+}
+
+// allocatingAEAD is a cipher.AEAD that always
+// returns a newly allocated slice.
+type allocatingAEAD struct{ cipher.AEAD }
+
+func (a allocatingAEAD) Seal(dst, nonce, plaintext, associatedData []byte) []byte {
+	return append(dst[:len(dst):len(dst)], a.AEAD.Seal(nil, nonce, plaintext, associatedData)...)
+}
+
+func (a allocatingAEAD) Open(dst, nonce, ciphertext, associatedData []byte) ([]byte, error) {
+	plaintext, err := a.AEAD.Open(nil, nonce, ciphertext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst[:len(dst):len(dst)], plaintext...), nil
+}
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error { c.closed = true; return nil }
+
+func newReencryptStream(t *testing.T, a Algorithm, bufSize int) (*Stream, []byte, []byte) {
+	stream, err := a.StreamWithBufSize(random(a.KeySize()), bufSize)
+	if err != nil {
+		t.Fatalf("Failed to create new Stream: %v", err)
+	}
+	return stream, random(stream.NonceSize()), randomN(32)
+}
+
+func encryptBytes(t *testing.T, stream *Stream, nonce, associatedData, plaintext []byte) []byte {
+	var ciphertext bytes.Buffer
+	w := stream.EncryptWriter(&ciphertext, nonce, associatedData)
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("Failed to encrypt plaintext: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close EncWriter: %v", err)
+	}
+	return ciphertext.Bytes()
+}
+
+func TestTranscode(t *testing.T) {
+	for i, bufSize := range []int{1, 17, BufSize, 1 << 20} {
+		key := random(AES_128_GCM.KeySize())
+		stream, err := AES_128_GCM.Stream(key)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create new Stream: %v", i, err)
+		}
+		nonce, associatedData := random(stream.NonceSize()), random(32)
+
+		plaintext := random(3*BufSize + 1)
+		ciphertext := encryptBytes(t, stream, nonce, associatedData, plaintext)
+
+		var output bytes.Buffer
+		n, newNonce, err := stream.Transcode(&output, bytes.NewReader(ciphertext), nonce, associatedData, bufSize)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to transcode data stream: %v", i, err)
+		}
+		if n != int64(len(plaintext)) {
+			t.Fatalf("Test %d: Transcoded %d bytes - want %d", i, n, len(plaintext))
+		}
+		if len(newNonce) != stream.NonceSize() || bytes.Equal(newNonce, nonce) {
+			t.Fatalf("Test %d: Invalid new nonce: %x", i, newNonce)
+		}
+		transcoded, err := AES_128_GCM.StreamWithBufSize(key, bufSize)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create new Stream: %v", i, err)
+		}
+		if !bytes.Equal(output.Bytes(), encryptBytes(t, transcoded, newNonce, associatedData, plaintext)) {
+			t.Fatalf("Test %d: Transcoded data stream does not match the expected data stream", i)
+		}
+	}
+}
+
+func TestTranscodeNonce(t *testing.T) {
+	stream, nonce, associatedData := newReencryptStream(t, AES_128_GCM, BufSize)
+	ciphertext := encryptBytes(t, stream, nonce, associatedData, random(100))
+
+	// Transcoding the same data stream twice must not reuse the nonce.
+	_, newNonce, err := stream.Transcode(DevNull, bytes.NewReader(ciphertext), nonce, associatedData, 64)
+	if err != nil {
+		t.Fatalf("Failed to transcode data stream: %v", err)
+	}
+	_, otherNonce, err := stream.Transcode(DevNull, bytes.NewReader(ciphertext), nonce, associatedData, 64)
+	if err != nil {
+		t.Fatalf("Failed to transcode data stream: %v", err)
+	}
+	if bytes.Equal(newNonce, otherNonce) {
+		t.Fatal("Transcode reused a nonce")
+	}
+}
+
+func TestTranscodeInvalid(t *testing.T) {
+	stream, nonce, associatedData := newReencryptStream(t, AES_128_GCM, BufSize)
+	ciphertext := encryptBytes(t, stream, nonce, associatedData, random(100))
+
+	for _, bufSize := range []int{0, -1, MaxBufSize + 1} {
+		if _, _, err := stream.Transcode(DevNull, bytes.NewReader(ciphertext), nonce, associatedData, bufSize); err == nil {
+			t.Fatalf("Transcoding with buffer size %d should fail", bufSize)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"crypto/cipher"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/secure-io/sio-go/internal/sm4"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// algorithm describes an Algorithm and how
+// to create a corresponding AEAD.
+type algorithm struct {
+	id        byte
+	keySize   int
+	nonceSize int
+	overhead  int
+	newAEAD   func(key []byte) (cipher.AEAD, error)
+
+	fipsApproved bool
+}
+
+// MinCustomAlgorithmID is the smallest ID that can be
+// assigned to a custom algorithm. The IDs 1 - 127 are
+// reserved for the predefined algorithms and the ID 0
+// is invalid.
+const MinCustomAlgorithmID = 128
+
+// UnknownAlgorithmError is returned when an algorithm
+// name or ID does not refer to one of the predefined
+// or registered algorithms.
+type UnknownAlgorithmError struct {
+	Name string // The unknown algorithm name, if any.
+	ID   byte   // The unknown algorithm ID, if any.
+}
+
+func (e UnknownAlgorithmError) Error() string {
+	if e.Name == "" && e.ID != 0 {
+		return "sio: unknown algorithm ID " + strconv.Itoa(int(e.ID))
+	}
+	return "sio: unknown algorithm '" + e.Name + "'"
+}
+
+var (
+	algorithmsLock sync.RWMutex
+	algorithms     = map[Algorithm]algorithm{
+		// The IDs of the predefined algorithms are part of
+		// the stable API and must never be changed or reused.
+		AES_128_GCM:                   {id: 1, keySize: 128 / 8, nonceSize: 12, overhead: 16, newAEAD: newAESGCM, fipsApproved: true},
+		AES_256_GCM:                   {id: 2, keySize: 256 / 8, nonceSize: 12, overhead: 16, newAEAD: newAESGCM, fipsApproved: true},
+		ChaCha20Poly1305:              {id: 3, keySize: chacha20poly1305.KeySize, nonceSize: chacha20poly1305.NonceSize, overhead: chacha20poly1305.Overhead, newAEAD: chacha20poly1305.New},
+		XChaCha20Poly1305:             {id: 4, keySize: chacha20poly1305.KeySize, nonceSize: chacha20poly1305.NonceSizeX, overhead: chacha20poly1305.Overhead, newAEAD: chacha20poly1305.NewX},
+		AES_256_CTR_HMAC_SHA256:       {id: 5, keySize: 256 / 8, nonceSize: ctrHMACNonceSize, overhead: ctrHMACTagSize, newAEAD: newAESCTRHMAC, fipsApproved: true},
+		AES_SIV_CMAC_256:              {id: 6, keySize: 256 / 8, nonceSize: sivNonceSize, overhead: sivSize, newAEAD: newAESSIV},
+		AES_SIV_CMAC_512:              {id: 7, keySize: 512 / 8, nonceSize: sivNonceSize, overhead: sivSize, newAEAD: newAESSIV},
+		SM4_GCM:                       {id: 8, keySize: sm4.KeySize, nonceSize: 12, overhead: 16, newAEAD: newSM4GCM},
+		XChaCha20Poly1305_AES_256_GCM: {id: 9, keySize: 256 / 8, nonceSize: chacha20poly1305.NonceSizeX, overhead: 2 * 16, newAEAD: newXChaCha20Poly1305AESGCM},
+	}
+)
+
+// RegisterAlgorithm registers a custom AEAD algorithm under
+// the given ID and name and returns the corresponding Algorithm.
+//
+// The ID identifies the algorithm in binary encodings - e.g.
+// in headers of stored data streams. Hence, the ID must be
+// stable and at least MinCustomAlgorithmID.
+//
+// The newAEAD function must return a new cipher.AEAD for a
+// keySize bytes long secret key. The returned cipher.AEAD
+// must have the given nonceSize, which must be >= 4, and
+// overhead. RegisterAlgorithm does not call newAEAD. Instead,
+// Algorithm.Stream returns an error if newAEAD returns a
+// cipher.AEAD with a different nonce size or overhead.
+//
+// Once registered, the Algorithm can be used like any of
+// the predefined algorithms. For example:
+//
+//	HSM_AES_256_GCM := sio.RegisterAlgorithm(0x80, "HSM-AES-256-GCM", 32, 12, 16, newHSMCipher)
+//	stream, err := HSM_AES_256_GCM.Stream(key)
+//
+// Further, ParseAlgorithm and AlgorithmFromID accept
+// the name resp. ID of a registered algorithm.
+//
+// RegisterAlgorithm is usually called from an init function.
+// It panics if the ID or name is invalid or already registered,
+// if keySize is not positive, if nonceSize is smaller than 4,
+// if overhead is negative or if newAEAD is nil.
+func RegisterAlgorithm(id byte, name string, keySize, nonceSize, overhead int, newAEAD func(key []byte) (cipher.AEAD, error)) Algorithm {
+	if id < MinCustomAlgorithmID {
+		panic("sio: algorithm ID " + strconv.Itoa(int(id)) + " is reserved")
+	}
+	if name == "" {
+		panic("sio: algorithm name is empty")
+	}
+	if keySize <= 0 {
+		panic("sio: algorithm key size is not positive")
+	}
+	if nonceSize < 4 {
+		panic("sio: nonce size of algorithm '" + name + "' is too small")
+	}
+	if overhead < 0 {
+		panic("sio: algorithm overhead is negative")
+	}
+	if newAEAD == nil {
+		panic("sio: algorithm constructor is nil")
+	}
+
+	algorithmsLock.Lock()
+	defer algorithmsLock.Unlock()
+
+	if _, ok := algorithms[Algorithm(name)]; ok {
+		panic("sio: algorithm '" + name + "' is already registered")
+	}
+	for a, alg := range algorithms {
+		if alg.id == id {
+			panic("sio: algorithm ID " + strconv.Itoa(int(id)) + " is already registered for '" + string(a) + "'")
+		}
+	}
+	algorithms[Algorithm(name)] = algorithm{
+		id:        id,
+		keySize:   keySize,
+		nonceSize: nonceSize,
+		overhead:  overhead,
+		newAEAD:   newAEAD,
+	}
+	return Algorithm(name)
+}
+
+// ParseAlgorithm returns the Algorithm with the given
+// name. The name must either refer to one of the
+// predefined algorithms or to an algorithm registered
+// via RegisterAlgorithm.
+//
+// ParseAlgorithm should be used when reading algorithm
+// names from configuration files or stored data. If no
+// such algorithm exists it returns an UnknownAlgorithmError.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	if _, ok := lookupAlgorithm(Algorithm(name)); !ok {
+		return "", UnknownAlgorithmError{Name: name}
+	}
+	return Algorithm(name), nil
+}
+
+// AlgorithmFromID returns the Algorithm with the given ID.
+// The ID must either refer to one of the predefined algorithms
+// or to an algorithm registered via RegisterAlgorithm.
+// If no such algorithm exists it returns an UnknownAlgorithmError.
+func AlgorithmFromID(id byte) (Algorithm, error) {
+	algorithmsLock.RLock()
+	defer algorithmsLock.RUnlock()
+
+	for a, alg := range algorithms {
+		if alg.id == id {
+			return a, nil
+		}
+	}
+	return "", UnknownAlgorithmError{ID: id}
+}
+
+// Algorithms returns all predefined and registered
+// algorithms ordered by their IDs.
+func Algorithms() []Algorithm {
+	algorithmsLock.RLock()
+	defer algorithmsLock.RUnlock()
+
+	list := make([]Algorithm, 0, len(algorithms))
+	for a := range algorithms {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return algorithms[list[i]].id < algorithms[list[j]].id })
+	return list
+}
+
+// ID returns the stable one-byte identifier of the
+// algorithm. It returns 0 if the algorithm is unknown.
+func (a Algorithm) ID() byte {
+	alg, _ := lookupAlgorithm(a)
+	return alg.id
+}
+
+// KeySize returns the size of the secret key in bytes.
+// It returns 0 if the algorithm is unknown.
+func (a Algorithm) KeySize() int {
+	alg, _ := lookupAlgorithm(a)
+	return alg.keySize
+}
+
+// StreamNonceSize returns the size of the nonce that must be
+// provided when en/decrypting a data stream. It is equal to
+// the Stream.NonceSize of all Streams created by the algorithm.
+// It returns 0 if the algorithm is unknown.
+func (a Algorithm) StreamNonceSize() int {
+	alg, ok := lookupAlgorithm(a)
+	if !ok {
+		return 0
+	}
+	return alg.nonceSize - 4
+}
+
+// Overhead returns the number of bytes the algorithm adds
+// to each encrypted fragment. It returns 0 if the algorithm
+// is unknown. Use Stream.Overhead to compute the overhead of
+// an entire data stream.
+func (a Algorithm) Overhead() int {
+	alg, _ := lookupAlgorithm(a)
+	return alg.overhead
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+// It returns the algorithm name - even if the algorithm is
+// unknown. UnmarshalText resp. ParseAlgorithm validate the name.
+//
+// An Algorithm gets marshaled as JSON string via MarshalText.
+func (a Algorithm) MarshalText() ([]byte, error) { return []byte(a), nil }
+
+// UnmarshalText implements the encoding.TextUnmarshaler
+// interface. It parses the text as algorithm name - like
+// ParseAlgorithm. An empty text is unmarshaled as the
+// empty Algorithm such that the zero value round-trips.
+//
+// An Algorithm gets unmarshaled from a JSON string via
+// UnmarshalText.
+func (a *Algorithm) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*a = ""
+		return nil
+	}
+	alg, err := ParseAlgorithm(string(text))
+	if err != nil {
+		return err
+	}
+	*a = alg
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler
+// interface. It returns the one-byte algorithm ID or an
+// UnknownAlgorithmError if the algorithm is unknown.
+func (a Algorithm) MarshalBinary() ([]byte, error) {
+	alg, ok := lookupAlgorithm(a)
+	if !ok {
+		return nil, UnknownAlgorithmError{Name: string(a)}
+	}
+	return []byte{alg.id}, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler
+// interface. It parses the data as one-byte algorithm ID -
+// like AlgorithmFromID.
+func (a *Algorithm) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return errorType("sio: invalid algorithm ID encoding")
+	}
+	alg, err := AlgorithmFromID(data[0])
+	if err != nil {
+		return err
+	}
+	*a = alg
+	return nil
+}
+
+func lookupAlgorithm(a Algorithm) (algorithm, bool) {
+	algorithmsLock.RLock()
+	defer algorithmsLock.RUnlock()
+
+	alg, ok := algorithms[a]
+	return alg, ok
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/secure-io/sio-go/internal/sm4"
+)
+
+var testAlgorithm = RegisterAlgorithm(0xff, "Test-AES-128-GCM-96", 16, 12, 12, func(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithTagSize(block, 12)
+})
+
+func TestRegisterAlgorithm(t *testing.T) {
+	alg, err := ParseAlgorithm("Test-AES-128-GCM-96")
+	if err != nil {
+		t.Fatalf("Failed to parse registered algorithm: %v", err)
+	}
+	if alg != testAlgorithm {
+		t.Fatalf("Invalid algorithm: got %q - want %q", alg, testAlgorithm)
+	}
+
+	stream, err := alg.Stream(make([]byte, 16))
+	if err != nil {
+		t.Fatalf("Failed to create Stream: %v", err)
+	}
+	if overhead := stream.Overhead(1); overhead != 12 {
+		t.Fatalf("Invalid overhead: got %d - want %d", overhead, 12)
+	}
+
+	nonce := make([]byte, stream.NonceSize())
+	plaintext := randomN(4 * BufSize)
+	ciphertext := bytes.NewBuffer(nil)
+	ew := stream.EncryptWriter(ciphertext, nonce, nil)
+	if _, err = ew.Write(plaintext); err != nil {
+		t.Fatalf("Failed to encrypt plaintext: %v", err)
+	}
+	if err = ew.Close(); err != nil {
+		t.Fatalf("Failed to close EncWriter: %v", err)
+	}
+
+	decrypted := bytes.NewBuffer(nil)
+	if _, err = stream.DecryptReader(ciphertext, nonce, nil).WriteTo(decrypted); err != nil {
+		t.Fatalf("Failed to decrypt ciphertext: %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Fatal("plaintext does not match original plaintext")
+	}
+
+	if _, err = alg.Stream(make([]byte, 32)); err == nil {
+		t.Fatal("Created Stream with invalid key size")
+	}
+}
+
+func TestKeySizeError(t *testing.T) {
+	for _, a := range []Algorithm{AES_128_GCM, AES_256_GCM, AES_256_CTR_HMAC_SHA256, AES_SIV_CMAC_256, AES_SIV_CMAC_512, XChaCha20Poly1305_AES_256_GCM} {
+		_, err := a.Stream(make([]byte, 24))
+		if _, ok := err.(aes.KeySizeError); !ok {
+			t.Fatalf("%s: Invalid key size error: got %T - want %T", a, err, aes.KeySizeError(0))
+		}
+	}
+	_, err := SM4_GCM.Stream(make([]byte, 24))
+	if _, ok := err.(sm4.KeySizeError); !ok {
+		t.Fatalf("%s: Invalid key size error: got %T - want %T", SM4_GCM, err, sm4.KeySizeError(0))
+	}
+	if _, err = ChaCha20Poly1305.Stream(make([]byte, 24)); err == nil {
+		t.Fatalf("%s: Created Stream with invalid key size", ChaCha20Poly1305)
+	}
+}
+
+func TestRegisterAlgorithmPanics(t *testing.T) {
+	newAEAD := func(key []byte) (cipher.AEAD, error) { return newAESGCM(key) }
+	tests := []struct {
+		ID        byte
+		Name      string
+		KeySize   int
+		NonceSize int
+		Overhead  int
+		NewAEAD   func([]byte) (cipher.AEAD, error)
+	}{
+		{ID: 0x80, Name: "", KeySize: 16, NonceSize: 12, Overhead: 16, NewAEAD: newAEAD},                       // 0
+		{ID: 0x80, Name: string(AES_128_GCM), KeySize: 16, NonceSize: 12, Overhead: 16, NewAEAD: newAEAD},      // 1
+		{ID: 0x80, Name: "Test-AES-128-GCM-96", KeySize: 16, NonceSize: 12, Overhead: 16, NewAEAD: newAEAD},    // 2
+		{ID: 0x80, Name: "Test-Invalid-Key-Size", KeySize: 0, NonceSize: 12, Overhead: 16, NewAEAD: newAEAD},   // 3
+		{ID: 0x80, Name: "Test-Invalid-Constructor", KeySize: 16, NonceSize: 12, Overhead: 16, NewAEAD: nil},   // 4
+		{ID: 0x80, Name: "Test-Invalid-Nonce-Size", KeySize: 16, NonceSize: 3, Overhead: 16, NewAEAD: newAEAD}, // 5
+		{ID: 0x80, Name: "Test-Invalid-Overhead", KeySize: 16, NonceSize: 12, Overhead: -1, NewAEAD: newAEAD},  // 6
+		{ID: 0x01, Name: "Test-Reserved-ID", KeySize: 16, NonceSize: 12, Overhead: 16, NewAEAD: newAEAD},       // 7
+		{ID: 0xff, Name: "Test-Duplicate-ID", KeySize: 16, NonceSize: 12, Overhead: 16, NewAEAD: newAEAD},      // 8
+	}
+	for i, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Test %d: RegisterAlgorithm did not panic", i)
+				}
+			}()
+			RegisterAlgorithm(test.ID, test.Name, test.KeySize, test.NonceSize, test.Overhead, test.NewAEAD)
+		}()
+	}
+}
+
+func TestRegisterAlgorithmMismatch(t *testing.T) {
+	// RegisterAlgorithm does not call the constructor. Instead,
+	// Stream verifies the AEAD returned by the constructor.
+	var calls int
+	newAEAD := func(key []byte) (cipher.AEAD, error) { calls++; return newAESGCM(key) }
+	algs := []Algorithm{
+		RegisterAlgorithm(0xfe, "Test-Nonce-Size-Mismatch", 16, 16, 16, newAEAD),
+		RegisterAlgorithm(0xfd, "Test-Overhead-Mismatch", 16, 12, 12, newAEAD),
+		RegisterAlgorithm(0xfc, "Test-Constructor-Error", 8, 12, 16, newAEAD),
+	}
+	defer func() {
+		algorithmsLock.Lock()
+		defer algorithmsLock.Unlock()
+		for _, a := range algs {
+			delete(algorithms, a)
+		}
+	}()
+	if calls != 0 {
+		t.Fatalf("RegisterAlgorithm called the constructor %d times", calls)
+	}
+
+	for _, a := range algs {
+		if _, err := a.Stream(make([]byte, a.KeySize())); err == nil {
+			t.Fatalf("%s: Created Stream with an invalid AEAD", a)
+		}
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, alg := range []Algorithm{AES_128_GCM, AES_256_GCM, ChaCha20Poly1305, XChaCha20Poly1305} {
+		if a, err := ParseAlgorithm(alg.String()); err != nil || a != alg {
+			t.Fatalf("Failed to parse %q: got %q - %v", alg, a, err)
+		}
+	}
+	if _, err := ParseAlgorithm("AES-512-GCM"); !errors.As(err, new(UnknownAlgorithmError)) {
+		t.Fatalf("Parsed unknown algorithm: %v", err)
+	}
+	if _, err := AES_512_GCM.Stream(make([]byte, 64)); !errors.As(err, new(UnknownAlgorithmError)) {
+		t.Fatalf("Created Stream for unknown algorithm: %v", err)
+	}
+}
+
+const AES_512_GCM Algorithm = "AES-512-GCM"
+
+func TestAlgorithmMetadata(t *testing.T) {
+	ids := map[byte]Algorithm{}
+	for _, alg := range testAlgorithms() {
+		stream, err := alg.Stream(make([]byte, alg.KeySize()))
+		if err != nil {
+			t.Fatalf("%s: Failed to create Stream: %v", alg, err)
+		}
+		if n := alg.StreamNonceSize(); n != stream.NonceSize() {
+			t.Fatalf("%s: Invalid nonce size: got %d - want %d", alg, n, stream.NonceSize())
+		}
+		if overhead := int64(alg.Overhead()); overhead != stream.Overhead(1) {
+			t.Fatalf("%s: Invalid overhead: got %d - want %d", alg, overhead, stream.Overhead(1))
+		}
+
+		if a, ok := ids[alg.ID()]; ok || alg.ID() == 0 {
+			t.Fatalf("%s: Invalid ID %d - already used by %s", alg, alg.ID(), a)
+		}
+		ids[alg.ID()] = alg
+		if a, err := AlgorithmFromID(alg.ID()); err != nil || a != alg {
+			t.Fatalf("%s: Failed to lookup ID %d: got %q - %v", alg, alg.ID(), a, err)
+		}
+	}
+
+	if AES_512_GCM.ID() != 0 || AES_512_GCM.KeySize() != 0 || AES_512_GCM.StreamNonceSize() != 0 || AES_512_GCM.Overhead() != 0 {
+		t.Fatal("Unknown algorithm has metadata")
+	}
+	if _, err := AlgorithmFromID(0); !errors.As(err, new(UnknownAlgorithmError)) {
+		t.Fatalf("Found algorithm with ID 0: %v", err)
+	}
+}
+
+func TestAlgorithmMarshal(t *testing.T) {
+	type Config struct {
+		Algorithm Algorithm
+	}
+	for _, alg := range testAlgorithms() {
+		data, err := json.Marshal(Config{Algorithm: alg})
+		if err != nil {
+			t.Fatalf("%s: Failed to marshal algorithm: %v", alg, err)
+		}
+		var config Config
+		if err = json.Unmarshal(data, &config); err != nil {
+			t.Fatalf("%s: Failed to unmarshal algorithm: %v", alg, err)
+		}
+		if config.Algorithm != alg {
+			t.Fatalf("%s: Invalid algorithm: got %q", alg, config.Algorithm)
+		}
+
+		if data, err = alg.MarshalBinary(); err != nil {
+			t.Fatalf("%s: Failed to marshal algorithm: %v", alg, err)
+		}
+		var a Algorithm
+		if err = a.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: Failed to unmarshal algorithm: %v", alg, err)
+		}
+		if a != alg {
+			t.Fatalf("%s: Invalid algorithm: got %q", alg, a)
+		}
+	}
+
+	var config Config
+	if err := json.Unmarshal([]byte(`{"Algorithm":"AES-512-GCM"}`), &config); !errors.As(err, new(UnknownAlgorithmError)) {
+		t.Fatalf("Unmarshaled unknown algorithm: %v", err)
+	}
+	if data, err := json.Marshal(Config{Algorithm: AES_512_GCM}); err != nil || string(data) != `{"Algorithm":"AES-512-GCM"}` {
+		t.Fatalf("Failed to marshal unknown algorithm: %s - %v", data, err)
+	}
+
+	// The zero value must round-trip.
+	data, err := json.Marshal(Config{})
+	if err != nil {
+		t.Fatalf("Failed to marshal empty algorithm: %v", err)
+	}
+	config = Config{Algorithm: AES_128_GCM}
+	if err = json.Unmarshal(data, &config); err != nil || config.Algorithm != "" {
+		t.Fatalf("Failed to unmarshal empty algorithm: got %q - %v", config.Algorithm, err)
+	}
+}
+
+// testAlgorithms returns all predefined algorithms and
+// the algorithm registered by the tests.
+func testAlgorithms() []Algorithm {
+	return []Algorithm{
+		AES_128_GCM,
+		AES_256_GCM,
+		ChaCha20Poly1305,
+		XChaCha20Poly1305,
+		AES_256_CTR_HMAC_SHA256,
+		AES_SIV_CMAC_256,
+		AES_SIV_CMAC_512,
+		SM4_GCM,
+		XChaCha20Poly1305_AES_256_GCM,
+		testAlgorithm,
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	list := Algorithms()
+	if !slices.Equal(list, testAlgorithms()) {
+		t.Fatalf("Algorithms mismatch: got %v - want %v", list, testAlgorithms())
+	}
+}
@@ -0,0 +1,512 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//go:embed test_vectors.json
+var testVectorsJSON []byte
+
+// SelfTestFailure describes a single failed self-test.
+type SelfTestFailure struct {
+	Algorithm Algorithm // The algorithm under test.
+
+	// Vector is the index of the known-answer test vector
+	// within the embedded test vectors. It is -1 for
+	// algorithms without a known-answer test vector.
+	Vector int
+
+	// BufSize is the buffer size of the Stream under test.
+	BufSize int
+
+	// Path is the en/decryption path under test - e.g.
+	// "EncWriter.Write" or "DecReaderAt.ReadAt".
+	Path string
+
+	// Err is the error returned by the path or
+	// describes the unexpected result.
+	Err error
+}
+
+func (f SelfTestFailure) String() string {
+	vector := "generated vector"
+	if f.Vector >= 0 {
+		vector = "vector " + strconv.Itoa(f.Vector)
+	}
+	return f.Algorithm.String() + " (" + vector + ", bufSize " + strconv.Itoa(f.BufSize) + "): " + f.Path + ": " + f.Err.Error()
+}
+
+// SelfTestError is returned by SelfTest when at least
+// one self-test fails. It lists all failed self-tests.
+type SelfTestError struct {
+	Tests    int               // Number of executed self-tests.
+	Failures []SelfTestFailure // Failed self-tests.
+}
+
+func (e *SelfTestError) Error() string {
+	var s strings.Builder
+	s.WriteString("sio: ")
+	s.WriteString(strconv.Itoa(len(e.Failures)))
+	s.WriteString(" of ")
+	s.WriteString(strconv.Itoa(e.Tests))
+	s.WriteString(" self-tests failed")
+	for _, f := range e.Failures {
+		s.WriteString("\n\t")
+		s.WriteString(f.String())
+	}
+	return s.String()
+}
+
+// SelfTest runs known-answer tests for all predefined and
+// registered algorithms. It checks that every en/decryption
+// path - EncWriter, DecWriter, EncReader, DecReader and
+// DecReaderAt - produces the expected ciphertext resp.
+// plaintext and that modified ciphertexts get rejected.
+//
+// SelfTest is meant to be called once when a program starts
+// - before it en/decrypts any data - to detect faulty or
+// miscompiled cryptographic implementations. For example:
+//
+//	if err := sio.SelfTest(); err != nil {
+//		log.Fatal(err) // Don't encrypt any data
+//	}
+//
+// The predefined algorithms get tested using the known-answer
+// test vectors embedded into the library. Registered algorithms
+// have no known-answer test vectors. Instead, their expected
+// ciphertext is computed by applying the stream construction
+// directly to a cipher.AEAD created by the algorithm. Hence,
+// for registered algorithms SelfTest only checks that all
+// en/decryption paths are consistent with the AEAD. It cannot
+// detect a faulty AEAD implementation.
+//
+// In FIPS 140-3 mode, SelfTest only tests the algorithms
+// that can be used in FIPS 140-3 mode.
+//
+// If any test fails, SelfTest returns a *SelfTestError
+// describing all failures.
+func SelfTest() error {
+	vectors, err := parseSelfTestVectors(testVectorsJSON)
+	if err != nil {
+		return err
+	}
+
+	registered := Algorithms()
+	report := &SelfTestError{}
+	tested := make(map[Algorithm]bool, len(registered))
+	for _, v := range vectors {
+		tested[v.Algorithm] = true
+	}
+	for _, a := range registered {
+		if tested[a] {
+			continue
+		}
+		v, err := generateSelfTestVector(a)
+		if err == ErrNotFIPSApproved {
+			continue // The algorithm cannot be used in FIPS 140-3 mode
+		}
+		if err != nil {
+			report.Tests++
+			report.Failures = append(report.Failures, SelfTestFailure{Algorithm: a, Vector: -1, Path: "NewStream", Err: err})
+			continue
+		}
+		vectors = append(vectors, v)
+	}
+
+	for _, v := range vectors {
+		stream, err := v.Algorithm.StreamWithBufSize(v.Key, v.BufSize)
+		if err == ErrNotFIPSApproved {
+			continue // The algorithm cannot be used in FIPS 140-3 mode
+		}
+		if err != nil {
+			report.Tests++
+			report.Failures = append(report.Failures, v.failure("NewStream", err))
+			continue
+		}
+		for _, path := range selfTestPaths {
+			report.Tests++
+			if err = path.check(stream, &v); err != nil {
+				report.Failures = append(report.Failures, v.failure(path.Name, err))
+			}
+		}
+	}
+	if len(report.Failures) > 0 {
+		return report
+	}
+	return nil
+}
+
+const (
+	errSelfTestCiphertext errorType = "sio: ciphertext does not match expected ciphertext"
+	errSelfTestPlaintext  errorType = "sio: plaintext does not match expected plaintext"
+	errSelfTestAuthentic  errorType = "sio: modified ciphertext is authentic"
+)
+
+type selfTestVector struct {
+	Algorithm      Algorithm
+	Vector         int
+	BufSize        int
+	Key            []byte
+	Nonce          []byte
+	AssociatedData []byte
+	Plaintext      []byte
+	Ciphertext     []byte
+}
+
+func (v *selfTestVector) failure(path string, err error) SelfTestFailure {
+	return SelfTestFailure{
+		Algorithm: v.Algorithm,
+		Vector:    v.Vector,
+		BufSize:   v.BufSize,
+		Path:      path,
+		Err:       err,
+	}
+}
+
+// parseSelfTestVectors parses the JSON-encoded
+// test vectors. It skips test vectors that start
+// at a later fragment - i.e. with a non-zero BlockNum.
+func parseSelfTestVectors(data []byte) ([]selfTestVector, error) {
+	var vectors []struct {
+		Algorithm      Algorithm
+		BufSize        int
+		BlockNum       uint32
+		Key            string
+		Nonce          string
+		AssociatedData string
+		Plaintext      string
+		Ciphertext     string
+	}
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		return nil, err
+	}
+
+	var err error
+	selfTestVectors := make([]selfTestVector, 0, len(vectors))
+	for i, v := range vectors {
+		if v.BlockNum != 0 {
+			continue
+		}
+		selfTestVectors = append(selfTestVectors, selfTestVector{})
+		vector := &selfTestVectors[len(selfTestVectors)-1]
+		vector.Algorithm = v.Algorithm
+		vector.Vector = i
+		vector.BufSize = v.BufSize
+		if vector.Key, err = hex.DecodeString(v.Key); err != nil {
+			return nil, err
+		}
+		if vector.Nonce, err = hex.DecodeString(v.Nonce); err != nil {
+			return nil, err
+		}
+		if vector.AssociatedData, err = hex.DecodeString(v.AssociatedData); err != nil {
+			return nil, err
+		}
+		if vector.Plaintext, err = hex.DecodeString(v.Plaintext); err != nil {
+			return nil, err
+		}
+		if vector.Ciphertext, err = hex.DecodeString(v.Ciphertext); err != nil {
+			return nil, err
+		}
+	}
+	return selfTestVectors, nil
+}
+
+// generateSelfTestVector generates a deterministic test
+// vector for the given algorithm. It computes the ciphertext
+// by applying the stream construction directly to the AEAD
+// instead of using the EncWriter or EncReader.
+//
+// The generated vector is not a known-answer test vector.
+// It is a round-trip consistency check since the expected
+// ciphertext is computed by the AEAD under test.
+func generateSelfTestVector(a Algorithm) (selfTestVector, error) {
+	const bufSize = 16
+
+	alg, ok := lookupAlgorithm(a)
+	if !ok {
+		return selfTestVector{}, UnknownAlgorithmError{Name: string(a)}
+	}
+	if !alg.fipsApproved && fipsEnabled() {
+		return selfTestVector{}, ErrNotFIPSApproved
+	}
+	v := selfTestVector{
+		Algorithm:      a,
+		Vector:         -1,
+		BufSize:        bufSize,
+		Key:            selfTestBytes(alg.keySize, 0x00),
+		Nonce:          selfTestBytes(alg.nonceSize-4, 0x40),
+		AssociatedData: selfTestBytes(13, 0x80),
+		Plaintext:      selfTestBytes(3*bufSize+7, 0xc0),
+	}
+	aead, err := alg.newAEAD(v.Key)
+	if err != nil {
+		return selfTestVector{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, v.Nonce)
+	associatedData := append([]byte{0x00}, aead.Seal(nil, nonce, nil, v.AssociatedData)...)
+
+	plaintext := v.Plaintext
+	for seqNum := uint32(1); ; seqNum++ {
+		binary.LittleEndian.PutUint32(nonce[len(nonce)-4:], seqNum)
+		if len(plaintext) <= bufSize {
+			associatedData[0] = 0x80
+			v.Ciphertext = aead.Seal(v.Ciphertext, nonce, plaintext, associatedData)
+			break
+		}
+		v.Ciphertext = aead.Seal(v.Ciphertext, nonce, plaintext[:bufSize], associatedData)
+		plaintext = plaintext[bufSize:]
+	}
+	return v, nil
+}
+
+func selfTestBytes(n int, offset byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = offset + byte(i)
+	}
+	return b
+}
+
+// selfTestPath is an en/decryption path checked by SelfTest.
+type selfTestPath struct {
+	Name string
+	Run  func(stream *Stream, v *selfTestVector) ([]byte, error)
+
+	// Encrypt reports whether the path encrypts. If so,
+	// Run must return the ciphertext. Otherwise, it must
+	// return the plaintext.
+	Encrypt bool
+
+	// Modified reports whether the path decrypts a
+	// modified ciphertext. If so, Run must fail.
+	Modified bool
+}
+
+func (p *selfTestPath) check(stream *Stream, v *selfTestVector) error {
+	output, err := p.Run(stream, v)
+	switch {
+	case p.Modified && err == nil:
+		return errSelfTestAuthentic
+	case p.Modified:
+		return nil
+	case err != nil:
+		return err
+	case p.Encrypt && !bytes.Equal(output, v.Ciphertext):
+		return errSelfTestCiphertext
+	case !p.Encrypt && !bytes.Equal(output, v.Plaintext):
+		return errSelfTestPlaintext
+	}
+	return nil
+}
+
+var selfTestPaths = []selfTestPath{
+	{
+		Name:    "EncWriter.Write",
+		Encrypt: true,
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			var ciphertext bytes.Buffer
+			ew := stream.EncryptWriter(&ciphertext, v.Nonce, v.AssociatedData)
+			if _, err := ew.Write(v.Plaintext); err != nil {
+				return nil, err
+			}
+			if err := ew.Close(); err != nil {
+				return nil, err
+			}
+			return ciphertext.Bytes(), nil
+		},
+	},
+	{
+		Name:    "EncWriter.WriteByte",
+		Encrypt: true,
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			var ciphertext bytes.Buffer
+			ew := stream.EncryptWriter(&ciphertext, v.Nonce, v.AssociatedData)
+			for _, b := range v.Plaintext {
+				if err := ew.WriteByte(b); err != nil {
+					return nil, err
+				}
+			}
+			if err := ew.Close(); err != nil {
+				return nil, err
+			}
+			return ciphertext.Bytes(), nil
+		},
+	},
+	{
+		Name:    "EncWriter.ReadFrom",
+		Encrypt: true,
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			var ciphertext bytes.Buffer
+			ew := stream.EncryptWriter(&ciphertext, v.Nonce, v.AssociatedData)
+			if _, err := ew.ReadFrom(bytes.NewReader(v.Plaintext)); err != nil {
+				return nil, err
+			}
+			if err := ew.Close(); err != nil {
+				return nil, err
+			}
+			return ciphertext.Bytes(), nil
+		},
+	},
+	{
+		Name:    "EncReader.Read",
+		Encrypt: true,
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			er := stream.EncryptReader(bytes.NewReader(v.Plaintext), v.Nonce, v.AssociatedData)
+			return io.ReadAll(er)
+		},
+	},
+	{
+		Name:    "EncReader.ReadByte",
+		Encrypt: true,
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			er := stream.EncryptReader(bytes.NewReader(v.Plaintext), v.Nonce, v.AssociatedData)
+			return readBytes(er)
+		},
+	},
+	{
+		Name:    "EncReader.WriteTo",
+		Encrypt: true,
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			var ciphertext bytes.Buffer
+			er := stream.EncryptReader(bytes.NewReader(v.Plaintext), v.Nonce, v.AssociatedData)
+			if _, err := er.WriteTo(&ciphertext); err != nil {
+				return nil, err
+			}
+			return ciphertext.Bytes(), nil
+		},
+	},
+	{
+		Name: "DecWriter.Write",
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			return decryptWrite(stream, v, v.Ciphertext)
+		},
+	},
+	{
+		Name: "DecWriter.WriteByte",
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			var plaintext bytes.Buffer
+			dw := stream.DecryptWriter(&plaintext, v.Nonce, v.AssociatedData)
+			for _, b := range v.Ciphertext {
+				if err := dw.WriteByte(b); err != nil {
+					return nil, err
+				}
+			}
+			if err := dw.Close(); err != nil {
+				return nil, err
+			}
+			return plaintext.Bytes(), nil
+		},
+	},
+	{
+		Name: "DecWriter.ReadFrom",
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			var plaintext bytes.Buffer
+			dw := stream.DecryptWriter(&plaintext, v.Nonce, v.AssociatedData)
+			if _, err := dw.ReadFrom(bytes.NewReader(v.Ciphertext)); err != nil {
+				return nil, err
+			}
+			if err := dw.Close(); err != nil {
+				return nil, err
+			}
+			return plaintext.Bytes(), nil
+		},
+	},
+	{
+		Name: "DecReader.Read",
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			return decryptRead(stream, v, v.Ciphertext)
+		},
+	},
+	{
+		Name: "DecReader.ReadByte",
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			dr := stream.DecryptReader(bytes.NewReader(v.Ciphertext), v.Nonce, v.AssociatedData)
+			return readBytes(dr)
+		},
+	},
+	{
+		Name: "DecReader.WriteTo",
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			var plaintext bytes.Buffer
+			dr := stream.DecryptReader(bytes.NewReader(v.Ciphertext), v.Nonce, v.AssociatedData)
+			if _, err := dr.WriteTo(&plaintext); err != nil {
+				return nil, err
+			}
+			return plaintext.Bytes(), nil
+		},
+	},
+	{
+		Name: "DecReaderAt.ReadAt",
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			dr := stream.DecryptReaderAt(bytes.NewReader(v.Ciphertext), v.Nonce, v.AssociatedData)
+			return io.ReadAll(io.NewSectionReader(dr, 0, math.MaxInt64))
+		},
+	},
+	{
+		Name:     "DecWriter.Write (modified ciphertext)",
+		Modified: true,
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			return decryptWrite(stream, v, modifyCiphertext(v.Ciphertext))
+		},
+	},
+	{
+		Name:     "DecReader.Read (modified ciphertext)",
+		Modified: true,
+		Run: func(stream *Stream, v *selfTestVector) ([]byte, error) {
+			return decryptRead(stream, v, modifyCiphertext(v.Ciphertext))
+		},
+	},
+}
+
+func decryptWrite(stream *Stream, v *selfTestVector, ciphertext []byte) ([]byte, error) {
+	var plaintext bytes.Buffer
+	dw := stream.DecryptWriter(&plaintext, v.Nonce, v.AssociatedData)
+	if _, err := dw.Write(ciphertext); err != nil {
+		return nil, err
+	}
+	if err := dw.Close(); err != nil {
+		return nil, err
+	}
+	return plaintext.Bytes(), nil
+}
+
+func decryptRead(stream *Stream, v *selfTestVector, ciphertext []byte) ([]byte, error) {
+	dr := stream.DecryptReader(bytes.NewReader(ciphertext), v.Nonce, v.AssociatedData)
+	return io.ReadAll(dr)
+}
+
+// modifyCiphertext returns a copy of the ciphertext
+// with the last bit of the final fragment flipped.
+func modifyCiphertext(ciphertext []byte) []byte {
+	modified := bytes.Clone(ciphertext)
+	modified[len(modified)-1] ^= 1
+	return modified
+}
+
+func readBytes(r io.ByteReader) ([]byte, error) {
+	var b []byte
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, c)
+	}
+}
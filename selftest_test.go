@@ -0,0 +1,99 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"crypto/cipher"
+	"strings"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Fatalf("Self-test failed: %v", err)
+	}
+}
+
+func TestSelfTestFIPSMode(t *testing.T) {
+	defer func(f func() bool) { fipsEnabled = f }(fipsEnabled)
+	fipsEnabled = func() bool { return true }
+
+	if err := SelfTest(); err != nil {
+		t.Fatalf("Self-test failed in FIPS 140-3 mode: %v", err)
+	}
+
+	// In FIPS 140-3 mode, SelfTest must only skip algorithms that
+	// are not approved. Any other error must be reported.
+	a := RegisterAlgorithm(0xfb, "Test-FIPS-Constructor-Error", 8, 12, 16, newAESGCM)
+	defer func() {
+		algorithmsLock.Lock()
+		defer algorithmsLock.Unlock()
+		delete(algorithms, a)
+	}()
+	algorithmsLock.Lock()
+	alg := algorithms[a]
+	alg.fipsApproved = true
+	algorithms[a] = alg
+	algorithmsLock.Unlock()
+
+	err := SelfTest()
+	if report, ok := err.(*SelfTestError); !ok || len(report.Failures) != 1 || report.Failures[0].Algorithm != a {
+		t.Fatalf("Self-test did not report the failing algorithm in FIPS 140-3 mode: %v", err)
+	}
+}
+
+func TestSelfTestVectors(t *testing.T) {
+	vectors, err := parseSelfTestVectors(testVectorsJSON)
+	if err != nil {
+		t.Fatalf("Failed to parse test vectors: %v", err)
+	}
+	if len(vectors) != len(TestVectors) {
+		t.Fatalf("Embedded test vectors do not match test vectors: got %d - want %d", len(vectors), len(TestVectors))
+	}
+
+	for _, a := range testAlgorithms() {
+		if _, err := generateSelfTestVector(a); err != nil {
+			t.Fatalf("%s: Failed to generate self-test vector: %v", a, err)
+		}
+	}
+}
+
+func TestSelfTestFailure(t *testing.T) {
+	v, err := generateSelfTestVector(AES_128_GCM)
+	if err != nil {
+		t.Fatalf("Failed to generate self-test vector: %v", err)
+	}
+	aead, err := newAESGCM(v.Key)
+	if err != nil {
+		t.Fatalf("Failed to create AES-GCM: %v", err)
+	}
+
+	stream := NewStream(faultyAEAD{aead}, v.BufSize)
+	for _, path := range selfTestPaths {
+		if err := path.check(stream, &v); err == nil {
+			t.Fatalf("%s: faulty AEAD passed the self-test", path.Name)
+		}
+	}
+
+	report := &SelfTestError{Tests: 2, Failures: []SelfTestFailure{v.failure("EncWriter.Write", errSelfTestCiphertext)}}
+	if msg := report.Error(); !strings.Contains(msg, "EncWriter.Write") || !strings.Contains(msg, errSelfTestCiphertext.Error()) {
+		t.Fatalf("Self-test report does not describe the failure: %s", msg)
+	}
+}
+
+// faultyAEAD is a broken cipher.AEAD that computes
+// wrong ciphertexts and accepts any ciphertext.
+type faultyAEAD struct{ cipher.AEAD }
+
+func (f faultyAEAD) Seal(dst, nonce, plaintext, associatedData []byte) []byte {
+	ciphertext := f.AEAD.Seal(dst, nonce, plaintext, associatedData)
+	ciphertext[len(ciphertext)-1] ^= 1
+	return ciphertext
+}
+
+func (f faultyAEAD) Open(dst, nonce, ciphertext, associatedData []byte) ([]byte, error) {
+	plaintext, _ := sliceForAppend(dst, len(ciphertext)-f.Overhead())
+	return plaintext, nil
+}
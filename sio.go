@@ -12,9 +12,10 @@ import (
 	"encoding/binary"
 	"io"
 	"math"
+	"strconv"
 	"sync"
 
-	"golang.org/x/crypto/chacha20poly1305"
+	"github.com/secure-io/sio-go/internal/sm4"
 )
 
 const (
@@ -40,6 +41,19 @@ const (
 	// encrypted / decrypted securely using the same key-nonce
 	// combination. For BufSize the limit is ~64 TiB.
 	ErrExceeded errorType = "sio: data limit exceeded"
+
+	// ErrNotFIPSApproved is returned when a Stream for an algorithm
+	// that is not FIPS 140-3 approved is requested while the program
+	// runs in FIPS 140-3 mode.
+	// See: Algorithm.FIPSApproved
+	ErrNotFIPSApproved errorType = "sio: algorithm is not FIPS 140-3 approved"
+
+	// ErrKeyCommitment is returned when decrypting a data stream
+	// encrypted by a CommittingStream and the key commitment of
+	// the data stream does not match. It indicates that the data
+	// stream has been encrypted with a different key or nonce -
+	// or that the commitment has been (maliciously) modified.
+	ErrKeyCommitment errorType = "sio: key commitment does not match"
 )
 
 type errorType string
@@ -57,6 +71,46 @@ const (
 	AES_256_GCM       Algorithm = "AES-256-GCM"        // The secret key must be 32 bytes long. See: https://golang.org/pkg/crypto/cipher/#NewGCM
 	ChaCha20Poly1305  Algorithm = "ChaCha20-Poly1305"  // The secret key must be 32 bytes long. See: https://godoc.org/golang.org/x/crypto/chacha20poly1305#New
 	XChaCha20Poly1305 Algorithm = "XChaCha20-Poly1305" // The secret key must be 32 bytes long. See: https://godoc.org/golang.org/x/crypto/chacha20poly1305#NewX
+
+	// AES_256_CTR_HMAC_SHA256 is an encrypt-then-MAC AEAD composed of
+	// AES-256-CTR and HMAC-SHA-256. The encryption and MAC keys are
+	// derived from the secret key using HKDF-SHA-256. The HMAC covers
+	// the nonce, the associated data and the ciphertext and is not
+	// truncated. Therefore, the overhead per fragment is 32 bytes.
+	// The secret key must be 32 bytes long.
+	AES_256_CTR_HMAC_SHA256 Algorithm = "AES-256-CTR-HMAC-SHA256"
+
+	// AES_SIV_CMAC_256 and AES_SIV_CMAC_512 are the deterministic
+	// AES-SIV AEADs specified by RFC 5297. The synthetic IV is
+	// computed over the associated data, the nonce and the plaintext.
+	// Hence, encrypting the same plaintext under the same key, nonce
+	// and associated data produces the same ciphertext - which makes
+	// AES-SIV suitable for deduplication. Repeating a nonce does not
+	// break the confidentiality beyond revealing such duplicates.
+	//
+	// For AES_SIV_CMAC_256 the secret key must be 32 bytes long and
+	// for AES_SIV_CMAC_512 the secret key must be 64 bytes long.
+	// See: https://tools.ietf.org/html/rfc5297
+	AES_SIV_CMAC_256 Algorithm = "AES-SIV-CMAC-256"
+	AES_SIV_CMAC_512 Algorithm = "AES-SIV-CMAC-512"
+
+	// SM4_GCM is the SM4 block cipher in Galois/Counter mode as
+	// specified by RFC 8998. It should only be used when regulations
+	// require SM4. The secret key must be 16 bytes long.
+	// See: https://tools.ietf.org/html/rfc8998
+	SM4_GCM Algorithm = "SM4-GCM"
+
+	// XChaCha20Poly1305_AES_256_GCM is a cascade that encrypts each
+	// fragment with XChaCha20-Poly1305 first and then encrypts the
+	// resulting ciphertext with AES-256-GCM. The data remains
+	// confidential and authentic as long as one of the two AEADs
+	// remains secure. Both AEAD keys are derived independently from
+	// the secret key using HKDF-SHA-256. The AES-256-GCM key is also
+	// derived from the nonce such that each data stream uses its own
+	// AES-256-GCM key. The overhead per fragment
+	// is 32 bytes and the nonce is 20 bytes long.
+	// The secret key must be 32 bytes long.
+	XChaCha20Poly1305_AES_256_GCM Algorithm = "XChaCha20-Poly1305+AES-256-GCM"
 )
 
 // Algorithm specifies an AEAD algorithm that
@@ -65,7 +119,8 @@ const (
 // Its main purpose is to simplify code that
 // wants to use commonly used AEAD algorithms,
 // like AES-GCM, by providing a way to directly
-// create Streams from secret keys.
+// create Streams from secret keys. Custom AEAD
+// algorithms can be added via RegisterAlgorithm.
 type Algorithm string
 
 // String returns the string representation of an
@@ -75,35 +130,63 @@ func (a Algorithm) String() string { return string(a) }
 // Stream returns a new Stream using the given
 // secret key and AEAD algorithm.
 // The returned Stream uses the default buffer size: BufSize.
-func (a Algorithm) Stream(key []byte) (*Stream, error) { return a.streamWithBufSize(key, BufSize) }
+func (a Algorithm) Stream(key []byte) (*Stream, error) { return a.StreamWithBufSize(key, BufSize) }
+
+// StreamWithBufSize returns a new Stream using the given
+// secret key and AEAD algorithm. The returned Stream uses
+// the given buffer size which must be between 1 (inclusive)
+// and MaxBufSize (inclusive).
+//
+// Data streams encrypted with one buffer size can only be
+// decrypted with the same buffer size. Most applications
+// should use Stream with the default buffer size instead.
+func (a Algorithm) StreamWithBufSize(key []byte, bufSize int) (*Stream, error) {
+	return a.streamWithBufSize(key, bufSize)
+}
 
 func (a Algorithm) streamWithBufSize(key []byte, bufSize int) (*Stream, error) {
-	var (
-		aead cipher.AEAD
-		err  error
-	)
-	switch a {
-	case AES_128_GCM:
-		if len(key) != 128/8 {
-			return nil, aes.KeySizeError(len(key))
-		}
-		aead, err = newAESGCM(key)
-	case AES_256_GCM:
-		if len(key) != 256/8 {
-			return nil, aes.KeySizeError(len(key))
-		}
-		aead, err = newAESGCM(key)
-	case ChaCha20Poly1305:
-		aead, err = chacha20poly1305.New(key)
-	case XChaCha20Poly1305:
-		aead, err = chacha20poly1305.NewX(key)
-	default:
-		return nil, errorType("sio: invalid algorithm name")
+	if bufSize <= 0 || bufSize > MaxBufSize {
+		return nil, errorType("sio: invalid buffer size " + strconv.Itoa(bufSize))
+	}
+	alg, ok := lookupAlgorithm(a)
+	if !ok {
+		return nil, UnknownAlgorithmError{Name: string(a)}
+	}
+	if !alg.fipsApproved && fipsEnabled() {
+		return nil, ErrNotFIPSApproved
+	}
+	if len(key) != alg.keySize {
+		return nil, keySizeError(a, alg, key)
 	}
+	aead, err := alg.newAEAD(key)
 	if err != nil {
 		return nil, err
 	}
-	return NewStream(aead, bufSize), nil
+	if aead.NonceSize() != alg.nonceSize {
+		return nil, errorType("sio: NonceSize() of " + string(a) + " does not match the registered nonce size")
+	}
+	if aead.Overhead() != alg.overhead {
+		return nil, errorType("sio: Overhead() of " + string(a) + " does not match the registered overhead")
+	}
+	return newStream(aead, bufSize), nil
+}
+
+// keySizeError returns the error for a key with an invalid
+// size. The predefined algorithms return the same errors as
+// their underlying ciphers - e.g. an aes.KeySizeError for
+// AES-GCM.
+func keySizeError(a Algorithm, alg algorithm, key []byte) error {
+	switch a {
+	case AES_128_GCM, AES_256_GCM, AES_256_CTR_HMAC_SHA256, AES_SIV_CMAC_256, AES_SIV_CMAC_512, XChaCha20Poly1305_AES_256_GCM:
+		return aes.KeySizeError(len(key))
+	case SM4_GCM:
+		return sm4.KeySizeError(len(key))
+	case ChaCha20Poly1305, XChaCha20Poly1305:
+		if _, err := alg.newAEAD(key); err != nil {
+			return err
+		}
+	}
+	return errorType("sio: invalid key size " + strconv.Itoa(len(key)) + " for " + string(a))
 }
 
 func newAESGCM(key []byte) (cipher.AEAD, error) {
@@ -114,6 +197,14 @@ func newAESGCM(key []byte) (cipher.AEAD, error) {
 	return cipher.NewGCM(block)
 }
 
+func newSM4GCM(key []byte) (cipher.AEAD, error) {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
 // NewStream creates a new Stream that encrypts or decrypts data
 // streams with the cipher using bufSize large chunks. Therefore,
 // the bufSize must be the same for encryption and decryption. If
@@ -121,7 +212,20 @@ func newAESGCM(key []byte) (cipher.AEAD, error) {
 //
 // The cipher must support a NonceSize() >= 4 and the
 // bufSize must be between 1 (inclusive) and MaxBufSize (inclusive).
+//
+// NewStream cannot tell whether an arbitrary cipher is FIPS 140-3
+// approved. Therefore, it panics with ErrNotFIPSApproved when the
+// program runs in FIPS 140-3 mode. Then, applications must create
+// Streams via Algorithm.Stream, which returns ErrNotFIPSApproved
+// for algorithms that are not approved.
 func NewStream(cipher cipher.AEAD, bufSize int) *Stream {
+	if fipsEnabled() {
+		panic(ErrNotFIPSApproved)
+	}
+	return newStream(cipher, bufSize)
+}
+
+func newStream(cipher cipher.AEAD, bufSize int) *Stream {
 	if cipher.NonceSize() < 4 {
 		panic("sio: NonceSize() of cipher is too small")
 	}
@@ -147,6 +251,10 @@ type Stream struct {
 // provided when encrypting or decrypting a data stream.
 func (s *Stream) NonceSize() int { return s.cipher.NonceSize() - 4 }
 
+// BufSize returns the buffer size of the Stream - i.e.
+// the size of the plaintext of a single fragment.
+func (s *Stream) BufSize() int { return s.bufSize }
+
 // Overhead returns the overhead added when encrypting a
 // data stream. For a plaintext stream of a non-negative
 // size, the size of an encrypted data stream will be:
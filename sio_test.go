@@ -11,6 +11,7 @@ import (
 type TestVector struct {
 	Algorithm      Algorithm
 	BufSize        int
+	BlockNum       uint32
 	Key            []byte
 	Nonce          []byte
 	AssociatedData []byte
@@ -18,7 +19,10 @@ type TestVector struct {
 	Ciphertext     []byte
 }
 
-var TestVectors []TestVector = loadTestVectors("./test_vectors.json")
+// TestVectors contains all test vectors that start at the first
+// fragment. BlockNumTestVectors contains all test vectors that
+// start at a later fragment - see EncWriter.Reset.
+var TestVectors, BlockNumTestVectors []TestVector = loadTestVectors("./test_vectors.json")
 
 type SimpleTest struct {
 	Algorithm      Algorithm
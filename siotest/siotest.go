@@ -0,0 +1,746 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+// Package siotest implements a conformance test suite for
+// cipher.AEAD implementations used with sio.NewStream.
+//
+// The suite checks that an AEAD works with the sio stream
+// construction - i.e. with the fragment counter in the nonce,
+// with short final fragments and with the in-place en/decryption
+// performed by the EncWriter, DecWriter, EncReader, DecReader and
+// DecReaderAt. Therefore, it en/decrypts data streams using every
+// reader and writer path while feeding them from adversarial
+// readers and writers - like readers that return one byte at a
+// time or fail in the middle of a stream.
+//
+// Custom AEAD implementations should be tested like this:
+//
+//	func TestMyAEAD(t *testing.T) {
+//		if err := siotest.TestAEAD(NewMyAEAD, MyAEADKeySize); err != nil {
+//			t.Fatal(err)
+//		}
+//	}
+package siotest
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"testing/iotest"
+
+	"github.com/secure-io/sio-go"
+)
+
+// BufSizes are the buffer sizes of the Streams
+// that TestAEAD uses to en/decrypt data streams.
+var BufSizes = []int{1, 3, 16, 61, 512, sio.BufSize}
+
+// TestAEAD checks that the cipher.AEAD returned by newAEAD conforms
+// to the cipher.AEAD interface and works correctly when used with
+// sio.NewStream. newAEAD must return a cipher.AEAD for the given
+// keySize bytes long secret key.
+//
+// First, TestAEAD checks the AEAD itself - e.g. that it supports
+// a NonceSize() >= 4, en/decrypts in-place and rejects modified
+// ciphertexts. Then, it en/decrypts data streams of various lengths
+// for every buffer size in BufSizes. It compares the ciphertext of
+// every encryption path to a reference ciphertext computed by
+// applying the stream construction directly to the AEAD.
+//
+// TestAEAD is deterministic. It returns an error describing all
+// failed checks, if any. An AEAD that panics fails the check that
+// caused the panic.
+func TestAEAD(newAEAD func(key []byte) (cipher.AEAD, error), keySize int) error {
+	if keySize <= 0 {
+		return errors.New("siotest: invalid key size " + fmt.Sprint(keySize))
+	}
+	rng := rand.New(rand.NewChaCha8([32]byte{}))
+
+	key := randomBytes(rng, keySize)
+	aead, err := newAEAD(key)
+	if err != nil {
+		return fmt.Errorf("siotest: failed to create AEAD: %v", err)
+	}
+	if aead.NonceSize() < 4 {
+		return fmt.Errorf("siotest: NonceSize() %d is smaller than 4", aead.NonceSize())
+	}
+	if aead.Overhead() < 0 {
+		return fmt.Errorf("siotest: Overhead() %d is negative", aead.Overhead())
+	}
+
+	var failures failures
+	for _, check := range aeadChecks {
+		failures.run(check.Name, func() error { return check.Check(aead, rng) })
+	}
+	if len(failures) > 0 { // Don't test data streams if the AEAD is broken.
+		return failures.err()
+	}
+
+	for _, bufSize := range BufSizes {
+		for _, size := range []int{0, 1, bufSize - 1, bufSize, bufSize + 1, 2 * bufSize, 3*bufSize + 1} {
+			if size < 0 || (size == 1 && bufSize == 1) || (size == bufSize-1 && bufSize <= 2) {
+				continue // Skip negative and duplicate stream sizes
+			}
+
+			aead, err := newAEAD(key)
+			if err != nil {
+				return fmt.Errorf("siotest: failed to create AEAD: %v", err)
+			}
+			var stream *sio.Stream
+			if err = catchPanic(func() error { stream = sio.NewStream(aead, bufSize); return nil }); err != nil {
+				return fmt.Errorf("siotest: failed to create Stream: %v", err)
+			}
+
+			test := newTestCase(aead, rng, bufSize, size)
+			for _, path := range streamPaths {
+				name := fmt.Sprintf("bufSize %d, size %d: %s", bufSize, size, path.Name)
+				failures.run(name, func() error { return path.Check(stream, test) })
+			}
+		}
+	}
+	return failures.err()
+}
+
+// failures is a list of failed checks.
+type failures []string
+
+// run runs the check and records a failure
+// if the check returns an error or panics.
+func (f *failures) run(name string, check func() error) {
+	if err := catchPanic(check); err != nil {
+		*f = append(*f, name+": "+err.Error())
+	}
+}
+
+func (f failures) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	return errors.New("siotest: " + fmt.Sprint(len(f)) + " checks failed:\n\t" + strings.Join(f, "\n\t"))
+}
+
+func catchPanic(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return f()
+}
+
+// testCase is a data stream en/decrypted by a Stream.
+type testCase struct {
+	rng      *rand.Rand
+	bufSize  int
+	overhead int
+
+	nonce          []byte
+	associatedData []byte
+	plaintext      []byte
+	ciphertext     []byte
+}
+
+// newTestCase returns a new testCase for a size bytes long
+// plaintext. It computes the ciphertext by applying the
+// stream construction directly to the AEAD.
+func newTestCase(aead cipher.AEAD, rng *rand.Rand, bufSize, size int) *testCase {
+	test := &testCase{
+		rng:            rng,
+		bufSize:        bufSize,
+		overhead:       aead.Overhead(),
+		nonce:          randomBytes(rng, aead.NonceSize()-4),
+		associatedData: randomBytes(rng, rng.IntN(64)),
+		plaintext:      randomBytes(rng, size),
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, test.nonce)
+	associatedData := append([]byte{0x00}, aead.Seal(nil, nonce, nil, test.associatedData)...)
+
+	plaintext := test.plaintext
+	for seqNum := uint32(1); ; seqNum++ {
+		binary.LittleEndian.PutUint32(nonce[len(nonce)-4:], seqNum)
+		if len(plaintext) <= bufSize {
+			associatedData[0] = 0x80
+			test.ciphertext = aead.Seal(test.ciphertext, nonce, plaintext, associatedData)
+			break
+		}
+		test.ciphertext = aead.Seal(test.ciphertext, nonce, plaintext[:bufSize], associatedData)
+		plaintext = plaintext[bufSize:]
+	}
+	return test
+}
+
+func randomBytes(rng *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(rng.Uint32())
+	}
+	return b
+}
+
+var errTest = errors.New("siotest: injected error")
+
+// aeadChecks are the checks of the AEAD itself.
+var aeadChecks = []struct {
+	Name  string
+	Check func(aead cipher.AEAD, rng *rand.Rand) error
+}{
+	{
+		Name: "Seal/Open",
+		Check: func(aead cipher.AEAD, rng *rand.Rand) error {
+			nonce, plaintext, associatedData := randomBytes(rng, aead.NonceSize()), randomBytes(rng, 1024), randomBytes(rng, 32)
+			ciphertext := aead.Seal(nil, nonce, plaintext, associatedData)
+			if len(ciphertext) != len(plaintext)+aead.Overhead() {
+				return fmt.Errorf("ciphertext length is %d - want %d", len(ciphertext), len(plaintext)+aead.Overhead())
+			}
+			if !bytes.Equal(ciphertext, aead.Seal(nil, nonce, plaintext, associatedData)) {
+				return errors.New("Seal is not deterministic")
+			}
+			opened, err := aead.Open(nil, nonce, ciphertext, associatedData)
+			if err != nil {
+				return fmt.Errorf("failed to open ciphertext: %v", err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				return errors.New("plaintext does not match original plaintext")
+			}
+			return nil
+		},
+	},
+	{
+		Name: "Seal/Open empty plaintext",
+		Check: func(aead cipher.AEAD, rng *rand.Rand) error {
+			nonce, associatedData := randomBytes(rng, aead.NonceSize()), randomBytes(rng, 32)
+			tag := aead.Seal(nil, nonce, nil, associatedData)
+			if len(tag) != aead.Overhead() {
+				return fmt.Errorf("ciphertext length is %d - want %d", len(tag), aead.Overhead())
+			}
+			if opened, err := aead.Open(nil, nonce, tag, associatedData); err != nil || len(opened) != 0 {
+				return fmt.Errorf("failed to open empty ciphertext: %v", err)
+			}
+			return nil
+		},
+	},
+	{
+		Name: "Seal/Open append to dst",
+		Check: func(aead cipher.AEAD, rng *rand.Rand) error {
+			nonce, plaintext, associatedData := randomBytes(rng, aead.NonceSize()), randomBytes(rng, 100), randomBytes(rng, 32)
+			prefix := randomBytes(rng, 7)
+			ciphertext := aead.Seal(bytes.Clone(prefix), nonce, plaintext, associatedData)
+			if !bytes.HasPrefix(ciphertext, prefix) {
+				return errors.New("Seal does not append to dst")
+			}
+			if !bytes.Equal(ciphertext[len(prefix):], aead.Seal(nil, nonce, plaintext, associatedData)) {
+				return errors.New("Seal produces a different ciphertext when appending to dst")
+			}
+			opened, err := aead.Open(bytes.Clone(prefix), nonce, ciphertext[len(prefix):], associatedData)
+			if err != nil {
+				return fmt.Errorf("failed to open ciphertext: %v", err)
+			}
+			if !bytes.HasPrefix(opened, prefix) || !bytes.Equal(opened[len(prefix):], plaintext) {
+				return errors.New("Open does not append to dst")
+			}
+			return nil
+		},
+	},
+	{
+		Name: "Seal/Open in-place",
+		Check: func(aead cipher.AEAD, rng *rand.Rand) error {
+			nonce, plaintext, associatedData := randomBytes(rng, aead.NonceSize()), randomBytes(rng, 1024), randomBytes(rng, 32)
+			ciphertext := aead.Seal(nil, nonce, plaintext, associatedData)
+
+			buffer := make([]byte, len(plaintext), len(ciphertext))
+			copy(buffer, plaintext)
+			if sealed := aead.Seal(buffer[:0], nonce, buffer, associatedData); !bytes.Equal(sealed, ciphertext) {
+				return errors.New("in-place Seal does not match ciphertext")
+			}
+			opened, err := aead.Open(buffer[:0], nonce, buffer[:len(ciphertext)], associatedData)
+			if err != nil {
+				return fmt.Errorf("failed to open ciphertext in-place: %v", err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				return errors.New("in-place Open does not match plaintext")
+			}
+			return nil
+		},
+	},
+	{
+		Name: "Open does not modify ciphertext",
+		Check: func(aead cipher.AEAD, rng *rand.Rand) error {
+			nonce, plaintext, associatedData := randomBytes(rng, aead.NonceSize()), randomBytes(rng, 1024), randomBytes(rng, 32)
+			ciphertext := aead.Seal(nil, nonce, plaintext, associatedData)
+			original := bytes.Clone(ciphertext)
+			if _, err := aead.Open(nil, nonce, ciphertext, associatedData); err != nil {
+				return fmt.Errorf("failed to open ciphertext: %v", err)
+			}
+			if !bytes.Equal(ciphertext, original) {
+				return errors.New("Open modified the ciphertext")
+			}
+			return nil
+		},
+	},
+	{
+		Name: "Open rejects modified data",
+		Check: func(aead cipher.AEAD, rng *rand.Rand) error {
+			nonce, plaintext, associatedData := randomBytes(rng, aead.NonceSize()), randomBytes(rng, 64), randomBytes(rng, 32)
+			ciphertext := aead.Seal(nil, nonce, plaintext, associatedData)
+
+			for i := range ciphertext {
+				modified := bytes.Clone(ciphertext)
+				modified[i] ^= 1 << rng.IntN(8)
+				if _, err := aead.Open(nil, nonce, modified, associatedData); err == nil {
+					return fmt.Errorf("ciphertext modified at byte %d is authentic", i)
+				}
+			}
+			for i := range associatedData {
+				modified := bytes.Clone(associatedData)
+				modified[i] ^= 1 << rng.IntN(8)
+				if _, err := aead.Open(nil, nonce, ciphertext, modified); err == nil {
+					return fmt.Errorf("associated data modified at byte %d is authentic", i)
+				}
+			}
+			for i := range nonce {
+				modified := bytes.Clone(nonce)
+				modified[i] ^= 1 << rng.IntN(8)
+				if _, err := aead.Open(nil, modified, ciphertext, associatedData); err == nil {
+					return fmt.Errorf("nonce modified at byte %d is authentic", i)
+				}
+			}
+			for i := 0; i < len(ciphertext); i++ {
+				if _, err := aead.Open(nil, nonce, ciphertext[:i], associatedData); err == nil {
+					return fmt.Errorf("ciphertext truncated to %d bytes is authentic", i)
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// streamPaths are the checks of the en/decryption
+// paths of a Stream.
+var streamPaths = []struct {
+	Name  string
+	Check func(stream *sio.Stream, test *testCase) error
+}{
+	{Name: "EncWriter.Write", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptWriter(s, func(w *sio.EncWriter, p []byte) error { _, err := w.Write(p); return err })
+	}},
+	{Name: "EncWriter.Write (1 byte)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptWriter(s, func(w *sio.EncWriter, p []byte) error { return writeChunks(w, p, func() int { return 1 }) })
+	}},
+	{Name: "EncWriter.Write (random)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptWriter(s, func(w *sio.EncWriter, p []byte) error { return writeChunks(w, p, t.chunkSize) })
+	}},
+	{Name: "EncWriter.WriteByte", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptWriter(s, func(w *sio.EncWriter, p []byte) error { return writeBytes(w, p) })
+	}},
+	{Name: "EncWriter.ReadFrom", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptWriter(s, func(w *sio.EncWriter, p []byte) error { _, err := w.ReadFrom(bytes.NewReader(p)); return err })
+	}},
+	{Name: "EncWriter.ReadFrom (OneByteReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptWriter(s, func(w *sio.EncWriter, p []byte) error {
+			_, err := w.ReadFrom(iotest.OneByteReader(bytes.NewReader(p)))
+			return err
+		})
+	}},
+	{Name: "EncWriter.ReadFrom (HalfReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptWriter(s, func(w *sio.EncWriter, p []byte) error {
+			_, err := w.ReadFrom(iotest.HalfReader(bytes.NewReader(p)))
+			return err
+		})
+	}},
+	{Name: "EncWriter.ReadFrom (DataErrReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptWriter(s, func(w *sio.EncWriter, p []byte) error {
+			_, err := w.ReadFrom(iotest.DataErrReader(bytes.NewReader(p)))
+			return err
+		})
+	}},
+	{Name: "EncWriter (failing writer)", Check: func(s *sio.Stream, t *testCase) error {
+		for _, limit := range t.limits(len(t.ciphertext)) {
+			w := s.EncryptWriter(&failingWriter{limit: limit}, t.nonce, t.associatedData)
+			_, err := w.Write(t.plaintext)
+			if err == nil {
+				err = w.Close()
+			}
+			if err != errTest {
+				return fmt.Errorf("writer failing after %d bytes: got error %v - want %v", limit, err, errTest)
+			}
+		}
+		return nil
+	}},
+	{Name: "EncReader.Read", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptReader(s, bytes.NewReader(t.plaintext), func(r *sio.EncReader) ([]byte, error) { return io.ReadAll(r) })
+	}},
+	{Name: "EncReader.Read (1 byte)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptReader(s, bytes.NewReader(t.plaintext), func(r *sio.EncReader) ([]byte, error) {
+			return readChunks(r, func() int { return 1 })
+		})
+	}},
+	{Name: "EncReader.Read (random)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptReader(s, bytes.NewReader(t.plaintext), func(r *sio.EncReader) ([]byte, error) { return readChunks(r, t.chunkSize) })
+	}},
+	{Name: "EncReader.Read (OneByteReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptReader(s, iotest.OneByteReader(bytes.NewReader(t.plaintext)), func(r *sio.EncReader) ([]byte, error) { return io.ReadAll(r) })
+	}},
+	{Name: "EncReader.Read (HalfReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptReader(s, iotest.HalfReader(bytes.NewReader(t.plaintext)), func(r *sio.EncReader) ([]byte, error) { return readChunks(r, t.chunkSize) })
+	}},
+	{Name: "EncReader.Read (DataErrReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptReader(s, iotest.DataErrReader(bytes.NewReader(t.plaintext)), func(r *sio.EncReader) ([]byte, error) { return io.ReadAll(r) })
+	}},
+	{Name: "EncReader.ReadByte", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptReader(s, bytes.NewReader(t.plaintext), func(r *sio.EncReader) ([]byte, error) { return readBytes(r) })
+	}},
+	{Name: "EncReader.WriteTo", Check: func(s *sio.Stream, t *testCase) error {
+		return t.encryptReader(s, iotest.HalfReader(bytes.NewReader(t.plaintext)), func(r *sio.EncReader) ([]byte, error) {
+			var b bytes.Buffer
+			_, err := r.WriteTo(&b)
+			return b.Bytes(), err
+		})
+	}},
+	{Name: "EncReader (failing reader)", Check: func(s *sio.Stream, t *testCase) error {
+		for _, limit := range t.limits(len(t.plaintext)) {
+			r := s.EncryptReader(failingReader(t.plaintext, limit), t.nonce, t.associatedData)
+			if _, err := io.ReadAll(r); err != errTest {
+				return fmt.Errorf("reader failing after %d bytes: got error %v - want %v", limit, err, errTest)
+			}
+		}
+		return nil
+	}},
+
+	{Name: "DecWriter.Write", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptWriter(s, t.ciphertext, func(w *sio.DecWriter, p []byte) error { _, err := w.Write(p); return err })
+	}},
+	{Name: "DecWriter.Write (1 byte)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptWriter(s, t.ciphertext, func(w *sio.DecWriter, p []byte) error { return writeChunks(w, p, func() int { return 1 }) })
+	}},
+	{Name: "DecWriter.Write (random)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptWriter(s, t.ciphertext, func(w *sio.DecWriter, p []byte) error { return writeChunks(w, p, t.chunkSize) })
+	}},
+	{Name: "DecWriter.WriteByte", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptWriter(s, t.ciphertext, func(w *sio.DecWriter, p []byte) error { return writeBytes(w, p) })
+	}},
+	{Name: "DecWriter.ReadFrom", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptWriter(s, t.ciphertext, func(w *sio.DecWriter, p []byte) error { _, err := w.ReadFrom(bytes.NewReader(p)); return err })
+	}},
+	{Name: "DecWriter.ReadFrom (OneByteReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptWriter(s, t.ciphertext, func(w *sio.DecWriter, p []byte) error {
+			_, err := w.ReadFrom(iotest.OneByteReader(bytes.NewReader(p)))
+			return err
+		})
+	}},
+	{Name: "DecWriter.ReadFrom (HalfReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptWriter(s, t.ciphertext, func(w *sio.DecWriter, p []byte) error {
+			_, err := w.ReadFrom(iotest.HalfReader(bytes.NewReader(p)))
+			return err
+		})
+	}},
+	{Name: "DecWriter.ReadFrom (DataErrReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptWriter(s, t.ciphertext, func(w *sio.DecWriter, p []byte) error {
+			_, err := w.ReadFrom(iotest.DataErrReader(bytes.NewReader(p)))
+			return err
+		})
+	}},
+	{Name: "DecWriter (failing writer)", Check: func(s *sio.Stream, t *testCase) error {
+		for _, limit := range t.limits(len(t.plaintext)) {
+			w := s.DecryptWriter(&failingWriter{limit: limit}, t.nonce, t.associatedData)
+			_, err := w.Write(t.ciphertext)
+			if err == nil {
+				err = w.Close()
+			}
+			if err != errTest {
+				return fmt.Errorf("writer failing after %d bytes: got error %v - want %v", limit, err, errTest)
+			}
+		}
+		return nil
+	}},
+	{Name: "DecWriter (modified ciphertext)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptWriter(s, t.modify(), func(w *sio.DecWriter, p []byte) error { _, err := w.Write(p); return err })
+	}},
+	{Name: "DecWriter (truncated ciphertext)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptWriter(s, t.truncate(), func(w *sio.DecWriter, p []byte) error { _, err := w.Write(p); return err })
+	}},
+	{Name: "DecReader.Read", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptReader(s, bytes.NewReader(t.ciphertext), t.ciphertext, func(r *sio.DecReader) ([]byte, error) { return io.ReadAll(r) })
+	}},
+	{Name: "DecReader.Read (1 byte)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptReader(s, bytes.NewReader(t.ciphertext), t.ciphertext, func(r *sio.DecReader) ([]byte, error) {
+			return readChunks(r, func() int { return 1 })
+		})
+	}},
+	{Name: "DecReader.Read (random)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptReader(s, bytes.NewReader(t.ciphertext), t.ciphertext, func(r *sio.DecReader) ([]byte, error) { return readChunks(r, t.chunkSize) })
+	}},
+	{Name: "DecReader.Read (OneByteReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptReader(s, iotest.OneByteReader(bytes.NewReader(t.ciphertext)), t.ciphertext, func(r *sio.DecReader) ([]byte, error) { return io.ReadAll(r) })
+	}},
+	{Name: "DecReader.Read (HalfReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptReader(s, iotest.HalfReader(bytes.NewReader(t.ciphertext)), t.ciphertext, func(r *sio.DecReader) ([]byte, error) { return readChunks(r, t.chunkSize) })
+	}},
+	{Name: "DecReader.Read (DataErrReader)", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptReader(s, iotest.DataErrReader(bytes.NewReader(t.ciphertext)), t.ciphertext, func(r *sio.DecReader) ([]byte, error) { return io.ReadAll(r) })
+	}},
+	{Name: "DecReader.ReadByte", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptReader(s, bytes.NewReader(t.ciphertext), t.ciphertext, func(r *sio.DecReader) ([]byte, error) { return readBytes(r) })
+	}},
+	{Name: "DecReader.WriteTo", Check: func(s *sio.Stream, t *testCase) error {
+		return t.decryptReader(s, iotest.HalfReader(bytes.NewReader(t.ciphertext)), t.ciphertext, func(r *sio.DecReader) ([]byte, error) {
+			var b bytes.Buffer
+			_, err := r.WriteTo(&b)
+			return b.Bytes(), err
+		})
+	}},
+	{Name: "DecReader (failing reader)", Check: func(s *sio.Stream, t *testCase) error {
+		for _, limit := range t.limits(len(t.ciphertext)) {
+			r := s.DecryptReader(failingReader(t.ciphertext, limit), t.nonce, t.associatedData)
+			if _, err := io.ReadAll(r); err != errTest {
+				return fmt.Errorf("reader failing after %d bytes: got error %v - want %v", limit, err, errTest)
+			}
+		}
+		return nil
+	}},
+	{Name: "DecReader (modified ciphertext)", Check: func(s *sio.Stream, t *testCase) error {
+		ciphertext := t.modify()
+		return t.decryptReader(s, bytes.NewReader(ciphertext), ciphertext, func(r *sio.DecReader) ([]byte, error) { return io.ReadAll(r) })
+	}},
+	{Name: "DecReader (truncated ciphertext)", Check: func(s *sio.Stream, t *testCase) error {
+		ciphertext := t.truncate()
+		return t.decryptReader(s, bytes.NewReader(ciphertext), ciphertext, func(r *sio.DecReader) ([]byte, error) { return io.ReadAll(r) })
+	}},
+	{Name: "DecReaderAt.ReadAt", Check: func(s *sio.Stream, t *testCase) error {
+		r := s.DecryptReaderAt(bytes.NewReader(t.ciphertext), t.nonce, t.associatedData)
+		for _, offset := range t.offsets() {
+			p := make([]byte, len(t.plaintext)-offset)
+			n, err := r.ReadAt(p, int64(offset))
+			if n != len(p) || (err != nil && err != io.EOF) {
+				return fmt.Errorf("offset %d: read %d of %d bytes: %v", offset, n, len(p), err)
+			}
+			if !bytes.Equal(p, t.plaintext[offset:]) {
+				return fmt.Errorf("offset %d: plaintext does not match original plaintext", offset)
+			}
+			if offset < len(t.plaintext) {
+				b := make([]byte, 1)
+				if _, err = r.ReadAt(b, int64(offset)); (err != nil && err != io.EOF) || b[0] != t.plaintext[offset] {
+					return fmt.Errorf("offset %d: failed to read single byte: %v", offset, err)
+				}
+			}
+		}
+		if n, err := r.ReadAt(make([]byte, 1), int64(len(t.plaintext))); n != 0 || err != io.EOF {
+			return fmt.Errorf("reading beyond the end of the stream: got %d bytes and error %v - want 0 bytes and %v", n, err, io.EOF)
+		}
+		return nil
+	}},
+	{Name: "DecReaderAt.ReadAt (section)", Check: func(s *sio.Stream, t *testCase) error {
+		r := s.DecryptReaderAt(bytes.NewReader(t.ciphertext), t.nonce, t.associatedData)
+		plaintext, err := readChunks(io.NewSectionReader(r, 0, math.MaxInt64), t.chunkSize)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(plaintext, t.plaintext) {
+			return errors.New("plaintext does not match original plaintext")
+		}
+		return nil
+	}},
+	{Name: "DecReaderAt (modified ciphertext)", Check: func(s *sio.Stream, t *testCase) error {
+		r := s.DecryptReaderAt(bytes.NewReader(t.modify()), t.nonce, t.associatedData)
+		if _, err := io.ReadAll(io.NewSectionReader(r, 0, math.MaxInt64)); err != sio.NotAuthentic {
+			return fmt.Errorf("got error %v - want %v", err, sio.NotAuthentic)
+		}
+		return nil
+	}},
+}
+
+// encryptWriter encrypts the plaintext using an EncWriter
+// and compares the ciphertext to the expected ciphertext.
+func (t *testCase) encryptWriter(s *sio.Stream, write func(*sio.EncWriter, []byte) error) error {
+	var ciphertext bytes.Buffer
+	w := s.EncryptWriter(&ciphertext, t.nonce, t.associatedData)
+	if err := write(w, t.plaintext); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if !bytes.Equal(ciphertext.Bytes(), t.ciphertext) {
+		return errors.New("ciphertext does not match expected ciphertext")
+	}
+	return nil
+}
+
+// encryptReader encrypts the plaintext using an EncReader
+// and compares the ciphertext to the expected ciphertext.
+func (t *testCase) encryptReader(s *sio.Stream, src io.Reader, read func(*sio.EncReader) ([]byte, error)) error {
+	ciphertext, err := read(s.EncryptReader(src, t.nonce, t.associatedData))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(ciphertext, t.ciphertext) {
+		return errors.New("ciphertext does not match expected ciphertext")
+	}
+	return nil
+}
+
+// decryptWriter decrypts the ciphertext using a DecWriter. If
+// the ciphertext is the expected ciphertext, it compares the
+// plaintext to the expected plaintext. Otherwise, decryption
+// must fail with sio.NotAuthentic.
+func (t *testCase) decryptWriter(s *sio.Stream, ciphertext []byte, write func(*sio.DecWriter, []byte) error) error {
+	var plaintext bytes.Buffer
+	w := s.DecryptWriter(&plaintext, t.nonce, t.associatedData)
+	err := write(w, ciphertext)
+	if err == nil {
+		err = w.Close()
+	}
+	return t.verify(ciphertext, plaintext.Bytes(), err)
+}
+
+// decryptReader decrypts the ciphertext using a DecReader. If
+// the ciphertext is the expected ciphertext, it compares the
+// plaintext to the expected plaintext. Otherwise, decryption
+// must fail with sio.NotAuthentic.
+func (t *testCase) decryptReader(s *sio.Stream, src io.Reader, ciphertext []byte, read func(*sio.DecReader) ([]byte, error)) error {
+	plaintext, err := read(s.DecryptReader(src, t.nonce, t.associatedData))
+	return t.verify(ciphertext, plaintext, err)
+}
+
+func (t *testCase) verify(ciphertext, plaintext []byte, err error) error {
+	if !bytes.Equal(ciphertext, t.ciphertext) {
+		if err != sio.NotAuthentic {
+			return fmt.Errorf("invalid ciphertext: got error %v - want %v", err, sio.NotAuthentic)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(plaintext, t.plaintext) {
+		return errors.New("plaintext does not match original plaintext")
+	}
+	return nil
+}
+
+// modify returns a copy of the ciphertext with one bit flipped.
+func (t *testCase) modify() []byte {
+	ciphertext := bytes.Clone(t.ciphertext)
+	ciphertext[t.rng.IntN(len(ciphertext))] ^= 1 << t.rng.IntN(8)
+	return ciphertext
+}
+
+// truncate returns the ciphertext without its final fragment.
+// If the ciphertext consists of a single fragment, it returns
+// the ciphertext without its last byte.
+func (t *testCase) truncate() []byte {
+	if len(t.plaintext) <= t.bufSize {
+		return t.ciphertext[:len(t.ciphertext)-1]
+	}
+	fragmentSize := t.bufSize + t.overhead
+	fragments := (len(t.ciphertext) + fragmentSize - 1) / fragmentSize
+	return t.ciphertext[:(fragments-1)*fragmentSize]
+}
+
+// offsets returns the plaintext offsets at which the
+// DecReaderAt starts reading. For large data streams,
+// it returns the offsets around the fragment boundaries.
+func (t *testCase) offsets() []int {
+	var offsets []int
+	for offset := 0; offset <= len(t.plaintext); offset++ {
+		if k := offset % t.bufSize; len(t.plaintext) <= 1024 || k <= 1 || k == t.bufSize-1 || offset == len(t.plaintext) {
+			offsets = append(offsets, offset)
+		}
+	}
+	return offsets
+}
+
+// limits returns the positions at which the
+// failing readers and writers return an error.
+func (t *testCase) limits(n int) []int {
+	if n == 0 {
+		return nil
+	}
+	return []int{0, n / 2, n - 1}
+}
+
+// chunkSize returns a random chunk size for
+// reading and writing a data stream.
+func (t *testCase) chunkSize() int { return 1 + t.rng.IntN(100) }
+
+func writeChunks(w io.Writer, p []byte, chunkSize func() int) error {
+	for len(p) > 0 {
+		n := min(chunkSize(), len(p))
+		if _, err := w.Write(p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+func writeBytes(w io.ByteWriter, p []byte) error {
+	for _, b := range p {
+		if err := w.WriteByte(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readChunks(r io.Reader, chunkSize func() int) ([]byte, error) {
+	var data []byte
+	for {
+		p := make([]byte, chunkSize())
+		n, err := r.Read(p)
+		if n < 0 || n > len(p) {
+			return nil, fmt.Errorf("Read returned %d bytes for a %d byte buffer", n, len(p))
+		}
+		data = append(data, p[:n]...)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readBytes(r io.ByteReader) ([]byte, error) {
+	var data []byte
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b)
+	}
+}
+
+// failingReader returns an io.Reader that returns
+// the first limit bytes of p and then fails.
+func failingReader(p []byte, limit int) io.Reader {
+	return io.MultiReader(bytes.NewReader(p[:limit]), iotest.ErrReader(errTest))
+}
+
+// failingWriter is an io.Writer that accepts
+// limit bytes and then fails.
+type failingWriter struct {
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errTest
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
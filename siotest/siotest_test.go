@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package siotest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestAEADConformance(t *testing.T) {
+	if err := TestAEAD(newAESGCM, 16); err != nil {
+		t.Fatalf("AES-GCM: %v", err)
+	}
+	if err := TestAEAD(chacha20poly1305.NewX, chacha20poly1305.KeySize); err != nil {
+		t.Fatalf("XChaCha20-Poly1305: %v", err)
+	}
+}
+
+func TestAEADNonConformance(t *testing.T) {
+	brokenAEADs := map[string]func([]byte) (cipher.AEAD, error){
+		"small nonce": func(key []byte) (cipher.AEAD, error) {
+			block, _ := aes.NewCipher(key)
+			return cipher.NewGCMWithNonceSize(block, 3)
+		},
+		"not in-place": func(key []byte) (cipher.AEAD, error) {
+			aead, err := newAESGCM(key)
+			return notInPlaceAEAD{aead}, err
+		},
+		"accepts anything": func(key []byte) (cipher.AEAD, error) {
+			aead, err := newAESGCM(key)
+			return acceptingAEAD{aead}, err
+		},
+	}
+	for name, newAEAD := range brokenAEADs {
+		if err := TestAEAD(newAEAD, 16); err == nil {
+			t.Fatalf("%s: broken AEAD passed the conformance test", name)
+		}
+	}
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// notInPlaceAEAD does not support in-place
+// en/decryption.
+type notInPlaceAEAD struct{ cipher.AEAD }
+
+func (a notInPlaceAEAD) Seal(dst, nonce, plaintext, associatedData []byte) []byte {
+	ciphertext := a.AEAD.Seal(nil, nonce, plaintext, associatedData)
+	clear(plaintext)
+	return append(dst, ciphertext...)
+}
+
+// acceptingAEAD accepts any ciphertext.
+type acceptingAEAD struct{ cipher.AEAD }
+
+func (a acceptingAEAD) Open(dst, nonce, ciphertext, associatedData []byte) ([]byte, error) {
+	plaintext, err := a.AEAD.Open(dst, nonce, ciphertext, associatedData)
+	if err != nil {
+		return append(dst, ciphertext[:len(ciphertext)-a.Overhead()]...), nil
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+
+	"github.com/secure-io/sio-go"
+)
+
+// DeriveSaltSize is the size of the random salt
+// used by a KeyDeriver to derive per-object Streams.
+const DeriveSaltSize = 32
+
+// minMasterKeySize is the minimum size of a master key.
+const minMasterKeySize = 16
+
+// A KeyDeriver derives a unique Stream and nonce for each object
+// from a master key using HKDF-SHA-256.
+//
+// The Stream key and nonce are derived from the master key, the
+// object ID and a random salt. Hence, each object is encrypted
+// with its own key - even if the same object ID is used multiple
+// times. Since the nonce is unique per key, the nonce size of the
+// algorithm - e.g. the 8 byte nonce of AES-GCM - does not limit
+// the number of objects.
+//
+// The salt is not secret but must be stored with the object.
+// For example:
+//
+//	stream, nonce, salt, err := deriver.NewStream([]byte("bucket/object"))
+//	if err != nil {
+//		// TODO: error handling
+//	}
+//	// Store the salt, then encrypt the object:
+//	enc := stream.EncryptWriter(w, nonce, nil)
+//
+// To decrypt the object, derive the same Stream and nonce again:
+//
+//	stream, nonce, err := deriver.Stream([]byte("bucket/object"), salt)
+type KeyDeriver struct {
+	masterKey []byte
+	algorithm sio.Algorithm
+	bufSize   int
+}
+
+// NewKeyDeriver returns a new KeyDeriver that derives Streams for
+// the algorithm and buffer size from the master key. The master
+// key must be at least 16 bytes long.
+func NewKeyDeriver(masterKey []byte, a sio.Algorithm, bufSize int) (*KeyDeriver, error) {
+	if len(masterKey) < minMasterKeySize {
+		return nil, errors.New("sioutil: master key is too short: " + strconv.Itoa(len(masterKey)) + " bytes - at least " + strconv.Itoa(minMasterKeySize) + " bytes are required")
+	}
+	if a.ID() == 0 {
+		return nil, sio.UnknownAlgorithmError{Name: a.String()}
+	}
+	if bufSize <= 0 || bufSize > sio.MaxBufSize {
+		return nil, errors.New("sioutil: invalid buffer size " + strconv.Itoa(bufSize))
+	}
+	return &KeyDeriver{
+		masterKey: append([]byte(nil), masterKey...),
+		algorithm: a,
+		bufSize:   bufSize,
+	}, nil
+}
+
+// Algorithm returns the algorithm of the derived Streams.
+func (d *KeyDeriver) Algorithm() sio.Algorithm { return d.algorithm }
+
+// NewStream returns a new Stream and nonce for the object
+// derived with a random salt. The salt must be stored with
+// the object to derive the same Stream and nonce again.
+func (d *KeyDeriver) NewStream(objectID []byte) (stream *sio.Stream, nonce, salt []byte, err error) {
+	salt, err = Random(DeriveSaltSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stream, nonce, err = d.Stream(objectID, salt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return stream, nonce, salt, nil
+}
+
+// Stream returns the Stream and nonce for the object
+// derived with the salt. The salt must be DeriveSaltSize
+// bytes long.
+func (d *KeyDeriver) Stream(objectID, salt []byte) (*sio.Stream, []byte, error) {
+	if len(salt) != DeriveSaltSize {
+		return nil, nil, errors.New("sioutil: invalid salt size " + strconv.Itoa(len(salt)))
+	}
+
+	// The algorithm name does not contain a zero byte.
+	// Therefore, the info uniquely encodes the algorithm
+	// and the object ID.
+	info := "sio: object key for " + d.algorithm.String() + "\x00" + string(objectID)
+	keySize, nonceSize := d.algorithm.KeySize(), d.algorithm.StreamNonceSize()
+	derived, err := hkdf.Key(sha256.New, d.masterKey, salt, info, keySize+nonceSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	stream, err := d.algorithm.StreamWithBufSize(derived[:keySize], d.bufSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stream, derived[keySize:], nil
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+func TestKeyDeriver(t *testing.T) {
+	deriver, err := NewKeyDeriver(MustRandom(32), sio.AES_128_GCM, sio.BufSize)
+	if err != nil {
+		t.Fatalf("Failed to create KeyDeriver: %v", err)
+	}
+	objectID := []byte("bucket/object")
+
+	stream, nonce, salt, err := deriver.NewStream(objectID)
+	if err != nil {
+		t.Fatalf("Failed to derive Stream: %v", err)
+	}
+	if len(nonce) != stream.NonceSize() || len(salt) != DeriveSaltSize {
+		t.Fatalf("Invalid nonce or salt size: %d %d", len(nonce), len(salt))
+	}
+	plaintext := MustRandom(1000)
+	var ciphertext bytes.Buffer
+	w := stream.EncryptWriter(&ciphertext, nonce, nil)
+	if _, err = w.Write(plaintext); err != nil {
+		t.Fatalf("Failed to encrypt plaintext: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Failed to close EncWriter: %v", err)
+	}
+
+	stream, nonce2, err := deriver.Stream(objectID, salt)
+	if err != nil {
+		t.Fatalf("Failed to derive Stream: %v", err)
+	}
+	if !bytes.Equal(nonce, nonce2) {
+		t.Fatal("Derived nonces do not match")
+	}
+	var output bytes.Buffer
+	if _, err = stream.DecryptReader(&ciphertext, nonce, nil).WriteTo(&output); err != nil {
+		t.Fatalf("Failed to decrypt data stream: %v", err)
+	}
+	if !bytes.Equal(output.Bytes(), plaintext) {
+		t.Fatal("Plaintext does not match original plaintext")
+	}
+}
+
+func TestKeyDeriverUnique(t *testing.T) {
+	deriver, err := NewKeyDeriver(MustRandom(32), sio.AES_128_GCM, sio.BufSize)
+	if err != nil {
+		t.Fatalf("Failed to create KeyDeriver: %v", err)
+	}
+	other, err := NewKeyDeriver(MustRandom(32), sio.AES_128_GCM, sio.BufSize)
+	if err != nil {
+		t.Fatalf("Failed to create KeyDeriver: %v", err)
+	}
+	salt := MustRandom(DeriveSaltSize)
+
+	// The same plaintext encrypted for different object IDs, salts
+	// or master keys must produce different ciphertexts.
+	encrypt := func(d *KeyDeriver, objectID, salt []byte) []byte {
+		stream, nonce, err := d.Stream(objectID, salt)
+		if err != nil {
+			t.Fatalf("Failed to derive Stream: %v", err)
+		}
+		var ciphertext bytes.Buffer
+		w := stream.EncryptWriter(&ciphertext, nonce, nil)
+		w.Write(make([]byte, 64))
+		if err = w.Close(); err != nil {
+			t.Fatalf("Failed to close EncWriter: %v", err)
+		}
+		return ciphertext.Bytes()
+	}
+	ciphertexts := [][]byte{
+		encrypt(deriver, []byte("object-1"), salt),
+		encrypt(deriver, []byte("object-2"), salt),
+		encrypt(deriver, []byte("object-1"), MustRandom(DeriveSaltSize)),
+		encrypt(other, []byte("object-1"), salt),
+	}
+	for i := range ciphertexts {
+		for j := i + 1; j < len(ciphertexts); j++ {
+			if bytes.Equal(ciphertexts[i], ciphertexts[j]) {
+				t.Fatalf("Ciphertexts %d and %d are equal", i, j)
+			}
+		}
+	}
+	if !bytes.Equal(ciphertexts[0], encrypt(deriver, []byte("object-1"), salt)) {
+		t.Fatal("Key derivation is not deterministic")
+	}
+}
+
+func TestNewKeyDeriverInvalid(t *testing.T) {
+	if _, err := NewKeyDeriver(MustRandom(15), sio.AES_128_GCM, sio.BufSize); err == nil {
+		t.Fatal("Creating a KeyDeriver with a short master key should fail")
+	}
+	if _, err := NewKeyDeriver(MustRandom(32), "unknown", sio.BufSize); err == nil {
+		t.Fatal("Creating a KeyDeriver with an unknown algorithm should fail")
+	}
+	if _, err := NewKeyDeriver(MustRandom(32), sio.AES_128_GCM, 0); err == nil {
+		t.Fatal("Creating a KeyDeriver with an invalid buffer size should fail")
+	}
+	deriver, err := NewKeyDeriver(MustRandom(32), sio.AES_128_GCM, sio.BufSize)
+	if err != nil {
+		t.Fatalf("Failed to create KeyDeriver: %v", err)
+	}
+	if _, _, err = deriver.Stream(nil, MustRandom(DeriveSaltSize-1)); err == nil {
+		t.Fatal("Deriving a Stream with an invalid salt should fail")
+	}
+}
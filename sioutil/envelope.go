@@ -0,0 +1,409 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/secure-io/sio-go"
+)
+
+// FileKeySize is the size of the random file key of an
+// envelope. Recipients wrap the file key and identities
+// unwrap it.
+const FileKeySize = 32
+
+// A Stanza is a file key wrapped for one recipient.
+type Stanza struct {
+	// Type identifies the recipient type - e.g. "X25519".
+	// It must not be empty and at most 255 bytes long.
+	Type string
+
+	// Body is the wrapped file key and any additional
+	// data required to unwrap it. It must be at most
+	// 65535 bytes long.
+	Body []byte
+}
+
+// A Recipient wraps the file key of an envelope.
+type Recipient interface {
+	Wrap(fileKey []byte) (*Stanza, error)
+}
+
+// An Identity unwraps the file key of an envelope.
+//
+// Unwrap returns ErrIncorrectIdentity if the stanza has
+// not been created for the identity. Any other error
+// aborts opening the envelope.
+type Identity interface {
+	Unwrap(stanza *Stanza) ([]byte, error)
+}
+
+// ErrIncorrectIdentity is returned by an Identity if
+// a stanza has not been created for the identity.
+var ErrIncorrectIdentity = errors.New("sioutil: incorrect identity for recipient stanza")
+
+// ErrNoIdentityMatch is returned by OpenEnvelope if none
+// of the identities can unwrap any file key of the envelope.
+var ErrNoIdentityMatch = errors.New("sioutil: no identity matches any recipient")
+
+// envelopeMagic is the first part of every envelope header.
+// Its last byte is the version of the header format.
+const envelopeMagic = "SIOE\x01"
+
+// envelopeMACSize is the size of the header MAC.
+const envelopeMACSize = sha256.Size
+
+// An Envelope is a Stream with a random key that is wrapped
+// for one or multiple recipients.
+//
+// The Header contains the algorithm, buffer size and nonce as
+// well as the wrapped file keys. It must be stored in front of
+// the encrypted data stream. The data stream itself is an
+// ordinary sio data stream that the Envelope en/decrypts with
+// the Nonce and the AssociatedData. For example:
+//
+//	envelope, err := sioutil.NewEnvelope(sio.XChaCha20Poly1305, sio.BufSize, recipients...)
+//	if err != nil {
+//		// TODO: error handling
+//	}
+//	if _, err = w.Write(envelope.Header); err != nil {
+//		// TODO: error handling
+//	}
+//	enc := envelope.EncryptWriter(w)
+//
+// The AssociatedData is the part of the header that contains the
+// algorithm, buffer size and nonce. The remaining header, including
+// the wrapped file keys, is authenticated by a MAC computed with a
+// key derived from the file key.
+type Envelope struct {
+	stream *sio.Stream
+
+	Algorithm      sio.Algorithm
+	Nonce          []byte
+	AssociatedData []byte
+	Header         []byte
+	Stanzas        []Stanza
+
+	fileKey []byte
+}
+
+// NewEnvelope returns a new Envelope with a random file key
+// and a random nonce. The file key is wrapped for each of the
+// recipients. At least one and at most 255 recipients must be
+// specified.
+func NewEnvelope(a sio.Algorithm, bufSize int, recipients ...Recipient) (*Envelope, error) {
+	fileKey, err := Random(FileKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return newEnvelope(a, bufSize, fileKey, recipients)
+}
+
+// newEnvelope returns a new Envelope with the
+// file key and a random nonce.
+func newEnvelope(a sio.Algorithm, bufSize int, fileKey []byte, recipients []Recipient) (*Envelope, error) {
+	if len(recipients) == 0 || len(recipients) > math.MaxUint8 {
+		return nil, errors.New("sioutil: invalid number of recipients " + strconv.Itoa(len(recipients)))
+	}
+	if a.ID() == 0 {
+		return nil, sio.UnknownAlgorithmError{Name: a.String()}
+	}
+	if bufSize <= 0 || bufSize > sio.MaxBufSize {
+		return nil, errors.New("sioutil: invalid buffer size " + strconv.Itoa(bufSize))
+	}
+
+	nonce, err := Random(a.StreamNonceSize())
+	if err != nil {
+		return nil, err
+	}
+	associatedData := make([]byte, 0, len(envelopeMagic)+1+4+len(nonce))
+	associatedData = append(associatedData, envelopeMagic...)
+	associatedData = append(associatedData, a.ID())
+	associatedData = binary.BigEndian.AppendUint32(associatedData, uint32(bufSize))
+	associatedData = append(associatedData, nonce...)
+
+	stream, err := newEnvelopeStream(a, bufSize, fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	e := &Envelope{
+		stream:         stream,
+		Algorithm:      a,
+		Nonce:          nonce,
+		AssociatedData: associatedData,
+		fileKey:        fileKey,
+	}
+	if err = e.wrap(recipients); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// OpenEnvelope reads an envelope header, as written by NewEnvelope,
+// from r and unwraps the file key with the first identity that
+// matches any of its recipients. Afterwards, r is positioned at
+// the start of the encrypted data stream. For example:
+//
+//	envelope, err := sioutil.OpenEnvelope(r, identities...)
+//	if err != nil {
+//		// TODO: error handling
+//	}
+//	dec := envelope.DecryptReader(r)
+//
+// To decrypt the data stream at random offsets, use DecryptReaderAt
+// with an io.SectionReader that starts at len(envelope.Header).
+//
+// If no identity matches any recipient, OpenEnvelope returns
+// ErrNoIdentityMatch. If the header has been modified, it
+// returns sio.NotAuthentic.
+func OpenEnvelope(r io.Reader, identities ...Identity) (*Envelope, error) {
+	header := make([]byte, len(envelopeMagic)+1+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errEnvelopeHeader(err)
+	}
+	if string(header[:len(envelopeMagic)]) != envelopeMagic {
+		return nil, errors.New("sioutil: invalid envelope header")
+	}
+	a, err := sio.AlgorithmFromID(header[5])
+	if err != nil {
+		return nil, err
+	}
+	bufSize := int(binary.BigEndian.Uint32(header[6:]))
+	if bufSize <= 0 || bufSize > sio.MaxBufSize {
+		return nil, errors.New("sioutil: invalid envelope header: invalid buffer size " + strconv.Itoa(bufSize))
+	}
+
+	if header, err = readN(r, header, a.StreamNonceSize()+1); err != nil {
+		return nil, err
+	}
+	adSize := len(header) - 1
+	if header[adSize] == 0 {
+		return nil, errors.New("sioutil: invalid envelope header: no recipients")
+	}
+
+	// The offsets of the stanza types and bodies within the header.
+	// The header is sliced once it has been read completely since
+	// reading may re-allocate the header.
+	type stanzaOffset struct{ typ, body, end int }
+	offsets := make([]stanzaOffset, header[adSize])
+	for i := range offsets {
+		if header, err = readN(r, header, 1); err != nil {
+			return nil, err
+		}
+		offsets[i].typ = len(header)
+		typeSize := int(header[len(header)-1])
+		if typeSize == 0 {
+			return nil, errors.New("sioutil: invalid envelope header: empty recipient type")
+		}
+		if header, err = readN(r, header, typeSize+2); err != nil {
+			return nil, err
+		}
+		offsets[i].body = len(header)
+		if header, err = readN(r, header, int(binary.BigEndian.Uint16(header[len(header)-2:]))); err != nil {
+			return nil, err
+		}
+		offsets[i].end = len(header)
+	}
+	if header, err = readN(r, header, envelopeMACSize); err != nil {
+		return nil, err
+	}
+
+	stanzas := make([]Stanza, len(offsets))
+	for i, offset := range offsets {
+		stanzas[i] = Stanza{
+			Type: string(header[offset.typ : offset.body-2]),
+			Body: header[offset.body:offset.end:offset.end],
+		}
+	}
+	nonce, associatedData := header[adSize-a.StreamNonceSize():adSize:adSize], header[:adSize:adSize]
+
+	fileKey, err := unwrap(stanzas, identities)
+	if err != nil {
+		return nil, err
+	}
+	mac := header[len(header)-envelopeMACSize:]
+	if !hmac.Equal(mac, envelopeMAC(fileKey, header[:len(header)-envelopeMACSize])) {
+		return nil, sio.NotAuthentic
+	}
+
+	stream, err := newEnvelopeStream(a, bufSize, fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		stream:         stream,
+		Algorithm:      a,
+		Nonce:          nonce,
+		AssociatedData: associatedData,
+		Header:         header,
+		Stanzas:        stanzas,
+		fileKey:        fileKey,
+	}, nil
+}
+
+// BufSize returns the buffer size of the data stream.
+func (e *Envelope) BufSize() int { return e.stream.BufSize() }
+
+// EncryptWriter returns a new EncWriter that wraps w and
+// encrypts and authenticates everything before writing it
+// to w. It uses the Nonce and the AssociatedData.
+//
+// The EncWriter does not write the Header. It must be
+// written to w before.
+func (e *Envelope) EncryptWriter(w io.Writer) *sio.EncWriter {
+	return e.stream.EncryptWriter(w, e.Nonce, e.AssociatedData)
+}
+
+// DecryptReader returns a new DecReader that wraps r and
+// decrypts and verifies everything it reads from r. It
+// uses the Nonce and the AssociatedData.
+//
+// The r must be positioned at the start of the encrypted
+// data stream - i.e. after the Header.
+func (e *Envelope) DecryptReader(r io.Reader) *sio.DecReader {
+	return e.stream.DecryptReader(r, e.Nonce, e.AssociatedData)
+}
+
+// DecryptReaderAt returns a new DecReaderAt that wraps r and
+// decrypts and verifies everything it reads from r. It uses
+// the Nonce and the AssociatedData.
+//
+// The offset 0 of r must be the start of the encrypted data
+// stream - e.g. an io.SectionReader that starts after the Header.
+func (e *Envelope) DecryptReaderAt(r io.ReaderAt) *sio.DecReaderAt {
+	return e.stream.DecryptReaderAt(r, e.Nonce, e.AssociatedData)
+}
+
+// Rewrap wraps the file key of the envelope for the recipients
+// and replaces the Header and Stanzas. The Nonce, AssociatedData
+// and the file key remain unchanged. Hence, an encrypted data stream
+// can be rewrapped - e.g. for a new set of recipients or a new
+// master key - by replacing its header. See: RewrapEnvelope.
+//
+// At least one and at most 255 recipients must be specified.
+func (e *Envelope) Rewrap(recipients ...Recipient) error {
+	if len(recipients) == 0 || len(recipients) > math.MaxUint8 {
+		return errors.New("sioutil: invalid number of recipients " + strconv.Itoa(len(recipients)))
+	}
+	return e.wrap(recipients)
+}
+
+// RewrapEnvelope reads an envelope header from src, unwraps the file
+// key with the identities and writes a new header for the recipients
+// to dst. Then, it copies the encrypted data stream from src to dst
+// without modifying it. It returns the number of bytes written to dst.
+//
+// RewrapEnvelope does not decrypt the data stream. Hence, it does not
+// detect whether the data stream has been modified.
+func RewrapEnvelope(dst io.Writer, src io.Reader, identities []Identity, recipients ...Recipient) (int64, error) {
+	e, err := OpenEnvelope(src, identities...)
+	if err != nil {
+		return 0, err
+	}
+	if err = e.Rewrap(recipients...); err != nil {
+		return 0, err
+	}
+	n, err := dst.Write(e.Header)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := io.Copy(dst, src)
+	return int64(n) + m, err
+}
+
+// wrap wraps the file key for all recipients and
+// computes the header.
+func (e *Envelope) wrap(recipients []Recipient) error {
+	stanzas := make([]Stanza, 0, len(recipients))
+	header := append(make([]byte, 0, 512), e.AssociatedData...)
+	header = append(header, byte(len(recipients)))
+	for _, recipient := range recipients {
+		stanza, err := recipient.Wrap(e.fileKey)
+		if err != nil {
+			return err
+		}
+		if stanza.Type == "" || len(stanza.Type) > math.MaxUint8 {
+			return errors.New("sioutil: invalid recipient type '" + stanza.Type + "'")
+		}
+		if len(stanza.Body) > math.MaxUint16 {
+			return errors.New("sioutil: " + stanza.Type + " stanza is too large")
+		}
+		header = append(header, byte(len(stanza.Type)))
+		header = append(header, stanza.Type...)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(stanza.Body)))
+		header = append(header, stanza.Body...)
+		stanzas = append(stanzas, *stanza)
+	}
+	e.Header = append(header, envelopeMAC(e.fileKey, header)...)
+	e.Stanzas = stanzas
+	return nil
+}
+
+// unwrap returns the file key unwrapped by the first
+// identity that matches any of the stanzas.
+func unwrap(stanzas []Stanza, identities []Identity) ([]byte, error) {
+	for _, identity := range identities {
+		for i := range stanzas {
+			fileKey, err := identity.Unwrap(&stanzas[i])
+			if err == ErrIncorrectIdentity {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(fileKey) != FileKeySize {
+				return nil, errors.New("sioutil: invalid file key size " + strconv.Itoa(len(fileKey)))
+			}
+			return fileKey, nil
+		}
+	}
+	return nil, ErrNoIdentityMatch
+}
+
+// newEnvelopeStream returns the Stream with the stream
+// key derived from the file key.
+func newEnvelopeStream(a sio.Algorithm, bufSize int, fileKey, nonce []byte) (*sio.Stream, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nonce, "sio: envelope stream key for "+a.String(), a.KeySize())
+	if err != nil {
+		return nil, err
+	}
+	return a.StreamWithBufSize(key, bufSize)
+}
+
+// envelopeMAC returns the MAC of the header computed
+// with a key derived from the file key.
+func envelopeMAC(fileKey, header []byte) []byte {
+	key, err := hkdf.Key(sha256.New, fileKey, nil, "sio: envelope header MAC", sha256.Size)
+	if err != nil {
+		panic("sioutil: failed to derive envelope MAC key: " + err.Error())
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(header)
+	return mac.Sum(nil)
+}
+
+// readN reads n bytes from r and appends them to b.
+func readN(r io.Reader, b []byte, n int) ([]byte, error) {
+	b = append(b, make([]byte, n)...)
+	if _, err := io.ReadFull(r, b[len(b)-n:]); err != nil {
+		return nil, errEnvelopeHeader(err)
+	}
+	return b, nil
+}
+
+func errEnvelopeHeader(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("sioutil: invalid envelope header: data is too short")
+	}
+	return err
+}
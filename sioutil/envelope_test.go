@@ -0,0 +1,148 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+func sealEnvelope(t *testing.T, plaintext []byte, a sio.Algorithm, bufSize int, recipients ...Recipient) []byte {
+	envelope, err := NewEnvelope(a, bufSize, recipients...)
+	if err != nil {
+		t.Fatalf("Failed to create envelope: %v", err)
+	}
+	return encryptStream(t, envelope.Header, envelope.EncryptWriter, plaintext)
+}
+
+func openEnvelope(ciphertext []byte, identities ...Identity) ([]byte, error) {
+	return decryptStream(ciphertext, func(r io.Reader) (*Envelope, error) { return OpenEnvelope(r, identities...) })
+}
+
+func generateX25519Identities(t *testing.T, n int) ([]*X25519Identity, []Recipient) {
+	identities := make([]*X25519Identity, n)
+	recipients := make([]Recipient, n)
+	for i := range identities {
+		identity, err := GenerateX25519Identity()
+		if err != nil {
+			t.Fatalf("Failed to generate X25519 identity: %v", err)
+		}
+		identities[i], recipients[i] = identity, identity.Recipient()
+	}
+	return identities, recipients
+}
+
+func TestX25519Envelope(t *testing.T) {
+	identities, recipients := generateX25519Identities(t, 3)
+	plaintext := MustRandom(5*1024 + 1)
+	ciphertext := sealEnvelope(t, plaintext, sio.AES_128_GCM, 1024, recipients...)
+
+	for i, identity := range identities {
+		output, err := openEnvelope(ciphertext, identity)
+		if err != nil {
+			t.Fatalf("Identity %d: Failed to open envelope: %v", i, err)
+		}
+		if !bytes.Equal(output, plaintext) {
+			t.Fatalf("Identity %d: Plaintext does not match original plaintext", i)
+		}
+	}
+
+	envelope, err := OpenEnvelope(bytes.NewReader(ciphertext), identities[2])
+	if err != nil {
+		t.Fatalf("Failed to open envelope: %v", err)
+	}
+	if envelope.Algorithm != sio.AES_128_GCM || envelope.BufSize() != 1024 || len(envelope.Stanzas) != len(recipients) {
+		t.Fatalf("Envelope does not match: got %v %d %d", envelope.Algorithm, envelope.BufSize(), len(envelope.Stanzas))
+	}
+	size := int64(len(ciphertext) - len(envelope.Header))
+	section := io.NewSectionReader(bytes.NewReader(ciphertext), int64(len(envelope.Header)), size)
+	p := make([]byte, 2000)
+	if _, err = envelope.DecryptReaderAt(section).ReadAt(p, 1000); err != nil {
+		t.Fatalf("Failed to decrypt data stream section: %v", err)
+	}
+	if !bytes.Equal(p, plaintext[1000:3000]) {
+		t.Fatal("Plaintext section does not match original plaintext")
+	}
+}
+
+func TestX25519EnvelopeNoIdentityMatch(t *testing.T) {
+	_, recipients := generateX25519Identities(t, 2)
+	identities, _ := generateX25519Identities(t, 2)
+	ciphertext := sealEnvelope(t, MustRandom(100), sio.AES_128_GCM, sio.BufSize, recipients...)
+
+	if _, err := openEnvelope(ciphertext, identities[0], identities[1]); err != ErrNoIdentityMatch {
+		t.Fatalf("Opening envelope should fail with ErrNoIdentityMatch: got %v", err)
+	}
+	if _, err := openEnvelope(ciphertext); err != ErrNoIdentityMatch {
+		t.Fatalf("Opening envelope should fail with ErrNoIdentityMatch: got %v", err)
+	}
+}
+
+func TestX25519EnvelopeModified(t *testing.T) {
+	identities, recipients := generateX25519Identities(t, 2)
+	ciphertext := sealEnvelope(t, MustRandom(100), sio.AES_128_GCM, sio.BufSize, recipients...)
+	envelope, err := OpenEnvelope(bytes.NewReader(ciphertext), identities[0])
+	if err != nil {
+		t.Fatalf("Failed to open envelope: %v", err)
+	}
+	header := envelope.Header
+
+	offsets := map[string]int{
+		"nonce":              len(envelope.AssociatedData) - 1,
+		"second stanza body": len(header) - envelopeMACSize - 1,
+		"MAC":                len(header) - 1,
+		"ciphertext":         len(ciphertext) - 1,
+	}
+	for name, offset := range offsets {
+		modified := append([]byte(nil), ciphertext...)
+		modified[offset] ^= 1
+		if _, err = openEnvelope(modified, identities[0]); err != sio.NotAuthentic {
+			t.Fatalf("Modified %s: Opening envelope should fail with NotAuthentic: got %v", name, err)
+		}
+	}
+
+	for _, n := range []int{0, 5, len(envelope.AssociatedData), len(header) - 1} {
+		if _, err = OpenEnvelope(bytes.NewReader(ciphertext[:n]), identities[0]); err == nil {
+			t.Fatalf("Opening envelope with a truncated header of %d bytes should fail", n)
+		}
+	}
+}
+
+func TestNewEnvelopeInvalid(t *testing.T) {
+	if _, err := NewEnvelope(sio.AES_128_GCM, sio.BufSize); err == nil {
+		t.Fatal("Creating an envelope without recipients should fail")
+	}
+	_, recipients := generateX25519Identities(t, 1)
+	if _, err := NewEnvelope(sio.AES_128_GCM, 0, recipients...); err == nil {
+		t.Fatal("Creating an envelope with an invalid buffer size should fail")
+	}
+	if _, err := NewEnvelope("unknown", sio.BufSize, recipients...); err == nil {
+		t.Fatal("Creating an envelope with an unknown algorithm should fail")
+	}
+}
+
+func TestX25519Keys(t *testing.T) {
+	identity, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate X25519 identity: %v", err)
+	}
+	parsed, err := NewX25519Identity(identity.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse X25519 private key: %v", err)
+	}
+	recipient, err := NewX25519Recipient(identity.Recipient().Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse X25519 public key: %v", err)
+	}
+	if !bytes.Equal(parsed.Recipient().Bytes(), recipient.Bytes()) {
+		t.Fatal("X25519 public keys do not match")
+	}
+	if _, err = NewX25519Recipient(make([]byte, 31)); err == nil {
+		t.Fatal("Parsing an invalid X25519 public key should fail")
+	}
+}
@@ -0,0 +1,423 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/secure-io/sio-go"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// The PEM block types of key files.
+const (
+	keyPEMType          = "SIO KEY"
+	encryptedKeyPEMType = "ENCRYPTED SIO KEY"
+)
+
+// ErrPassphraseRequired is returned when loading a
+// passphrase-protected key without a passphrase.
+var ErrPassphraseRequired = errors.New("sioutil: key is protected by a passphrase")
+
+// A Key is a secret key for an Algorithm.
+//
+// Keys are stored as PEM-encoded key files that record the
+// algorithm, key ID and creation time next to the secret key.
+// For example:
+//
+//	-----BEGIN SIO KEY-----
+//	Algorithm: AES-256-GCM
+//	Created: 2020-01-01T00:00:00Z
+//	Key-Id: my-key
+//
+//	rGBWhV8RuIYmOb3Bo2tEZMQ24ToBBCiR1dLp5rqz4Ao=
+//	-----END SIO KEY-----
+//
+// A key file may be protected by a passphrase. Then the secret
+// key is encrypted with a key derived from the passphrase and
+// the PEM block type is "ENCRYPTED SIO KEY".
+type Key struct {
+	ID        string
+	Algorithm sio.Algorithm
+	Secret    []byte
+	CreatedAt time.Time
+}
+
+// GenerateKey returns a new random Key for the algorithm.
+// The key ID must either be empty or consist of at most 255
+// letters, digits, '-', '_' and '.'.
+func GenerateKey(keyID string, a sio.Algorithm) (*Key, error) {
+	if keyID != "" {
+		if err := checkKeyID(keyID); err != nil {
+			return nil, err
+		}
+	}
+	if a.ID() == 0 {
+		return nil, sio.UnknownAlgorithmError{Name: a.String()}
+	}
+	secret, err := Random(a.KeySize())
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		ID:        keyID,
+		Algorithm: a,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}, nil
+}
+
+// Stream returns a new Stream for the key's algorithm
+// using the secret key. It is equivalent to:
+//
+//	k.Algorithm.Stream(k.Secret)
+func (k *Key) Stream() (*sio.Stream, error) { return k.Algorithm.Stream(k.Secret) }
+
+// EncodeKeyPEM returns the PEM-encoded key file of the key.
+func EncodeKeyPEM(k *Key) ([]byte, error) {
+	if err := checkKey(k); err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    keyPEMType,
+		Headers: keyPEMHeaders(k),
+		Bytes:   k.Secret,
+	}), nil
+}
+
+// EncryptKeyPEM returns the PEM-encoded key file of the key
+// with the secret key encrypted by a key derived from the
+// passphrase.
+//
+// It returns an error if the params are below the
+// minimum parameters of the KDF - e.g. MinArgon2idParams.
+func EncryptKeyPEM(k *Key, passphrase []byte, params PasswordParams) ([]byte, error) {
+	if err := checkKey(k); err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("sioutil: passphrase is empty")
+	}
+	if err := checkMinParams(params); err != nil {
+		return nil, err
+	}
+
+	salt, err := Random(passwordSaltSize)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := Random(chacha20poly1305.NonceSizeX)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := keyFileCipher(passphrase, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	headers := keyPEMHeaders(k)
+
+	body := make([]byte, 0, passwordParamsSize+len(salt)+len(nonce)+len(k.Secret)+aead.Overhead())
+	body = appendPasswordParams(body, params)
+	body = append(body, salt...)
+	body = append(body, nonce...)
+	body = aead.Seal(body, nonce, k.Secret, keyFileAssociatedData(headers, body[:passwordParamsSize]))
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    encryptedKeyPEMType,
+		Headers: headers,
+		Bytes:   body,
+	}), nil
+}
+
+// ParseKeyPEM parses a PEM-encoded key file, as returned by
+// EncodeKeyPEM or EncryptKeyPEM.
+//
+// If the key is protected by a passphrase, ParseKeyPEM decrypts
+// it with the passphrase. It returns ErrPassphraseRequired if the
+// passphrase is empty and sio.NotAuthentic if the passphrase is
+// wrong. If the KDF parameters exceed the DefaultPasswordLimits,
+// it returns ErrPasswordLimit without deriving a key.
+func ParseKeyPEM(data, passphrase []byte) (*Key, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("sioutil: invalid key file: no PEM block found")
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, errors.New("sioutil: invalid key file: trailing data after PEM block")
+	}
+	if block.Type != keyPEMType && block.Type != encryptedKeyPEMType {
+		return nil, errors.New("sioutil: invalid key file: unexpected PEM block type '" + block.Type + "'")
+	}
+
+	a, err := sio.ParseAlgorithm(block.Headers["Algorithm"])
+	if err != nil {
+		return nil, err
+	}
+	k := &Key{
+		ID:        block.Headers["Key-Id"],
+		Algorithm: a,
+	}
+	if created, ok := block.Headers["Created"]; ok {
+		if k.CreatedAt, err = time.Parse(time.RFC3339, created); err != nil {
+			return nil, errors.New("sioutil: invalid key file: invalid creation time '" + created + "'")
+		}
+	}
+
+	k.Secret = block.Bytes
+	if block.Type == encryptedKeyPEMType {
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		body := block.Bytes
+		if len(body) < passwordParamsSize+passwordSaltSize+chacha20poly1305.NonceSizeX {
+			return nil, errors.New("sioutil: invalid key file: data is too short")
+		}
+		params, err := parsePasswordParams(body, &DefaultPasswordLimits)
+		if err == ErrPasswordLimit {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errors.New("sioutil: invalid key file: " + err.Error())
+		}
+		salt := body[passwordParamsSize : passwordParamsSize+passwordSaltSize]
+		nonce := body[passwordParamsSize+passwordSaltSize : passwordParamsSize+passwordSaltSize+chacha20poly1305.NonceSizeX]
+		ciphertext := body[passwordParamsSize+passwordSaltSize+chacha20poly1305.NonceSizeX:]
+
+		aead, err := keyFileCipher(passphrase, salt, params)
+		if err != nil {
+			return nil, err
+		}
+		k.Secret, err = aead.Open(nil, nonce, ciphertext, keyFileAssociatedData(block.Headers, body[:passwordParamsSize]))
+		if err != nil {
+			return nil, sio.NotAuthentic
+		}
+	}
+	if err = checkKey(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParseKeyHex parses a hex-encoded secret key for the algorithm.
+// The returned key has no key ID and no creation time.
+func ParseKeyHex(a sio.Algorithm, s string) (*Key, error) {
+	secret, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New("sioutil: invalid hex-encoded key")
+	}
+	return newKey(a, secret)
+}
+
+// ParseKeyBase64 parses a standard base64-encoded secret key for
+// the algorithm. The returned key has no key ID and no creation
+// time.
+func ParseKeyBase64(a sio.Algorithm, s string) (*Key, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New("sioutil: invalid base64-encoded key")
+	}
+	return newKey(a, secret)
+}
+
+// LoadKeyEnv loads the key from the environment variable name.
+//
+// The environment variable contains either a PEM-encoded key file
+// without passphrase or a hex- or base64-encoded secret key for the
+// algorithm. If it contains a key file, the key file must be for the
+// same algorithm, unless the algorithm is empty.
+func LoadKeyEnv(name string, a sio.Algorithm) (*Key, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, errors.New("sioutil: environment variable " + name + " is not set")
+	}
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "-----BEGIN ") {
+		k, err := ParseKeyPEM([]byte(value), nil)
+		if err != nil {
+			return nil, err
+		}
+		if a != "" && k.Algorithm != a {
+			return nil, errors.New("sioutil: environment variable " + name + " contains a " + k.Algorithm.String() + " key - not a " + a.String() + " key")
+		}
+		return k, nil
+	}
+
+	// The hex encoding of a key is always
+	// longer than its base64 encoding.
+	if len(value) == hex.EncodedLen(a.KeySize()) {
+		return ParseKeyHex(a, value)
+	}
+	return ParseKeyBase64(a, value)
+}
+
+// LoadKeyFile loads the key from the PEM-encoded key file. If the
+// key is protected by a passphrase, LoadKeyFile decrypts it with
+// the passphrase. See: ParseKeyPEM.
+//
+// On Unix systems, LoadKeyFile returns an error if the key file
+// is accessible by users other than its owner.
+func LoadKeyFile(filename string, passphrase []byte) (*Key, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkKeyFilePerm(filename, stat); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyPEM(data, passphrase)
+}
+
+// WriteKeyFile writes the PEM-encoded key to a new file with
+// permissions 0600. If the passphrase is not empty, the key is
+// protected by the passphrase using DefaultArgon2idParams.
+//
+// WriteKeyFile returns an error if the file already exists or
+// if - on Unix systems - the file is accessible by users other
+// than its owner after it has been created.
+func WriteKeyFile(filename string, k *Key, passphrase []byte) error {
+	var (
+		data []byte
+		err  error
+	)
+	if len(passphrase) == 0 {
+		data, err = EncodeKeyPEM(k)
+	} else {
+		data, err = EncryptKeyPEM(k, passphrase, DefaultArgon2idParams)
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err == nil {
+		err = checkKeyFilePerm(filename, stat)
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(filename)
+		return err
+	}
+	return nil
+}
+
+// GenerateKeyFile generates a new random key for the algorithm
+// and writes it to a new key file. See: GenerateKey and
+// WriteKeyFile.
+func GenerateKeyFile(filename, keyID string, a sio.Algorithm, passphrase []byte) (*Key, error) {
+	k, err := GenerateKey(keyID, a)
+	if err != nil {
+		return nil, err
+	}
+	if err = WriteKeyFile(filename, k, passphrase); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func newKey(a sio.Algorithm, secret []byte) (*Key, error) {
+	k := &Key{Algorithm: a, Secret: secret}
+	if err := checkKey(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// checkKey returns an error if the key ID is not valid or
+// if the secret key is not a valid key for the algorithm.
+func checkKey(k *Key) error {
+	if k.ID != "" {
+		if err := checkKeyID(k.ID); err != nil {
+			return err
+		}
+	}
+	if a := k.Algorithm; a.ID() == 0 {
+		return sio.UnknownAlgorithmError{Name: a.String()}
+	}
+	if len(k.Secret) != k.Algorithm.KeySize() {
+		return errors.New("sioutil: invalid key size " + strconv.Itoa(len(k.Secret)) + " for " + k.Algorithm.String())
+	}
+	return nil
+}
+
+// checkKeyFilePerm returns an error if the key file is
+// accessible by users other than its owner. It does not
+// check permissions on Windows.
+func checkKeyFilePerm(filename string, stat os.FileInfo) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	if !stat.Mode().IsRegular() {
+		return errors.New("sioutil: key file '" + filename + "' is not a regular file")
+	}
+	if perm := stat.Mode().Perm(); perm&0o077 != 0 {
+		return errors.New("sioutil: key file '" + filename + "' is accessible by other users: permissions " + strconv.FormatUint(uint64(perm), 8))
+	}
+	return nil
+}
+
+func keyPEMHeaders(k *Key) map[string]string {
+	headers := map[string]string{"Algorithm": k.Algorithm.String()}
+	if k.ID != "" {
+		headers["Key-Id"] = k.ID
+	}
+	if !k.CreatedAt.IsZero() {
+		headers["Created"] = k.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	return headers
+}
+
+// keyFileCipher returns the AEAD that encrypts the secret key
+// of a key file with a key derived from the passphrase.
+func keyFileCipher(passphrase, salt []byte, params PasswordParams) (cipher.AEAD, error) {
+	key, err := passwordKey(passphrase, salt, params, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// keyFileAssociatedData returns the associated data that binds
+// the PEM headers and KDF parameters to the encrypted secret key.
+func keyFileAssociatedData(headers map[string]string, params []byte) []byte {
+	ad := make([]byte, 0, 64)
+	ad = append(ad, "sio: key file\x00"...)
+	for _, name := range []string{"Algorithm", "Key-Id", "Created"} {
+		ad = append(ad, headers[name]...)
+		ad = append(ad, 0)
+	}
+	return append(ad, params...)
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/secure-io/sio-go"
+)
+
+// ErrWrongKey is returned by KeyRing.OpenStream if the key check
+// value of a header does not match the key with the same key ID.
+// It indicates that the data stream has been encrypted with a
+// different key - e.g. a key with a re-used key ID.
+var ErrWrongKey = errors.New("sioutil: wrong key: key check value does not match")
+
+// keyRingMagic is the first part of every key ring header.
+// Its last byte is the version of the header format.
+const keyRingMagic = "SIOK\x01"
+
+// keyCheckValueSize is the size of a key check value.
+const keyCheckValueSize = 16
+
+// A KeyRing maps key IDs to algorithms and keys. It encrypts data
+// streams with its current key and stores the key ID in a header
+// in front of the data stream. On decryption, it selects the key
+// by the key ID of the header. Hence, keys can be rotated without
+// trial decryption: new data streams are encrypted with the new
+// current key while existing data streams remain readable as long
+// as their key is part of the KeyRing.
+//
+// The header also contains a key check value (KCV) derived from
+// the key. If the key ring contains a different key for the same
+// key ID, opening the data stream fails with ErrWrongKey instead
+// of sio.NotAuthentic.
+//
+// A KeyRing is safe for concurrent use.
+type KeyRing struct {
+	lock    sync.RWMutex
+	keys    map[string]keyRingEntry
+	current string
+}
+
+type keyRingEntry struct {
+	algorithm     sio.Algorithm
+	key           []byte
+	keyCheckValue []byte
+}
+
+// NewKeyRing returns a new empty KeyRing.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: map[string]keyRingEntry{}}
+}
+
+// Add adds the key for the algorithm with the key ID to the key
+// ring. The key ID must consist of at most 255 letters, digits,
+// '-', '_' and '.'. If the key ring is empty, the key becomes the
+// current key.
+//
+// Add returns an error if the key ring already contains a key
+// with the same key ID or if the key is not a valid key for the
+// algorithm.
+func (r *KeyRing) Add(keyID string, a sio.Algorithm, key []byte) error {
+	if err := checkKeyID(keyID); err != nil {
+		return err
+	}
+	if _, err := a.Stream(key); err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.keys[keyID]; ok {
+		return errors.New("sioutil: key ring already contains key '" + keyID + "'")
+	}
+	key = append([]byte(nil), key...)
+	r.keys[keyID] = keyRingEntry{
+		algorithm:     a,
+		key:           key,
+		keyCheckValue: keyCheckValue(a, key),
+	}
+	if r.current == "" {
+		r.current = keyID
+	}
+	return nil
+}
+
+// Remove removes the key with the key ID from the key
+// ring. It returns an error if the key is the current key.
+func (r *KeyRing) Remove(keyID string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if keyID == r.current {
+		return errors.New("sioutil: cannot remove current key '" + keyID + "'")
+	}
+	delete(r.keys, keyID)
+	return nil
+}
+
+// SetCurrent sets the key with the key ID as current key.
+// The current key is used to encrypt new data streams.
+// It returns ErrKeyNotFound if the key ring does not contain
+// the key.
+func (r *KeyRing) SetCurrent(keyID string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.keys[keyID]; !ok {
+		return ErrKeyNotFound
+	}
+	r.current = keyID
+	return nil
+}
+
+// Current returns the key ID of the current key.
+// It returns the empty string if the key ring is empty.
+func (r *KeyRing) Current() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.current
+}
+
+// KeyIDs returns the sorted key IDs of all keys.
+func (r *KeyRing) KeyIDs() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	keyIDs := make([]string, 0, len(r.keys))
+	for keyID := range r.keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	return keyIDs
+}
+
+// A KeyRingStream is a Stream with a key from a KeyRing.
+//
+// The Header contains the algorithm, buffer size, key ID, key check
+// value and nonce. It must be stored in front of the encrypted data
+// stream. The KeyRingStream uses the Nonce and the Header as
+// associated data of the data stream. For example:
+//
+//	stream, err := keyRing.NewStream(sio.BufSize)
+//	if err != nil {
+//		// TODO: error handling
+//	}
+//	if _, err = w.Write(stream.Header); err != nil {
+//		// TODO: error handling
+//	}
+//	enc := stream.EncryptWriter(w)
+type KeyRingStream struct {
+	stream *sio.Stream
+
+	KeyID     string
+	Algorithm sio.Algorithm
+	Nonce     []byte
+	Header    []byte
+}
+
+// NewStream returns a new KeyRingStream using the current
+// key and a random nonce. It returns ErrKeyNotFound if the
+// key ring is empty.
+func (r *KeyRing) NewStream(bufSize int) (*KeyRingStream, error) {
+	if bufSize <= 0 || bufSize > sio.MaxBufSize {
+		return nil, errors.New("sioutil: invalid buffer size " + strconv.Itoa(bufSize))
+	}
+
+	r.lock.RLock()
+	keyID := r.current
+	entry, ok := r.keys[keyID]
+	r.lock.RUnlock()
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	stream, err := entry.algorithm.StreamWithBufSize(entry.key, bufSize)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := Random(stream.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, len(keyRingMagic)+1+4+1+len(keyID)+keyCheckValueSize+len(nonce))
+	header = append(header, keyRingMagic...)
+	header = append(header, entry.algorithm.ID())
+	header = binary.BigEndian.AppendUint32(header, uint32(bufSize))
+	header = append(header, byte(len(keyID)))
+	header = append(header, keyID...)
+	header = append(header, entry.keyCheckValue...)
+	header = append(header, nonce...)
+	return &KeyRingStream{
+		stream:    stream,
+		KeyID:     keyID,
+		Algorithm: entry.algorithm,
+		Nonce:     nonce,
+		Header:    header,
+	}, nil
+}
+
+// OpenStream reads a header, as written by NewStream, from rd and
+// returns the KeyRingStream using the key with the key ID of the
+// header. Afterwards, rd is positioned at the start of the encrypted
+// data stream. For example:
+//
+//	stream, err := keyRing.OpenStream(r)
+//	if err != nil {
+//		// TODO: error handling
+//	}
+//	dec := stream.DecryptReader(r)
+//
+// OpenStream returns ErrKeyNotFound if the key ring does not contain
+// the key and ErrWrongKey if the key check value does not match.
+func (r *KeyRing) OpenStream(rd io.Reader) (*KeyRingStream, error) {
+	header := make([]byte, len(keyRingMagic)+1+4+1)
+	if _, err := io.ReadFull(rd, header); err != nil {
+		return nil, errKeyRingHeader(err)
+	}
+	if string(header[:len(keyRingMagic)]) != keyRingMagic {
+		return nil, errors.New("sioutil: invalid key ring header")
+	}
+	a, err := sio.AlgorithmFromID(header[5])
+	if err != nil {
+		return nil, err
+	}
+	bufSize := int(binary.BigEndian.Uint32(header[6:]))
+	if bufSize <= 0 || bufSize > sio.MaxBufSize {
+		return nil, errors.New("sioutil: invalid key ring header: invalid buffer size " + strconv.Itoa(bufSize))
+	}
+
+	n := int(header[10])
+	suffix := make([]byte, n+keyCheckValueSize+a.StreamNonceSize())
+	if _, err = io.ReadFull(rd, suffix); err != nil {
+		return nil, errKeyRingHeader(err)
+	}
+	header = append(header, suffix...)
+	keyID := string(suffix[:n])
+	kcv, nonce := suffix[n:n+keyCheckValueSize], suffix[n+keyCheckValueSize:]
+
+	r.lock.RLock()
+	entry, ok := r.keys[keyID]
+	r.lock.RUnlock()
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if entry.algorithm != a || !hmac.Equal(entry.keyCheckValue, kcv) {
+		return nil, ErrWrongKey
+	}
+	stream, err := a.StreamWithBufSize(entry.key, bufSize)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyRingStream{
+		stream:    stream,
+		KeyID:     keyID,
+		Algorithm: a,
+		Nonce:     nonce,
+		Header:    header,
+	}, nil
+}
+
+// BufSize returns the buffer size of the data stream.
+func (s *KeyRingStream) BufSize() int { return s.stream.BufSize() }
+
+// EncryptWriter returns a new EncWriter that wraps w and
+// encrypts and authenticates everything before writing it
+// to w. It uses the Nonce and the Header as associated data.
+//
+// The EncWriter does not write the Header. It must be
+// written to w before.
+func (s *KeyRingStream) EncryptWriter(w io.Writer) *sio.EncWriter {
+	return s.stream.EncryptWriter(w, s.Nonce, s.Header)
+}
+
+// DecryptReader returns a new DecReader that wraps r and
+// decrypts and verifies everything it reads from r. It
+// uses the Nonce and the Header as associated data.
+//
+// The r must be positioned at the start of the encrypted
+// data stream - i.e. after the Header.
+func (s *KeyRingStream) DecryptReader(r io.Reader) *sio.DecReader {
+	return s.stream.DecryptReader(r, s.Nonce, s.Header)
+}
+
+// DecryptReaderAt returns a new DecReaderAt that wraps r and
+// decrypts and verifies everything it reads from r. It uses
+// the Nonce and the Header as associated data.
+//
+// The offset 0 of r must be the start of the encrypted data
+// stream - e.g. an io.SectionReader that starts after the Header.
+func (s *KeyRingStream) DecryptReaderAt(r io.ReaderAt) *sio.DecReaderAt {
+	return s.stream.DecryptReaderAt(r, s.Nonce, s.Header)
+}
+
+// keyCheckValue returns the key check value of the key. It does
+// not reveal the key but identifies it with high probability.
+func keyCheckValue(a sio.Algorithm, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("sio: key check value for " + a.String()))
+	return mac.Sum(nil)[:keyCheckValueSize]
+}
+
+func errKeyRingHeader(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("sioutil: invalid key ring header: data is too short")
+	}
+	return err
+}
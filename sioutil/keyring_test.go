@@ -0,0 +1,126 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+func sealKeyRing(t *testing.T, r *KeyRing, plaintext []byte) []byte {
+	stream, err := r.NewStream(100)
+	if err != nil {
+		t.Fatalf("Failed to create KeyRingStream: %v", err)
+	}
+	return encryptStream(t, stream.Header, stream.EncryptWriter, plaintext)
+}
+
+func TestKeyRing(t *testing.T) {
+	r := NewKeyRing()
+	if err := r.Add("key-1", sio.AES_128_GCM, MustRandom(16)); err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	if err := r.Add("key-2", sio.ChaCha20Poly1305, MustRandom(32)); err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	if current := r.Current(); current != "key-1" {
+		t.Fatalf("Invalid current key: got '%s' - want 'key-1'", current)
+	}
+
+	plaintext := MustRandom(1000)
+	ciphertext1 := sealKeyRing(t, r, plaintext)
+	if err := r.SetCurrent("key-2"); err != nil {
+		t.Fatalf("Failed to set current key: %v", err)
+	}
+	ciphertext2 := sealKeyRing(t, r, plaintext)
+
+	for i, ciphertext := range [][]byte{ciphertext1, ciphertext2} {
+		output, err := decryptStream(ciphertext, r.OpenStream)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to decrypt data stream: %v", i, err)
+		}
+		if !bytes.Equal(output, plaintext) {
+			t.Fatalf("Test %d: Plaintext does not match original plaintext", i)
+		}
+	}
+
+	if err := r.Remove("key-2"); err == nil {
+		t.Fatal("Removing the current key should fail")
+	}
+	if err := r.Remove("key-1"); err != nil {
+		t.Fatalf("Failed to remove key: %v", err)
+	}
+	if _, err := decryptStream(ciphertext1, r.OpenStream); err != ErrKeyNotFound {
+		t.Fatalf("Decrypting with a removed key should fail with ErrKeyNotFound: got %v", err)
+	}
+	if keyIDs := r.KeyIDs(); len(keyIDs) != 1 || keyIDs[0] != "key-2" {
+		t.Fatalf("Invalid key IDs: %v", keyIDs)
+	}
+}
+
+func TestKeyRingWrongKey(t *testing.T) {
+	r := NewKeyRing()
+	if err := r.Add("key", sio.AES_128_GCM, MustRandom(16)); err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	ciphertext := sealKeyRing(t, r, MustRandom(1000))
+
+	other := NewKeyRing()
+	if err := other.Add("key", sio.AES_128_GCM, MustRandom(16)); err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	if _, err := decryptStream(ciphertext, other.OpenStream); err != ErrWrongKey {
+		t.Fatalf("Decrypting with a different key should fail with ErrWrongKey: got %v", err)
+	}
+
+	// The header is authenticated. Modifying the buffer size or the
+	// data stream must be detected.
+	modified := append([]byte(nil), ciphertext...)
+	modified[9] ^= 1
+	if _, err := decryptStream(modified, r.OpenStream); err != sio.NotAuthentic {
+		t.Fatalf("Decrypting a modified header should fail with NotAuthentic: got %v", err)
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := decryptStream(ciphertext, r.OpenStream); err != sio.NotAuthentic {
+		t.Fatalf("Decrypting a modified data stream should fail with NotAuthentic: got %v", err)
+	}
+}
+
+func TestKeyRingInvalid(t *testing.T) {
+	r := NewKeyRing()
+	if _, err := r.NewStream(sio.BufSize); err != ErrKeyNotFound {
+		t.Fatalf("Encrypting with an empty key ring should fail with ErrKeyNotFound: got %v", err)
+	}
+	if err := r.Add("key", sio.AES_128_GCM, MustRandom(15)); err == nil {
+		t.Fatal("Adding an invalid key should fail")
+	}
+	if err := r.Add("../key", sio.AES_128_GCM, MustRandom(16)); err == nil {
+		t.Fatal("Adding a key with an invalid key ID should fail")
+	}
+	if err := r.Add("key", sio.AES_128_GCM, MustRandom(16)); err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	if err := r.Add("key", sio.AES_128_GCM, MustRandom(16)); err == nil {
+		t.Fatal("Adding an existing key should fail")
+	}
+	if err := r.SetCurrent("unknown"); err != ErrKeyNotFound {
+		t.Fatalf("Setting an unknown key as current key should fail with ErrKeyNotFound: got %v", err)
+	}
+
+	// An empty data stream consists of the header and one
+	// authentication tag.
+	ciphertext := sealKeyRing(t, r, nil)
+	for i := 0; i < len(ciphertext)-sio.AES_128_GCM.Overhead(); i++ {
+		if _, err := r.OpenStream(bytes.NewReader(ciphertext[:i])); err == nil {
+			t.Fatalf("Test %d: Opening a truncated header should fail", i)
+		}
+	}
+	ciphertext[0] ^= 1
+	if _, err := r.OpenStream(bytes.NewReader(ciphertext)); err == nil {
+		t.Fatal("Opening a header with an invalid magic should fail")
+	}
+}
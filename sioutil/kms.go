@@ -0,0 +1,268 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/secure-io/sio-go"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// keyManagerStanzaType is the stanza type of KeyManager recipients.
+const keyManagerStanzaType = "KeyManager"
+
+// A KeyManager generates and decrypts data keys using master
+// keys that never leave the KeyManager - e.g. a KMS.
+type KeyManager interface {
+	// GenerateKey returns a new random 256 bit data key and
+	// the data key encrypted with the master key keyID.
+	GenerateKey(keyID string) (plaintext, ciphertext []byte, err error)
+
+	// Decrypt decrypts a data key encrypted with the master
+	// key keyID. It returns ErrKeyNotFound if the master key
+	// does not exist.
+	Decrypt(keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// A KeyEncrypter encrypts existing data keys with a master key.
+// A KeyManager must implement KeyEncrypter to rewrap envelopes
+// for its master keys. See: KeyManagerRecipient.
+type KeyEncrypter interface {
+	Encrypt(keyID string, plaintext []byte) ([]byte, error)
+}
+
+// ErrKeyNotFound is returned by a KeyManager or a
+// KeyRing if a key does not exist.
+var ErrKeyNotFound = errors.New("sioutil: key not found")
+
+// NewKeyManagerEnvelope returns a new Envelope with a file key
+// generated by the KeyManager. The file key is stored encrypted
+// with the master key keyID in the header. The file key is also
+// wrapped for any additional recipients.
+//
+// An envelope created by NewKeyManagerEnvelope can be opened
+// with a KeyManagerIdentity for the same KeyManager.
+func NewKeyManagerEnvelope(km KeyManager, keyID string, a sio.Algorithm, bufSize int, recipients ...Recipient) (*Envelope, error) {
+	if err := checkKeyID(keyID); err != nil {
+		return nil, err
+	}
+	fileKey, ciphertext, err := km.GenerateKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(fileKey) != FileKeySize {
+		return nil, errors.New("sioutil: key manager returned an invalid data key")
+	}
+	stanza := newKeyManagerStanza(keyID, ciphertext)
+	return newEnvelope(a, bufSize, fileKey, append([]Recipient{stanza}, recipients...))
+}
+
+// A KeyManagerRecipient wraps the file key of an envelope by
+// encrypting it with the master key KeyID. The KeyManager must
+// implement KeyEncrypter.
+//
+// Most applications should use NewKeyManagerEnvelope to create
+// envelopes and use a KeyManagerRecipient to rewrap existing
+// envelopes for a new master key. For example:
+//
+//	envelope.Rewrap(&sioutil.KeyManagerRecipient{KeyManager: km, KeyID: "new-key"})
+type KeyManagerRecipient struct {
+	KeyManager KeyManager
+	KeyID      string
+}
+
+var _ Recipient = (*KeyManagerRecipient)(nil)
+
+// Wrap encrypts the file key with the master key.
+func (r *KeyManagerRecipient) Wrap(fileKey []byte) (*Stanza, error) {
+	if err := checkKeyID(r.KeyID); err != nil {
+		return nil, err
+	}
+	encrypter, ok := r.KeyManager.(KeyEncrypter)
+	if !ok {
+		return nil, errors.New("sioutil: key manager does not support encrypting existing keys")
+	}
+	ciphertext, err := encrypter.Encrypt(r.KeyID, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return (*Stanza)(newKeyManagerStanza(r.KeyID, ciphertext)), nil
+}
+
+// A KeyManagerIdentity unwraps file keys by
+// decrypting them with its KeyManager.
+type KeyManagerIdentity struct {
+	KeyManager KeyManager
+}
+
+var _ Identity = (*KeyManagerIdentity)(nil)
+
+// Unwrap decrypts the file key of a KeyManager stanza. It returns
+// ErrIncorrectIdentity if the stanza is not a KeyManager stanza or
+// if the KeyManager does not have the master key.
+func (i *KeyManagerIdentity) Unwrap(stanza *Stanza) ([]byte, error) {
+	if stanza.Type != keyManagerStanzaType {
+		return nil, ErrIncorrectIdentity
+	}
+	if len(stanza.Body) == 0 || len(stanza.Body) < 1+int(stanza.Body[0]) {
+		return nil, errors.New("sioutil: invalid " + keyManagerStanzaType + " stanza")
+	}
+	keyID, ciphertext := string(stanza.Body[1:1+stanza.Body[0]]), stanza.Body[1+stanza.Body[0]:]
+
+	fileKey, err := i.KeyManager.Decrypt(keyID, ciphertext)
+	if err == ErrKeyNotFound {
+		return nil, ErrIncorrectIdentity
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fileKey, nil
+}
+
+// keyManagerStanza is a Recipient that returns a
+// KeyManager stanza with a precomputed ciphertext.
+type keyManagerStanza Stanza
+
+func newKeyManagerStanza(keyID string, ciphertext []byte) *keyManagerStanza {
+	body := make([]byte, 0, 1+len(keyID)+len(ciphertext))
+	body = append(body, byte(len(keyID)))
+	body = append(body, keyID...)
+	body = append(body, ciphertext...)
+	return &keyManagerStanza{Type: keyManagerStanzaType, Body: body}
+}
+
+func (s *keyManagerStanza) Wrap([]byte) (*Stanza, error) { return (*Stanza)(s), nil }
+
+// checkKeyID returns an error if the keyID is not a valid
+// key ID. A valid key ID consists of at most 255 letters,
+// digits, '-', '_' and '.' and does not start with a '.'.
+func checkKeyID(keyID string) error {
+	if keyID == "" || len(keyID) > math.MaxUint8 || keyID[0] == '.' {
+		return errors.New("sioutil: invalid key ID '" + keyID + "'")
+	}
+	for _, c := range keyID {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return errors.New("sioutil: invalid key ID '" + keyID + "'")
+		}
+	}
+	return nil
+}
+
+// A LocalKeyManager is a KeyManager that stores master keys
+// as files in a directory - one file per key ID. It encrypts
+// data keys with XChaCha20-Poly1305.
+//
+// A LocalKeyManager is meant for tests and air-gapped setups.
+// The master keys are only as secure as the directory that
+// contains them.
+type LocalKeyManager struct {
+	dir string
+}
+
+var (
+	_ KeyManager   = (*LocalKeyManager)(nil)
+	_ KeyEncrypter = (*LocalKeyManager)(nil)
+)
+
+// NewLocalKeyManager returns a new LocalKeyManager that stores
+// master keys in the directory dir. The directory must exist.
+func NewLocalKeyManager(dir string) (*LocalKeyManager, error) {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, errors.New("sioutil: '" + dir + "' is not a directory")
+	}
+	return &LocalKeyManager{dir: dir}, nil
+}
+
+// CreateKey creates a new random master key with the keyID.
+// It returns an error if the master key already exists.
+func (m *LocalKeyManager) CreateKey(keyID string) error {
+	if err := checkKeyID(keyID); err != nil {
+		return err
+	}
+	key, err := Random(chacha20poly1305.KeySize)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(m.dir, keyID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(key); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// GenerateKey returns a new random 256 bit data key and the
+// data key encrypted with the master key keyID.
+func (m *LocalKeyManager) GenerateKey(keyID string) (plaintext, ciphertext []byte, err error) {
+	plaintext, err = Random(FileKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ciphertext, err = m.Encrypt(keyID, plaintext); err != nil {
+		return nil, nil, err
+	}
+	return plaintext, ciphertext, nil
+}
+
+// Encrypt encrypts the plaintext with the master key keyID.
+func (m *LocalKeyManager) Encrypt(keyID string, plaintext []byte) ([]byte, error) {
+	aead, err := m.masterKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := Random(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(keyID)), nil
+}
+
+// Decrypt decrypts a ciphertext encrypted with the master key keyID.
+func (m *LocalKeyManager) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	aead, err := m.masterKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, sio.NotAuthentic
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, sio.NotAuthentic
+	}
+	return plaintext, nil
+}
+
+// masterKey returns the AEAD for the master key keyID.
+func (m *LocalKeyManager) masterKey(keyID string) (cipher.AEAD, error) {
+	if err := checkKeyID(keyID); err != nil {
+		return nil, err
+	}
+	key, err := os.ReadFile(filepath.Join(m.dir, keyID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, errors.New("sioutil: master key '" + keyID + "' is invalid")
+	}
+	return chacha20poly1305.NewX(key)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+func newLocalKeyManager(t *testing.T, keyIDs ...string) *LocalKeyManager {
+	km, err := NewLocalKeyManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create LocalKeyManager: %v", err)
+	}
+	for _, keyID := range keyIDs {
+		if err = km.CreateKey(keyID); err != nil {
+			t.Fatalf("Failed to create master key '%s': %v", keyID, err)
+		}
+	}
+	return km
+}
+
+func TestKeyManagerEnvelope(t *testing.T) {
+	km := newLocalKeyManager(t, "key-1", "key-2")
+	x25519, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate X25519 identity: %v", err)
+	}
+
+	envelope, err := NewKeyManagerEnvelope(km, "key-1", sio.AES_256_GCM, sio.BufSize, x25519.Recipient())
+	if err != nil {
+		t.Fatalf("Failed to create envelope: %v", err)
+	}
+	plaintext := MustRandom(1000)
+	ciphertext := encryptStream(t, envelope.Header, envelope.EncryptWriter, plaintext)
+
+	for i, identity := range []Identity{&KeyManagerIdentity{KeyManager: km}, x25519} {
+		output, err := openEnvelope(ciphertext, identity)
+		if err != nil {
+			t.Fatalf("Identity %d: Failed to open envelope: %v", i, err)
+		}
+		if !bytes.Equal(output, plaintext) {
+			t.Fatalf("Identity %d: Plaintext does not match original plaintext", i)
+		}
+	}
+}
+
+func TestRewrapEnvelope(t *testing.T) {
+	km := newLocalKeyManager(t, "key-1", "key-2")
+	envelope, err := NewKeyManagerEnvelope(km, "key-1", sio.AES_256_GCM, 100)
+	if err != nil {
+		t.Fatalf("Failed to create envelope: %v", err)
+	}
+	plaintext := MustRandom(1000)
+	ciphertext := encryptStream(t, envelope.Header, envelope.EncryptWriter, plaintext)
+
+	identity := &KeyManagerIdentity{KeyManager: km}
+	var rewrapped bytes.Buffer
+	n, err := RewrapEnvelope(&rewrapped, bytes.NewReader(ciphertext), []Identity{identity}, &KeyManagerRecipient{KeyManager: km, KeyID: "key-2"})
+	if err != nil {
+		t.Fatalf("Failed to rewrap envelope: %v", err)
+	}
+	if n != int64(rewrapped.Len()) {
+		t.Fatalf("RewrapEnvelope returned %d - but wrote %d bytes", n, rewrapped.Len())
+	}
+
+	// Only the header must change.
+	body := ciphertext[len(envelope.Header):]
+	if !bytes.HasSuffix(rewrapped.Bytes(), body) {
+		t.Fatal("Rewrapping modified the encrypted data stream")
+	}
+
+	if err = os.Remove(filepath.Join(km.dir, "key-1")); err != nil {
+		t.Fatalf("Failed to remove master key: %v", err)
+	}
+	if _, err = openEnvelope(ciphertext, identity); err != ErrNoIdentityMatch {
+		t.Fatalf("Opening envelope without master key should fail with ErrNoIdentityMatch: got %v", err)
+	}
+	output, err := openEnvelope(rewrapped.Bytes(), identity)
+	if err != nil {
+		t.Fatalf("Failed to open rewrapped envelope: %v", err)
+	}
+	if !bytes.Equal(output, plaintext) {
+		t.Fatal("Plaintext does not match original plaintext")
+	}
+}
+
+func TestLocalKeyManager(t *testing.T) {
+	km := newLocalKeyManager(t, "key")
+
+	stat, err := os.Stat(filepath.Join(km.dir, "key"))
+	if err != nil {
+		t.Fatalf("Failed to stat master key: %v", err)
+	}
+	if perm := stat.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("Invalid master key permissions: got %o - want %o", perm, 0o600)
+	}
+	if err = km.CreateKey("key"); err == nil {
+		t.Fatal("Creating an existing master key should fail")
+	}
+	for _, keyID := range []string{"", ".key", "../key", "key/1", string(make([]byte, 256))} {
+		if err = km.CreateKey(keyID); err == nil {
+			t.Fatalf("Creating master key '%s' should fail", keyID)
+		}
+	}
+
+	plaintext, ciphertext, err := km.GenerateKey("key")
+	if err != nil {
+		t.Fatalf("Failed to generate data key: %v", err)
+	}
+	decrypted, err := km.Decrypt("key", ciphertext)
+	if err != nil {
+		t.Fatalf("Failed to decrypt data key: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("Decrypted data key does not match generated data key")
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err = km.Decrypt("key", ciphertext); err != sio.NotAuthentic {
+		t.Fatalf("Decrypting a modified data key should fail with NotAuthentic: got %v", err)
+	}
+	if _, err = km.Decrypt("unknown", ciphertext); err != ErrKeyNotFound {
+		t.Fatalf("Decrypting with an unknown master key should fail with ErrKeyNotFound: got %v", err)
+	}
+	if _, err = NewLocalKeyManager(filepath.Join(km.dir, "key")); err == nil {
+		t.Fatal("Creating a LocalKeyManager for a file should fail")
+	}
+}
+
+func TestKeyManagerRecipientWithoutEncrypt(t *testing.T) {
+	km := struct{ KeyManager }{newLocalKeyManager(t, "key")}
+	recipient := &KeyManagerRecipient{KeyManager: km, KeyID: "key"}
+	if _, err := NewEnvelope(sio.AES_256_GCM, sio.BufSize, recipient); err == nil {
+		t.Fatal("Wrapping with a KeyManager that does not implement KeyEncrypter should fail")
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// The stanza types of ML-KEM recipients.
+const (
+	mlkemStanzaType       = "ML-KEM-768"
+	mlkemHybridStanzaType = "ML-KEM-768+X25519"
+)
+
+// An MLKEMRecipient wraps the file key of an envelope for the
+// owner of an ML-KEM-768 encapsulation key. ML-KEM is a post-quantum
+// key encapsulation mechanism specified by FIPS 203.
+//
+// A hybrid MLKEMRecipient combines ML-KEM-768 with X25519. The
+// wrapped file key remains confidential as long as one of the two
+// remains secure.
+//
+// The wrapping key is derived from the shared secret(s) using
+// HKDF-SHA-256. The file key is encrypted with ChaCha20-Poly1305.
+type MLKEMRecipient struct {
+	encapsulationKey *mlkem.EncapsulationKey768
+	x25519           *X25519Recipient
+}
+
+var _ Recipient = (*MLKEMRecipient)(nil)
+
+// NewMLKEMRecipient returns a new MLKEMRecipient from an ML-KEM-768
+// encapsulation key. If x25519 is not nil, the returned recipient
+// is a hybrid ML-KEM-768 and X25519 recipient.
+func NewMLKEMRecipient(encapsulationKey []byte, x25519 *X25519Recipient) (*MLKEMRecipient, error) {
+	key, err := mlkem.NewEncapsulationKey768(encapsulationKey)
+	if err != nil {
+		return nil, errors.New("sioutil: invalid ML-KEM-768 encapsulation key")
+	}
+	return &MLKEMRecipient{encapsulationKey: key, x25519: x25519}, nil
+}
+
+// EncapsulationKey returns the ML-KEM-768 encapsulation key.
+func (r *MLKEMRecipient) EncapsulationKey() []byte { return r.encapsulationKey.Bytes() }
+
+// X25519 returns the X25519 recipient of a hybrid
+// recipient or nil.
+func (r *MLKEMRecipient) X25519() *X25519Recipient { return r.x25519 }
+
+// Wrap wraps the file key for the recipient.
+func (r *MLKEMRecipient) Wrap(fileKey []byte) (*Stanza, error) {
+	sharedKey, ciphertext := r.encapsulationKey.Encapsulate()
+
+	stanzaType, body := mlkemStanzaType, ciphertext
+	if r.x25519 != nil {
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		sharedSecret, err := ephemeral.ECDH(r.x25519.publicKey)
+		if err != nil {
+			return nil, err
+		}
+		stanzaType = mlkemHybridStanzaType
+		sharedKey = append(sharedKey, sharedSecret...)
+		body = append(body, ephemeral.PublicKey().Bytes()...)
+	}
+
+	aead, err := mlkemWrappingKey(stanzaType, sharedKey, body, r.x25519)
+	if err != nil {
+		return nil, err
+	}
+	body = aead.Seal(body, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+	return &Stanza{Type: stanzaType, Body: body}, nil
+}
+
+// An MLKEMIdentity unwraps file keys that have
+// been wrapped for its MLKEMRecipient.
+type MLKEMIdentity struct {
+	decapsulationKey *mlkem.DecapsulationKey768
+	x25519           *X25519Identity
+}
+
+var _ Identity = (*MLKEMIdentity)(nil)
+
+// GenerateMLKEMIdentity returns a new random MLKEMIdentity.
+// If hybrid is true, the returned identity is a hybrid
+// ML-KEM-768 and X25519 identity.
+func GenerateMLKEMIdentity(hybrid bool) (*MLKEMIdentity, error) {
+	key, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, err
+	}
+	identity := &MLKEMIdentity{decapsulationKey: key}
+	if hybrid {
+		if identity.x25519, err = GenerateX25519Identity(); err != nil {
+			return nil, err
+		}
+	}
+	return identity, nil
+}
+
+// NewMLKEMIdentity returns a new MLKEMIdentity from the 64 byte
+// seed of an ML-KEM-768 decapsulation key. If x25519 is not nil,
+// the returned identity is a hybrid ML-KEM-768 and X25519 identity.
+func NewMLKEMIdentity(seed []byte, x25519 *X25519Identity) (*MLKEMIdentity, error) {
+	key, err := mlkem.NewDecapsulationKey768(seed)
+	if err != nil {
+		return nil, errors.New("sioutil: invalid ML-KEM-768 decapsulation key")
+	}
+	return &MLKEMIdentity{decapsulationKey: key, x25519: x25519}, nil
+}
+
+// Seed returns the 64 byte seed of the
+// ML-KEM-768 decapsulation key.
+func (i *MLKEMIdentity) Seed() []byte { return i.decapsulationKey.Bytes() }
+
+// X25519 returns the X25519 identity of a hybrid
+// identity or nil.
+func (i *MLKEMIdentity) X25519() *X25519Identity { return i.x25519 }
+
+// Recipient returns the MLKEMRecipient of the identity.
+func (i *MLKEMIdentity) Recipient() *MLKEMRecipient {
+	r := &MLKEMRecipient{encapsulationKey: i.decapsulationKey.EncapsulationKey()}
+	if i.x25519 != nil {
+		r.x25519 = i.x25519.Recipient()
+	}
+	return r
+}
+
+// Unwrap unwraps the file key if the stanza has been
+// created for the identity. Otherwise, it returns
+// ErrIncorrectIdentity.
+//
+// A hybrid identity only unwraps hybrid stanzas and
+// a non-hybrid identity only unwraps non-hybrid stanzas.
+func (i *MLKEMIdentity) Unwrap(stanza *Stanza) ([]byte, error) {
+	stanzaType, bodySize := mlkemStanzaType, mlkem.CiphertextSize768
+	if i.x25519 != nil {
+		stanzaType, bodySize = mlkemHybridStanzaType, mlkem.CiphertextSize768+32
+	}
+	if stanza.Type != stanzaType {
+		return nil, ErrIncorrectIdentity
+	}
+	if len(stanza.Body) != bodySize+FileKeySize+chacha20poly1305.Overhead {
+		return nil, errors.New("sioutil: invalid " + stanzaType + " stanza")
+	}
+
+	sharedKey, err := i.decapsulationKey.Decapsulate(stanza.Body[:mlkem.CiphertextSize768])
+	if err != nil {
+		return nil, errors.New("sioutil: invalid " + stanzaType + " stanza")
+	}
+	var x25519 *X25519Recipient
+	if i.x25519 != nil {
+		ephemeral, err := ecdh.X25519().NewPublicKey(stanza.Body[mlkem.CiphertextSize768:bodySize])
+		if err != nil {
+			return nil, errors.New("sioutil: invalid " + stanzaType + " stanza")
+		}
+		sharedSecret, err := i.x25519.privateKey.ECDH(ephemeral)
+		if err != nil {
+			return nil, errors.New("sioutil: invalid " + stanzaType + " stanza")
+		}
+		sharedKey = append(sharedKey, sharedSecret...)
+		x25519 = i.x25519.Recipient()
+	}
+
+	aead, err := mlkemWrappingKey(stanzaType, sharedKey, stanza.Body[:bodySize], x25519)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), stanza.Body[bodySize:], nil)
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+	return fileKey, nil
+}
+
+// mlkemWrappingKey returns the AEAD that wraps the file key derived
+// from the shared key(s), the ML-KEM ciphertext and - for hybrid
+// stanzas - the ephemeral and the recipient's X25519 public key.
+func mlkemWrappingKey(stanzaType string, sharedKey, ciphertext []byte, x25519 *X25519Recipient) (cipher.AEAD, error) {
+	salt := append([]byte(nil), ciphertext...)
+	if x25519 != nil {
+		salt = append(salt, x25519.Bytes()...)
+	}
+	key, err := hkdf.Key(sha256.New, sharedKey, salt, "sio: "+stanzaType+" file key wrapping", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+func TestMLKEMEnvelope(t *testing.T) {
+	pure, err := GenerateMLKEMIdentity(false)
+	if err != nil {
+		t.Fatalf("Failed to generate ML-KEM identity: %v", err)
+	}
+	hybrid, err := GenerateMLKEMIdentity(true)
+	if err != nil {
+		t.Fatalf("Failed to generate hybrid ML-KEM identity: %v", err)
+	}
+	x25519, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate X25519 identity: %v", err)
+	}
+
+	plaintext := MustRandom(3*1024 + 5)
+	ciphertext := sealEnvelope(t, plaintext, sio.XChaCha20Poly1305, 1024, pure.Recipient(), hybrid.Recipient(), x25519.Recipient())
+	for i, identity := range []Identity{pure, hybrid, x25519} {
+		output, err := openEnvelope(ciphertext, identity)
+		if err != nil {
+			t.Fatalf("Identity %d: Failed to open envelope: %v", i, err)
+		}
+		if !bytes.Equal(output, plaintext) {
+			t.Fatalf("Identity %d: Plaintext does not match original plaintext", i)
+		}
+	}
+
+	envelope, err := OpenEnvelope(bytes.NewReader(ciphertext), hybrid)
+	if err != nil {
+		t.Fatalf("Failed to open envelope: %v", err)
+	}
+	size := int64(len(ciphertext) - len(envelope.Header))
+	section := io.NewSectionReader(bytes.NewReader(ciphertext), int64(len(envelope.Header)), size)
+	p := make([]byte, 1024)
+	if _, err = envelope.DecryptReaderAt(section).ReadAt(p, 2000); err != nil {
+		t.Fatalf("Failed to decrypt data stream section: %v", err)
+	}
+	if !bytes.Equal(p, plaintext[2000:3024]) {
+		t.Fatal("Plaintext section does not match original plaintext")
+	}
+}
+
+func TestMLKEMEnvelopeNoIdentityMatch(t *testing.T) {
+	hybrid, err := GenerateMLKEMIdentity(true)
+	if err != nil {
+		t.Fatalf("Failed to generate hybrid ML-KEM identity: %v", err)
+	}
+	pure, err := NewMLKEMIdentity(hybrid.Seed(), nil)
+	if err != nil {
+		t.Fatalf("Failed to parse ML-KEM seed: %v", err)
+	}
+	other, err := GenerateMLKEMIdentity(false)
+	if err != nil {
+		t.Fatalf("Failed to generate ML-KEM identity: %v", err)
+	}
+
+	// The same ML-KEM key but without the X25519 key must not
+	// unwrap a hybrid stanza - and vice versa.
+	ciphertext := sealEnvelope(t, MustRandom(100), sio.AES_256_GCM, sio.BufSize, hybrid.Recipient())
+	if _, err = openEnvelope(ciphertext, pure, other); err != ErrNoIdentityMatch {
+		t.Fatalf("Opening envelope should fail with ErrNoIdentityMatch: got %v", err)
+	}
+	ciphertext = sealEnvelope(t, MustRandom(100), sio.AES_256_GCM, sio.BufSize, pure.Recipient())
+	if _, err = openEnvelope(ciphertext, hybrid, other); err != ErrNoIdentityMatch {
+		t.Fatalf("Opening envelope should fail with ErrNoIdentityMatch: got %v", err)
+	}
+}
+
+func TestMLKEMKeys(t *testing.T) {
+	identity, err := GenerateMLKEMIdentity(true)
+	if err != nil {
+		t.Fatalf("Failed to generate hybrid ML-KEM identity: %v", err)
+	}
+	parsed, err := NewMLKEMIdentity(identity.Seed(), identity.X25519())
+	if err != nil {
+		t.Fatalf("Failed to parse ML-KEM seed: %v", err)
+	}
+	recipient, err := NewMLKEMRecipient(identity.Recipient().EncapsulationKey(), identity.Recipient().X25519())
+	if err != nil {
+		t.Fatalf("Failed to parse ML-KEM encapsulation key: %v", err)
+	}
+	if !bytes.Equal(parsed.Recipient().EncapsulationKey(), recipient.EncapsulationKey()) {
+		t.Fatal("ML-KEM encapsulation keys do not match")
+	}
+
+	ciphertext := sealEnvelope(t, MustRandom(100), sio.AES_256_GCM, sio.BufSize, recipient)
+	if _, err = openEnvelope(ciphertext, parsed); err != nil {
+		t.Fatalf("Failed to open envelope: %v", err)
+	}
+
+	if _, err = NewMLKEMRecipient(make([]byte, 100), nil); err == nil {
+		t.Fatal("Parsing an invalid ML-KEM encapsulation key should fail")
+	}
+	if _, err = NewMLKEMIdentity(make([]byte, 32), nil); err == nil {
+		t.Fatal("Parsing an invalid ML-KEM seed should fail")
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/secure-io/sio-go"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// A PasswordKDF is a password-based key derivation
+// function that derives a Stream key from a password.
+type PasswordKDF byte
+
+// The password-based key derivation functions.
+const (
+	Argon2id PasswordKDF = 1 // See: https://pkg.go.dev/golang.org/x/crypto/argon2#IDKey
+	Scrypt   PasswordKDF = 2 // See: https://pkg.go.dev/golang.org/x/crypto/scrypt#Key
+)
+
+// String returns the string representation of the KDF.
+func (k PasswordKDF) String() string {
+	switch k {
+	case Argon2id:
+		return "Argon2id"
+	case Scrypt:
+		return "scrypt"
+	default:
+		return "PasswordKDF(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// PasswordParams are the cost parameters of a
+// password-based key derivation function.
+type PasswordParams struct {
+	KDF PasswordKDF
+
+	// Argon2id parameters
+	Time    uint32 // Number of passes over the memory
+	Memory  uint32 // Memory in KiB
+	Threads uint8  // Degree of parallelism
+
+	// scrypt parameters
+	LogN uint8  // Base-2 logarithm of the CPU/memory cost N
+	R    uint32 // Block size
+	P    uint32 // Degree of parallelism
+}
+
+var (
+	// DefaultArgon2idParams are the recommended Argon2id parameters.
+	// They require 64 MiB of memory.
+	DefaultArgon2idParams = PasswordParams{KDF: Argon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+
+	// DefaultScryptParams are the recommended scrypt parameters.
+	// They require 128 MiB of memory.
+	DefaultScryptParams = PasswordParams{KDF: Scrypt, LogN: 17, R: 8, P: 1}
+
+	// MinArgon2idParams are the minimum Argon2id parameters
+	// accepted by NewPasswordStream.
+	MinArgon2idParams = PasswordParams{KDF: Argon2id, Time: 1, Memory: 19 * 1024, Threads: 1}
+
+	// MinScryptParams are the minimum scrypt parameters
+	// accepted by NewPasswordStream.
+	MinScryptParams = PasswordParams{KDF: Scrypt, LogN: 15, R: 8, P: 1}
+)
+
+// PasswordLimits are the maximum cost parameters accepted by
+// OpenPasswordStream. They prevent crafted headers from
+// consuming arbitrary amounts of memory and CPU time.
+type PasswordLimits struct {
+	MaxArgon2idTime    uint32
+	MaxArgon2idMemory  uint32 // in KiB
+	MaxArgon2idThreads uint8
+
+	MaxScryptLogN uint8
+	MaxScryptR    uint32
+	MaxScryptP    uint32
+}
+
+// DefaultPasswordLimits are the limits used by OpenPasswordStream
+// if no limits are specified. A KDF within these limits requires
+// at most 1 GiB of memory.
+var DefaultPasswordLimits = PasswordLimits{
+	MaxArgon2idTime:    16,
+	MaxArgon2idMemory:  1 << 20,
+	MaxArgon2idThreads: 64,
+
+	MaxScryptLogN: 20,
+	MaxScryptR:    8,
+	MaxScryptP:    16,
+}
+
+// ErrPasswordLimit is returned by OpenPasswordStream when the
+// KDF parameters of a header exceed the PasswordLimits.
+var ErrPasswordLimit = errors.New("sioutil: password KDF parameters exceed the limits")
+
+// passwordMagic is the first part of every password header.
+// Its last byte is the version of the header format.
+const passwordMagic = "SIOP\x01"
+
+// passwordSaltSize is the size of the random salt.
+const passwordSaltSize = 32
+
+// A PasswordStream is a Stream with a key derived
+// from a password.
+//
+// The Header contains the algorithm, buffer size, KDF
+// parameters, salt and nonce. It must be stored in front
+// of the encrypted data stream. The PasswordStream uses
+// the Nonce and the Header as associated data of the data
+// stream. For example:
+//
+//	stream, err := sioutil.NewPasswordStream(password, sio.XChaCha20Poly1305, sio.BufSize, sioutil.DefaultArgon2idParams)
+//	if err != nil {
+//		// TODO: error handling
+//	}
+//	if _, err = w.Write(stream.Header); err != nil {
+//		// TODO: error handling
+//	}
+//	enc := stream.EncryptWriter(w)
+//
+// Since the header is authenticated, any modification of the
+// header causes the decryption to fail with sio.NotAuthentic.
+type PasswordStream struct {
+	stream *sio.Stream
+
+	Algorithm sio.Algorithm
+	Params    PasswordParams
+	Nonce     []byte
+	Header    []byte
+}
+
+// NewPasswordStream returns a new PasswordStream using a key
+// derived from the password with a random salt, and a random
+// nonce.
+//
+// It returns an error if the params are below the
+// minimum parameters of the KDF - e.g. MinArgon2idParams.
+func NewPasswordStream(password []byte, a sio.Algorithm, bufSize int, params PasswordParams) (*PasswordStream, error) {
+	if err := checkMinParams(params); err != nil {
+		return nil, err
+	}
+	if a.ID() == 0 {
+		return nil, sio.UnknownAlgorithmError{Name: a.String()}
+	}
+	if bufSize <= 0 || bufSize > sio.MaxBufSize {
+		return nil, errors.New("sioutil: invalid buffer size " + strconv.Itoa(bufSize))
+	}
+
+	salt, err := Random(passwordSaltSize)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := Random(a.StreamNonceSize())
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, len(passwordMagic)+1+4+passwordParamsSize+passwordSaltSize+len(nonce))
+	header = append(header, passwordMagic...)
+	header = append(header, a.ID())
+	header = binary.BigEndian.AppendUint32(header, uint32(bufSize))
+	header = appendPasswordParams(header, params)
+	header = append(header, salt...)
+	header = append(header, nonce...)
+
+	return newPasswordStream(password, a, bufSize, params, salt, nonce, header)
+}
+
+// OpenPasswordStream reads a header, as written by NewPasswordStream,
+// from r and returns the PasswordStream with the key derived from
+// the password. Afterwards, r is positioned at the start of the
+// encrypted data stream. For example:
+//
+//	stream, err := sioutil.OpenPasswordStream(r, password, nil)
+//	if err != nil {
+//		// TODO: error handling
+//	}
+//	dec := stream.DecryptReader(r)
+//
+// If limits is nil, OpenPasswordStream uses DefaultPasswordLimits.
+// If the KDF parameters of the header exceed the limits, it returns
+// ErrPasswordLimit without deriving the key.
+//
+// OpenPasswordStream does not verify the password. Instead, decrypting
+// the data stream fails with sio.NotAuthentic if the password is wrong.
+func OpenPasswordStream(r io.Reader, password []byte, limits *PasswordLimits) (*PasswordStream, error) {
+	if limits == nil {
+		limits = &DefaultPasswordLimits
+	}
+
+	header := make([]byte, len(passwordMagic)+1+4+passwordParamsSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errPasswordHeader(err)
+	}
+	if string(header[:len(passwordMagic)]) != passwordMagic {
+		return nil, errors.New("sioutil: invalid password header")
+	}
+	a, err := sio.AlgorithmFromID(header[5])
+	if err != nil {
+		return nil, err
+	}
+	bufSize := int(binary.BigEndian.Uint32(header[6:]))
+	if bufSize <= 0 || bufSize > sio.MaxBufSize {
+		return nil, errors.New("sioutil: invalid password header: invalid buffer size " + strconv.Itoa(bufSize))
+	}
+
+	params, err := parsePasswordParams(header[10:], limits)
+	if err == ErrPasswordLimit {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New("sioutil: invalid password header: " + err.Error())
+	}
+
+	suffix := make([]byte, passwordSaltSize+a.StreamNonceSize())
+	if _, err = io.ReadFull(r, suffix); err != nil {
+		return nil, errPasswordHeader(err)
+	}
+	header = append(header, suffix...)
+	salt, nonce := suffix[:passwordSaltSize], suffix[passwordSaltSize:]
+	return newPasswordStream(password, a, bufSize, params, salt, nonce, header)
+}
+
+func newPasswordStream(password []byte, a sio.Algorithm, bufSize int, params PasswordParams, salt, nonce, header []byte) (*PasswordStream, error) {
+	key, err := passwordKey(password, salt, params, a.KeySize())
+	if err != nil {
+		return nil, err
+	}
+	stream, err := a.StreamWithBufSize(key, bufSize)
+	if err != nil {
+		return nil, err
+	}
+	return &PasswordStream{
+		stream:    stream,
+		Algorithm: a,
+		Params:    params,
+		Nonce:     nonce,
+		Header:    header,
+	}, nil
+}
+
+// BufSize returns the buffer size of the data stream.
+func (s *PasswordStream) BufSize() int { return s.stream.BufSize() }
+
+// EncryptWriter returns a new EncWriter that wraps w and
+// encrypts and authenticates everything before writing it
+// to w. It uses the Nonce and the Header as associated data.
+//
+// The EncWriter does not write the Header. It must be
+// written to w before.
+func (s *PasswordStream) EncryptWriter(w io.Writer) *sio.EncWriter {
+	return s.stream.EncryptWriter(w, s.Nonce, s.Header)
+}
+
+// DecryptReader returns a new DecReader that wraps r and
+// decrypts and verifies everything it reads from r. It
+// uses the Nonce and the Header as associated data.
+//
+// The r must be positioned at the start of the encrypted
+// data stream - i.e. after the Header.
+func (s *PasswordStream) DecryptReader(r io.Reader) *sio.DecReader {
+	return s.stream.DecryptReader(r, s.Nonce, s.Header)
+}
+
+// DecryptReaderAt returns a new DecReaderAt that wraps r and
+// decrypts and verifies everything it reads from r. It uses
+// the Nonce and the Header as associated data.
+//
+// The offset 0 of r must be the start of the encrypted data
+// stream - e.g. an io.SectionReader that starts after the Header.
+func (s *PasswordStream) DecryptReaderAt(r io.ReaderAt) *sio.DecReaderAt {
+	return s.stream.DecryptReaderAt(r, s.Nonce, s.Header)
+}
+
+// passwordParamsSize is the size of encoded PasswordParams.
+const passwordParamsSize = 1 + 9
+
+// appendPasswordParams appends the binary encoding
+// of the params to b and returns the extended slice.
+func appendPasswordParams(b []byte, params PasswordParams) []byte {
+	b = append(b, byte(params.KDF))
+	switch params.KDF {
+	case Argon2id:
+		b = binary.BigEndian.AppendUint32(b, params.Time)
+		b = binary.BigEndian.AppendUint32(b, params.Memory)
+		b = append(b, params.Threads)
+	case Scrypt:
+		b = append(b, params.LogN)
+		b = binary.BigEndian.AppendUint32(b, params.R)
+		b = binary.BigEndian.AppendUint32(b, params.P)
+	}
+	return b
+}
+
+// parsePasswordParams parses binary encoded PasswordParams,
+// as written by appendPasswordParams, from the first
+// passwordParamsSize bytes of b. It returns ErrPasswordLimit
+// if the params exceed the limits.
+func parsePasswordParams(b []byte, limits *PasswordLimits) (PasswordParams, error) {
+	params := PasswordParams{KDF: PasswordKDF(b[0])}
+	switch p := b[1:]; params.KDF {
+	case Argon2id:
+		params.Time = binary.BigEndian.Uint32(p[0:])
+		params.Memory = binary.BigEndian.Uint32(p[4:])
+		params.Threads = p[8]
+		if params.Time > limits.MaxArgon2idTime || params.Memory > limits.MaxArgon2idMemory || params.Threads > limits.MaxArgon2idThreads {
+			return params, ErrPasswordLimit
+		}
+	case Scrypt:
+		params.LogN = p[0]
+		params.R = binary.BigEndian.Uint32(p[1:])
+		params.P = binary.BigEndian.Uint32(p[5:])
+		if params.LogN > limits.MaxScryptLogN || params.R > limits.MaxScryptR || params.P > limits.MaxScryptP {
+			return params, ErrPasswordLimit
+		}
+	}
+	return params, checkParams(params)
+}
+
+// passwordKey derives an n byte key from the password
+// and salt using the KDF of the params.
+func passwordKey(password, salt []byte, params PasswordParams, n int) ([]byte, error) {
+	switch params.KDF {
+	case Argon2id:
+		return argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, uint32(n)), nil
+	case Scrypt:
+		return scrypt.Key(password, salt, 1<<params.LogN, int(params.R), int(params.P), n)
+	default:
+		return nil, errors.New("sioutil: unknown KDF " + strconv.Itoa(int(params.KDF)))
+	}
+}
+
+// checkMinParams returns an error if the params
+// are below the minimum parameters of the KDF.
+func checkMinParams(params PasswordParams) error {
+	if err := checkParams(params); err != nil {
+		return errors.New("sioutil: " + err.Error())
+	}
+	switch m := MinArgon2idParams; params.KDF {
+	case Argon2id:
+		if params.Time < m.Time || params.Memory < m.Memory || params.Threads < m.Threads {
+			return errors.New("sioutil: Argon2id parameters are below the minimum of time=" + strconv.Itoa(int(m.Time)) + ", memory=" + strconv.Itoa(int(m.Memory)) + " KiB, threads=" + strconv.Itoa(int(m.Threads)))
+		}
+	case Scrypt:
+		m = MinScryptParams
+		if params.LogN < m.LogN || params.R < m.R || params.P < m.P {
+			return errors.New("sioutil: scrypt parameters are below the minimum of N=2^" + strconv.Itoa(int(m.LogN)) + ", r=" + strconv.Itoa(int(m.R)) + ", p=" + strconv.Itoa(int(m.P)))
+		}
+	}
+	return nil
+}
+
+// checkParams returns an error if the params
+// are not valid parameters for the KDF.
+func checkParams(params PasswordParams) error {
+	switch params.KDF {
+	case Argon2id:
+		if params.Time == 0 || params.Threads == 0 || params.Memory < 8*uint32(params.Threads) {
+			return errors.New("invalid Argon2id parameters")
+		}
+	case Scrypt:
+		if params.LogN == 0 || params.LogN >= 63 || params.R == 0 || params.P == 0 || uint64(params.R)*uint64(params.P) >= 1<<30 {
+			return errors.New("invalid scrypt parameters")
+		}
+	default:
+		return errors.New("unknown KDF " + strconv.Itoa(int(params.KDF)))
+	}
+	return nil
+}
+
+func errPasswordHeader(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("sioutil: invalid password header: data is too short")
+	}
+	return err
+}
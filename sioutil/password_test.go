@@ -0,0 +1,115 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+func openPasswordStream(password []byte) func(io.Reader) (*PasswordStream, error) {
+	return func(r io.Reader) (*PasswordStream, error) { return OpenPasswordStream(r, password, nil) }
+}
+
+func TestPasswordStream(t *testing.T) {
+	password := []byte("correct horse battery staple")
+	plaintext := MustRandom(3*1000 + 7)
+
+	for i, params := range []PasswordParams{MinArgon2idParams, MinScryptParams} {
+		stream, err := NewPasswordStream(password, sio.XChaCha20Poly1305, 1000, params)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create PasswordStream: %v", i, err)
+		}
+		ciphertext := encryptStream(t, stream.Header, stream.EncryptWriter, plaintext)
+
+		stream, err = OpenPasswordStream(bytes.NewReader(ciphertext), password, nil)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to open PasswordStream: %v", i, err)
+		}
+		if stream.Algorithm != sio.XChaCha20Poly1305 || stream.BufSize() != 1000 || stream.Params != params {
+			t.Fatalf("Test %d: Header does not match: got %v %d %v", i, stream.Algorithm, stream.BufSize(), stream.Params)
+		}
+
+		output, err := decryptStream(ciphertext, openPasswordStream(password))
+		if err != nil {
+			t.Fatalf("Test %d: Failed to decrypt data stream: %v", i, err)
+		}
+		if !bytes.Equal(output, plaintext) {
+			t.Fatalf("Test %d: Plaintext does not match original plaintext", i)
+		}
+
+		section := io.NewSectionReader(bytes.NewReader(ciphertext), int64(len(stream.Header)), int64(len(ciphertext)-len(stream.Header)))
+		p := make([]byte, 1500)
+		if _, err = stream.DecryptReaderAt(section).ReadAt(p, 999); err != nil {
+			t.Fatalf("Test %d: Failed to decrypt data stream section: %v", i, err)
+		}
+		if !bytes.Equal(p, plaintext[999:999+1500]) {
+			t.Fatalf("Test %d: Plaintext section does not match original plaintext", i)
+		}
+	}
+}
+
+func TestPasswordStreamNotAuthentic(t *testing.T) {
+	password := []byte("correct horse battery staple")
+	stream, err := NewPasswordStream(password, sio.AES_128_GCM, sio.BufSize, MinArgon2idParams)
+	if err != nil {
+		t.Fatalf("Failed to create PasswordStream: %v", err)
+	}
+	ciphertext := encryptStream(t, stream.Header, stream.EncryptWriter, MustRandom(100))
+
+	if _, err = decryptStream(ciphertext, openPasswordStream([]byte("wrong password"))); err != sio.NotAuthentic {
+		t.Fatalf("Decryption with wrong password should fail with NotAuthentic: got %v", err)
+	}
+
+	// Modifying the header - e.g. the Argon2id time parameter or the
+	// nonce - must be detected.
+	for _, offset := range []int{14, len(passwordMagic) + 1 + 4 + 1 + 9 + passwordSaltSize} {
+		modified := append([]byte(nil), ciphertext...)
+		modified[offset] ^= 2
+		if _, err = decryptStream(modified, openPasswordStream(password)); err != sio.NotAuthentic {
+			t.Fatalf("Decryption of modified header at offset %d should fail with NotAuthentic: got %v", offset, err)
+		}
+	}
+}
+
+func TestPasswordStreamMinParams(t *testing.T) {
+	for i, params := range []PasswordParams{
+		{KDF: Argon2id, Time: 1, Memory: MinArgon2idParams.Memory - 1, Threads: 1},
+		{KDF: Argon2id, Time: 0, Memory: MinArgon2idParams.Memory, Threads: 1},
+		{KDF: Argon2id, Time: 1, Memory: MinArgon2idParams.Memory, Threads: 0},
+		{KDF: Scrypt, LogN: MinScryptParams.LogN - 1, R: 8, P: 1},
+		{KDF: Scrypt, LogN: MinScryptParams.LogN, R: 7, P: 1},
+		{KDF: Scrypt, LogN: MinScryptParams.LogN, R: 8, P: 0},
+		{KDF: 0},
+	} {
+		if _, err := NewPasswordStream([]byte("password"), sio.AES_128_GCM, sio.BufSize, params); err == nil {
+			t.Fatalf("Test %d: NewPasswordStream should fail for params %v", i, params)
+		}
+	}
+}
+
+func TestPasswordStreamLimits(t *testing.T) {
+	password := []byte("password")
+	limits := DefaultPasswordLimits
+	limits.MaxArgon2idMemory = MinArgon2idParams.Memory - 1
+	limits.MaxScryptLogN = MinScryptParams.LogN - 1
+
+	for i, params := range []PasswordParams{MinArgon2idParams, MinScryptParams} {
+		stream, err := NewPasswordStream(password, sio.AES_128_GCM, sio.BufSize, params)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create PasswordStream: %v", i, err)
+		}
+		ciphertext := encryptStream(t, stream.Header, stream.EncryptWriter, nil)
+		if _, err = OpenPasswordStream(bytes.NewReader(ciphertext), password, &limits); err != ErrPasswordLimit {
+			t.Fatalf("Test %d: OpenPasswordStream should fail with ErrPasswordLimit: got %v", i, err)
+		}
+		if _, err = OpenPasswordStream(bytes.NewReader(ciphertext[:len(ciphertext)-sio.AES_128_GCM.Overhead()-1]), password, nil); err == nil {
+			t.Fatalf("Test %d: OpenPasswordStream should fail for a truncated header", i)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"crypto/fips140"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/secure-io/sio-go"
+)
+
+// A Policy describes the requirements an algorithm
+// selected by SelectAlgorithm has to satisfy.
+type Policy struct {
+	// SecurityLevel is the minimum security level in bits.
+	// It must be either 128 or 256. If zero, a security
+	// level of 128 bits is used.
+	//
+	// The security level of an algorithm is the size of
+	// its secret key in bits - except for AES-SIV which
+	// provides half of its key size.
+	SecurityLevel int
+
+	// MinNonceSize is the minimum size of the Stream nonce
+	// in bytes. Applications that generate nonces at random
+	// should require a large nonce - e.g. 20 bytes. If zero,
+	// any nonce size is acceptable.
+	MinNonceSize int
+}
+
+// A Selection is the result of SelectAlgorithm.
+// It contains the selected algorithm and explains
+// why it has been selected.
+type Selection struct {
+	// Algorithm is the selected algorithm.
+	Algorithm sio.Algorithm
+
+	// NativeAES reports whether the executing CPU
+	// provides AES-GCM hardware instructions.
+	// See: NativeAES
+	NativeAES bool
+
+	// FIPS reports whether the program runs in
+	// FIPS 140-3 mode. If so, only FIPS 140-3
+	// approved algorithms are selected.
+	// See: sio.Algorithm.FIPSApproved
+	FIPS bool
+
+	// Override reports whether the algorithm has
+	// been set explicitly via SetAlgorithmOverride.
+	Override bool
+
+	// Reason is a human-readable explanation of
+	// the decision.
+	Reason string
+}
+
+// String returns the selected algorithm and
+// the reason for selecting it.
+func (s Selection) String() string { return s.Algorithm.String() + ": " + s.Reason }
+
+var (
+	overrideLock sync.RWMutex
+	override     sio.Algorithm
+)
+
+// SetAlgorithmOverride makes SelectAlgorithm return the given
+// algorithm - regardless of the CPU capabilities. The algorithm
+// must still satisfy the policy passed to SelectAlgorithm.
+// Setting the override to the empty algorithm removes it.
+//
+// It is mainly useful for tests and for deployments that must
+// use the same algorithm on all machines.
+func SetAlgorithmOverride(a sio.Algorithm) error {
+	if a != "" {
+		if _, err := sio.ParseAlgorithm(a.String()); err != nil {
+			return err
+		}
+	}
+
+	overrideLock.Lock()
+	defer overrideLock.Unlock()
+
+	override = a
+	return nil
+}
+
+// SelectAlgorithm returns the recommended algorithm for the
+// executing CPU that satisfies the given policy.
+//
+// If the CPU provides AES-GCM hardware instructions then
+// SelectAlgorithm prefers AES-GCM. Otherwise, or when the
+// policy requires a nonce larger than the 8 bytes of AES-GCM,
+// it selects XChaCha20-Poly1305.
+//
+// In FIPS 140-3 mode, SelectAlgorithm only selects FIPS 140-3
+// approved algorithms. It prefers AES-GCM - unless FIPS 140-3
+// mode is enforced strictly (GODEBUG=fips140=only) - and selects
+// AES-256-CTR-HMAC-SHA256 otherwise. Since none of the approved
+// algorithms supports a nonce larger than 8 bytes, it returns an
+// error if the policy requires one.
+//
+// If an override has been set via SetAlgorithmOverride then
+// SelectAlgorithm returns the override if it satisfies the
+// policy. Otherwise, it returns an error.
+func SelectAlgorithm(policy Policy) (Selection, error) {
+	mode := fipsOff
+	if fips140.Enabled() {
+		mode = fipsOn
+		if _, err := sio.AES_128_GCM.Stream(make([]byte, sio.AES_128_GCM.KeySize())); err != nil {
+			mode = fipsOnly // AES-GCM with externally generated nonces is not allowed
+		}
+	}
+	return selectAlgorithm(policy, NativeAES(), mode)
+}
+
+// fipsMode describes whether and how strictly
+// FIPS 140-3 mode is enabled.
+type fipsMode int
+
+const (
+	fipsOff  fipsMode = iota // GODEBUG=fips140=off
+	fipsOn                   // GODEBUG=fips140=on
+	fipsOnly                 // GODEBUG=fips140=only
+)
+
+func selectAlgorithm(policy Policy, nativeAES bool, mode fipsMode) (Selection, error) {
+	switch policy.SecurityLevel {
+	case 0:
+		policy.SecurityLevel = 128
+	case 128, 256:
+	default:
+		return Selection{}, errors.New("sioutil: invalid security level " + strconv.Itoa(policy.SecurityLevel))
+	}
+	if policy.MinNonceSize < 0 {
+		return Selection{}, errors.New("sioutil: invalid nonce size " + strconv.Itoa(policy.MinNonceSize))
+	}
+
+	overrideLock.RLock()
+	a := override
+	overrideLock.RUnlock()
+
+	if a != "" {
+		if mode != fipsOff && !a.FIPSApproved() {
+			return Selection{}, errors.New("sioutil: " + a.String() + " is not FIPS 140-3 approved")
+		}
+		if err := satisfies(a, policy); err != nil {
+			return Selection{}, err
+		}
+		return Selection{
+			Algorithm: a,
+			NativeAES: nativeAES,
+			FIPS:      mode != fipsOff,
+			Override:  true,
+			Reason:    "the algorithm has been set explicitly",
+		}, nil
+	}
+
+	var reason string
+	switch {
+	case mode == fipsOnly:
+		a = sio.AES_256_CTR_HMAC_SHA256
+		reason = "FIPS 140-3 mode is enforced and AES-GCM with externally generated nonces is not allowed"
+	case mode == fipsOn:
+		reason = "FIPS 140-3 mode is enabled"
+		if policy.SecurityLevel == 128 {
+			a = sio.AES_128_GCM
+		} else {
+			a = sio.AES_256_GCM
+		}
+	case nativeAES && policy.MinNonceSize <= sio.AES_128_GCM.StreamNonceSize():
+		reason = "the CPU provides AES-GCM hardware instructions"
+		if policy.SecurityLevel == 128 {
+			a = sio.AES_128_GCM
+		} else {
+			a = sio.AES_256_GCM
+		}
+	case nativeAES:
+		a = sio.XChaCha20Poly1305
+		reason = "the CPU provides AES-GCM hardware instructions but the policy requires a nonce larger than " + strconv.Itoa(sio.AES_128_GCM.StreamNonceSize()) + " bytes"
+	default:
+		a = sio.XChaCha20Poly1305
+		reason = "the CPU does not provide AES-GCM hardware instructions"
+	}
+	if err := satisfies(a, policy); err != nil {
+		return Selection{}, err
+	}
+	return Selection{
+		Algorithm: a,
+		NativeAES: nativeAES,
+		FIPS:      mode != fipsOff,
+		Reason:    reason,
+	}, nil
+}
+
+// satisfies returns an error if the algorithm does
+// not satisfy the given policy.
+func satisfies(a sio.Algorithm, policy Policy) error {
+	securityLevel := 8 * a.KeySize()
+	if a == sio.AES_SIV_CMAC_256 || a == sio.AES_SIV_CMAC_512 {
+		securityLevel /= 2
+	}
+	if securityLevel < policy.SecurityLevel {
+		return errors.New("sioutil: " + a.String() + " does not provide a " + strconv.Itoa(policy.SecurityLevel) + " bit security level")
+	}
+	if a.StreamNonceSize() < policy.MinNonceSize {
+		return errors.New("sioutil: " + a.String() + " does not support " + strconv.Itoa(policy.MinNonceSize) + " byte nonces")
+	}
+	return nil
+}
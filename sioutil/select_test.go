@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+var selectAlgorithmTests = []struct {
+	Policy    Policy
+	NativeAES bool
+	FIPS      fipsMode
+	Algorithm sio.Algorithm
+	Err       bool
+}{
+	{Policy: Policy{}, NativeAES: true, Algorithm: sio.AES_128_GCM},                                            // 0
+	{Policy: Policy{SecurityLevel: 128}, NativeAES: true, Algorithm: sio.AES_128_GCM},                          // 1
+	{Policy: Policy{SecurityLevel: 256}, NativeAES: true, Algorithm: sio.AES_256_GCM},                          // 2
+	{Policy: Policy{MinNonceSize: 8}, NativeAES: true, Algorithm: sio.AES_128_GCM},                             // 3
+	{Policy: Policy{MinNonceSize: 12}, NativeAES: true, Algorithm: sio.XChaCha20Poly1305},                      // 4
+	{Policy: Policy{}, NativeAES: false, Algorithm: sio.XChaCha20Poly1305},                                     // 5
+	{Policy: Policy{SecurityLevel: 256, MinNonceSize: 20}, NativeAES: false, Algorithm: sio.XChaCha20Poly1305}, // 6
+	{Policy: Policy{MinNonceSize: 21}, NativeAES: true, Err: true},                                             // 7
+	{Policy: Policy{SecurityLevel: 192}, NativeAES: true, Err: true},                                           // 8
+	{Policy: Policy{MinNonceSize: -1}, NativeAES: true, Err: true},                                             // 9
+
+	{Policy: Policy{}, NativeAES: false, FIPS: fipsOn, Algorithm: sio.AES_128_GCM},                                // 10
+	{Policy: Policy{SecurityLevel: 256}, NativeAES: true, FIPS: fipsOn, Algorithm: sio.AES_256_GCM},               // 11
+	{Policy: Policy{}, NativeAES: true, FIPS: fipsOnly, Algorithm: sio.AES_256_CTR_HMAC_SHA256},                   // 12
+	{Policy: Policy{SecurityLevel: 256}, NativeAES: true, FIPS: fipsOnly, Algorithm: sio.AES_256_CTR_HMAC_SHA256}, // 13
+	{Policy: Policy{MinNonceSize: 12}, NativeAES: true, FIPS: fipsOn, Err: true},                                  // 14
+	{Policy: Policy{MinNonceSize: 12}, NativeAES: true, FIPS: fipsOnly, Err: true},                                // 15
+}
+
+func TestSelectAlgorithm(t *testing.T) {
+	for i, test := range selectAlgorithmTests {
+		selection, err := selectAlgorithm(test.Policy, test.NativeAES, test.FIPS)
+		if err == nil && test.Err {
+			t.Fatalf("Test %d: should have failed but selected %s", i, selection.Algorithm)
+		}
+		if err != nil && !test.Err {
+			t.Fatalf("Test %d: failed to select algorithm: %v", i, err)
+		}
+		if err != nil {
+			continue
+		}
+		if selection.Algorithm != test.Algorithm {
+			t.Fatalf("Test %d: got %s - want %s", i, selection.Algorithm, test.Algorithm)
+		}
+		if selection.NativeAES != test.NativeAES || selection.FIPS != (test.FIPS != fipsOff) || selection.Override || selection.Reason == "" {
+			t.Fatalf("Test %d: invalid selection: %+v", i, selection)
+		}
+	}
+}
+
+func TestSetAlgorithmOverride(t *testing.T) {
+	defer SetAlgorithmOverride("")
+
+	if err := SetAlgorithmOverride(sio.ChaCha20Poly1305); err != nil {
+		t.Fatalf("Failed to set override: %v", err)
+	}
+	selection, err := selectAlgorithm(Policy{SecurityLevel: 256}, true, fipsOff)
+	if err != nil {
+		t.Fatalf("Failed to select algorithm: %v", err)
+	}
+	if selection.Algorithm != sio.ChaCha20Poly1305 || !selection.Override {
+		t.Fatalf("Override has been ignored: %+v", selection)
+	}
+	if _, err = selectAlgorithm(Policy{MinNonceSize: 20}, true, fipsOff); err == nil {
+		t.Fatal("Override does not satisfy the policy but has been selected")
+	}
+
+	if err = SetAlgorithmOverride(sio.AES_128_GCM); err != nil {
+		t.Fatalf("Failed to set override: %v", err)
+	}
+	if _, err = selectAlgorithm(Policy{SecurityLevel: 256}, true, fipsOff); err == nil {
+		t.Fatal("Override does not satisfy the policy but has been selected")
+	}
+
+	if err = SetAlgorithmOverride("AES-512-GCM"); err == nil {
+		t.Fatal("Unknown algorithm has been accepted as override")
+	}
+
+	if err = SetAlgorithmOverride(sio.ChaCha20Poly1305); err != nil {
+		t.Fatalf("Failed to set override: %v", err)
+	}
+	if _, err = selectAlgorithm(Policy{}, true, fipsOn); err == nil {
+		t.Fatal("Non-approved override has been selected in FIPS 140-3 mode")
+	}
+
+	if err = SetAlgorithmOverride(""); err != nil {
+		t.Fatalf("Failed to remove override: %v", err)
+	}
+	if selection, err = selectAlgorithm(Policy{}, false, fipsOff); err != nil || selection.Override {
+		t.Fatalf("Override has not been removed: %+v - %v", selection, err)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+
+	"github.com/secure-io/sio-go"
+)
+
+// ErrInvalidShare is returned by CombineKey if a key
+// share is malformed or its checksum does not match.
+var ErrInvalidShare = errors.New("sioutil: invalid key share")
+
+// shareMagic is the first part of every key share.
+// Its last byte is the version of the share format.
+const shareMagic = "SIOS\x01"
+
+// shareChecksumSize is the size of the checksum
+// at the end of every key share.
+const shareChecksumSize = 4
+
+// SplitKey splits the key into n shares using Shamir's secret
+// sharing over GF(256). Any threshold of the n shares can be
+// combined to recover the key while fewer shares do not reveal
+// the key. The threshold must be at least 2 and n must not be
+// greater than 255.
+//
+// The key must be a valid key for the algorithm. If the algorithm
+// is empty, the key is treated as master key - e.g. of a KeyDeriver -
+// that must be at least 16 bytes long.
+//
+// Each share contains the algorithm ID, the threshold, a key check
+// value and a checksum. It is not encrypted and must be kept secret
+// by its holder. The key check value allows CombineKey to detect
+// inconsistent shares. However, it also allows anyone holding a
+// single share to verify a guessed key. Hence, the key must have
+// enough entropy - i.e. it must be randomly generated. The key check
+// value differs from the one stored in KeyRing headers such that a
+// share cannot be linked to data streams encrypted via a KeyRing.
+// For example:
+//
+//	shares, err := sioutil.SplitKey(sio.AES_256_GCM, key, 5, 3)
+//	if err != nil {
+//		// TODO: error handling
+//	}
+//	// Later, combine any 3 of the 5 shares:
+//	a, key, err := sioutil.CombineKey(shares[1], shares[3], shares[4])
+func SplitKey(a sio.Algorithm, key []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, errors.New("sioutil: invalid number of key shares: n=" + strconv.Itoa(n) + ", threshold=" + strconv.Itoa(threshold))
+	}
+	if err := checkShareKey(a, key); err != nil {
+		return nil, err
+	}
+
+	// The i-th byte of the key is the constant term of the
+	// i-th polynomial. The other coefficients are random.
+	coefficients, err := Random(len(key) * (threshold - 1))
+	if err != nil {
+		return nil, err
+	}
+	kcv := shareCheckValue(a, key)
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		x := byte(i + 1)
+		share := make([]byte, 0, len(shareMagic)+3+keyCheckValueSize+len(key)+shareChecksumSize)
+		share = append(share, shareMagic...)
+		share = append(share, a.ID(), byte(threshold), x)
+		share = append(share, kcv...)
+		for j, b := range key {
+			share = append(share, evalPolynomial(b, coefficients[j*(threshold-1):(j+1)*(threshold-1)], x))
+		}
+		shares[i] = append(share, shareChecksum(share)...)
+	}
+	return shares, nil
+}
+
+// CombineKey combines key shares, as returned by SplitKey, and
+// returns the algorithm and the key. The key can be passed to
+// Algorithm.Stream directly - or to NewKeyDeriver if the algorithm
+// is empty.
+//
+// CombineKey returns ErrInvalidShare if a share is malformed or has
+// been modified, and an error if the shares belong to different keys
+// or if there are fewer shares than the threshold.
+func CombineKey(shares ...[]byte) (sio.Algorithm, []byte, error) {
+	const headerSize = len(shareMagic) + 3 + keyCheckValueSize
+
+	if len(shares) == 0 {
+		return "", nil, errors.New("sioutil: no key shares")
+	}
+	for _, share := range shares {
+		if len(share) <= headerSize+shareChecksumSize || string(share[:len(shareMagic)]) != shareMagic {
+			return "", nil, ErrInvalidShare
+		}
+		data, checksum := share[:len(share)-shareChecksumSize], share[len(share)-shareChecksumSize:]
+		if !hmac.Equal(shareChecksum(data), checksum) {
+			return "", nil, ErrInvalidShare
+		}
+	}
+
+	first := shares[0]
+	id, threshold, kcv := first[5], int(first[6]), first[8:headerSize]
+	keySize := len(first) - headerSize - shareChecksumSize
+	if threshold < 2 {
+		return "", nil, ErrInvalidShare
+	}
+	xs := make([]byte, len(shares))
+	for i, share := range shares {
+		if share[5] != id || int(share[6]) != threshold || !hmac.Equal(share[8:headerSize], kcv) || len(share) != len(first) {
+			return "", nil, errors.New("sioutil: key shares belong to different keys")
+		}
+		xs[i] = share[7]
+		if xs[i] == 0 {
+			return "", nil, ErrInvalidShare
+		}
+		for _, x := range xs[:i] {
+			if x == xs[i] {
+				return "", nil, errors.New("sioutil: duplicate key share " + strconv.Itoa(int(x)))
+			}
+		}
+	}
+	if len(shares) < threshold {
+		return "", nil, errors.New("sioutil: not enough key shares: " + strconv.Itoa(len(shares)) + " of " + strconv.Itoa(threshold))
+	}
+
+	var a sio.Algorithm
+	if id != 0 {
+		var err error
+		if a, err = sio.AlgorithmFromID(id); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Interpolate the polynomials at x = 0 using the Lagrange
+	// basis polynomials l_i(0) = Π x_j / (x_j - x_i) for j != i.
+	// In GF(256), subtraction is XOR.
+	key := make([]byte, keySize)
+	for i, share := range shares {
+		l := byte(1)
+		for j, x := range xs {
+			if i != j {
+				l = gfMul(l, gfMul(x, gfInv(x^xs[i])))
+			}
+		}
+		for k, y := range share[headerSize : headerSize+keySize] {
+			key[k] ^= gfMul(l, y)
+		}
+	}
+	if !hmac.Equal(shareCheckValue(a, key), kcv) {
+		return "", nil, errors.New("sioutil: key shares are inconsistent")
+	}
+	if err := checkShareKey(a, key); err != nil {
+		return "", nil, err
+	}
+	return a, key, nil
+}
+
+// shareCheckValue returns the key check value stored in every
+// key share. It uses a different label than keyCheckValue.
+func shareCheckValue(a sio.Algorithm, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("sio: key share check value for " + a.String()))
+	return mac.Sum(nil)[:keyCheckValueSize]
+}
+
+// checkShareKey returns an error if the key is not a valid key
+// for the algorithm or - if the algorithm is empty - not a valid
+// master key.
+func checkShareKey(a sio.Algorithm, key []byte) error {
+	if a == "" {
+		if len(key) < minMasterKeySize {
+			return errors.New("sioutil: master key is too short: " + strconv.Itoa(len(key)) + " bytes - at least " + strconv.Itoa(minMasterKeySize) + " bytes are required")
+		}
+		return nil
+	}
+	if a.ID() == 0 {
+		return sio.UnknownAlgorithmError{Name: a.String()}
+	}
+	if len(key) != a.KeySize() {
+		return errors.New("sioutil: invalid key size " + strconv.Itoa(len(key)) + " for " + a.String())
+	}
+	return nil
+}
+
+func shareChecksum(share []byte) []byte {
+	sum := sha256.Sum256(share)
+	return sum[:shareChecksumSize]
+}
+
+// evalPolynomial evaluates the polynomial with the constant
+// term c0 and the remaining coefficients at x using Horner's
+// method.
+func evalPolynomial(c0 byte, coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+	return gfMul(y, x) ^ c0
+}
+
+// gfMul returns the product of a and b in GF(256) with the
+// AES reduction polynomial x⁸ + x⁴ + x³ + x + 1. It does not
+// use lookup tables and does not branch on its inputs.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ -(a>>7)&0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the multiplicative inverse of a in GF(256),
+// computed as a²⁵⁴. The inverse of 0 is 0.
+func gfInv(a byte) byte {
+	b := gfMul(a, a) // a²
+	c := b           // a²
+	for i := 0; i < 6; i++ {
+		b = gfMul(b, b) // a⁴, a⁸, ..., a¹²⁸
+		c = gfMul(c, b) // a⁶, a¹⁴, ..., a²⁵⁴
+	}
+	return c
+}
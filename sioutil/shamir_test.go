@@ -0,0 +1,152 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+func TestGFMul(t *testing.T) {
+	// See: FIPS 197, section 4.2
+	if p := gfMul(0x57, 0x83); p != 0xc1 {
+		t.Fatalf("gfMul(0x57, 0x83) = %#x - want 0xc1", p)
+	}
+	if p := gfMul(0x57, 0x13); p != 0xfe {
+		t.Fatalf("gfMul(0x57, 0x13) = %#x - want 0xfe", p)
+	}
+	for a := 1; a < 256; a++ {
+		if p := gfMul(byte(a), gfInv(byte(a))); p != 1 {
+			t.Fatalf("Test %d: a * a⁻¹ = %#x - want 1", a, p)
+		}
+	}
+}
+
+var splitKeyTests = []struct {
+	Algorithm sio.Algorithm
+	KeySize   int
+	N         int
+	Threshold int
+}{
+	{Algorithm: sio.AES_128_GCM, KeySize: 16, N: 2, Threshold: 2},
+	{Algorithm: sio.AES_256_GCM, KeySize: 32, N: 5, Threshold: 3},
+	{Algorithm: sio.XChaCha20Poly1305, KeySize: 32, N: 255, Threshold: 255},
+	{Algorithm: "", KeySize: 64, N: 10, Threshold: 4}, // master key
+}
+
+func TestSplitKey(t *testing.T) {
+	for i, test := range splitKeyTests {
+		key := MustRandom(test.KeySize)
+		shares, err := SplitKey(test.Algorithm, key, test.N, test.Threshold)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to split key: %v", i, err)
+		}
+		if len(shares) != test.N {
+			t.Fatalf("Test %d: Invalid number of shares: got %d - want %d", i, len(shares), test.N)
+		}
+
+		// Any threshold shares recover the key.
+		for _, subset := range [][][]byte{
+			shares[:test.Threshold],
+			shares[test.N-test.Threshold:],
+			shares,
+		} {
+			a, combined, err := CombineKey(subset...)
+			if err != nil {
+				t.Fatalf("Test %d: Failed to combine key shares: %v", i, err)
+			}
+			if a != test.Algorithm || !bytes.Equal(combined, key) {
+				t.Fatalf("Test %d: Combined key does not match original key", i)
+			}
+		}
+		if _, _, err = CombineKey(shares[:test.Threshold-1]...); err == nil {
+			t.Fatalf("Test %d: Combining fewer shares than the threshold should fail", i)
+		}
+	}
+}
+
+func TestSplitKeyCheckValue(t *testing.T) {
+	key := MustRandom(16)
+	shares, err := SplitKey(sio.AES_128_GCM, key, 3, 2)
+	if err != nil {
+		t.Fatalf("Failed to split key: %v", err)
+	}
+
+	// A share must not contain the key check value of KeyRing
+	// headers. Otherwise, it could be linked to encrypted data.
+	kcv := keyCheckValue(sio.AES_128_GCM, key)
+	for i, share := range shares {
+		if bytes.Contains(share, kcv) {
+			t.Fatalf("Share %d contains the key ring key check value", i)
+		}
+	}
+}
+
+func TestCombineKeyStream(t *testing.T) {
+	shares, err := SplitKey(sio.ChaCha20Poly1305, MustRandom(32), 3, 2)
+	if err != nil {
+		t.Fatalf("Failed to split key: %v", err)
+	}
+	a, key, err := CombineKey(shares[2], shares[0])
+	if err != nil {
+		t.Fatalf("Failed to combine key shares: %v", err)
+	}
+	if _, err = a.Stream(key); err != nil {
+		t.Fatalf("Failed to create Stream from combined key: %v", err)
+	}
+}
+
+func TestCombineKeyInvalid(t *testing.T) {
+	shares, err := SplitKey(sio.AES_256_GCM, MustRandom(32), 3, 2)
+	if err != nil {
+		t.Fatalf("Failed to split key: %v", err)
+	}
+	other, err := SplitKey(sio.AES_256_GCM, MustRandom(32), 3, 2)
+	if err != nil {
+		t.Fatalf("Failed to split key: %v", err)
+	}
+
+	for i := range shares[0] {
+		modified := append([]byte(nil), shares[0]...)
+		modified[i] ^= 1
+		if _, _, err = CombineKey(modified, shares[1]); err != ErrInvalidShare {
+			t.Fatalf("Test %d: Combining a modified share should fail with ErrInvalidShare: got %v", i, err)
+		}
+	}
+	if _, _, err = CombineKey(shares[0][:len(shares[0])-1], shares[1]); err != ErrInvalidShare {
+		t.Fatalf("Combining a truncated share should fail with ErrInvalidShare: got %v", err)
+	}
+	if _, _, err = CombineKey(shares[0], other[1]); err == nil {
+		t.Fatal("Combining shares of different keys should fail")
+	}
+	if _, _, err = CombineKey(shares[0], shares[0]); err == nil {
+		t.Fatal("Combining duplicate shares should fail")
+	}
+	if _, _, err = CombineKey(); err == nil {
+		t.Fatal("Combining no shares should fail")
+	}
+}
+
+func TestSplitKeyInvalid(t *testing.T) {
+	for i, test := range []struct {
+		Algorithm sio.Algorithm
+		KeySize   int
+		N         int
+		Threshold int
+	}{
+		{Algorithm: sio.AES_128_GCM, KeySize: 16, N: 3, Threshold: 1},
+		{Algorithm: sio.AES_128_GCM, KeySize: 16, N: 3, Threshold: 4},
+		{Algorithm: sio.AES_128_GCM, KeySize: 16, N: 256, Threshold: 2},
+		{Algorithm: sio.AES_128_GCM, KeySize: 32, N: 3, Threshold: 2},
+		{Algorithm: "", KeySize: 15, N: 3, Threshold: 2},
+		{Algorithm: "unknown", KeySize: 32, N: 3, Threshold: 2},
+	} {
+		if _, err := SplitKey(test.Algorithm, MustRandom(test.KeySize), test.N, test.Threshold); err == nil {
+			t.Fatalf("Test %d: Splitting key should fail", i)
+		}
+	}
+}
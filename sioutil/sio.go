@@ -65,11 +65,12 @@ func NativeAES() bool {
 // Random returns n randomly generated bytes if
 // and only if err == nil.
 //
-// Random uses crypto/rand.Reader as cryptographically
-// secure random number generator (CSPRNG).
+// Random uses crypto/rand.Read as cryptographically
+// secure random number generator (CSPRNG). In FIPS 140-3
+// mode, crypto/rand uses the FIPS 140-3 approved DRBG.
 func Random(n int) (b []byte, err error) {
 	b = make([]byte, n)
-	if _, err = io.ReadFull(rand.Reader, b); err != nil {
+	if _, err = rand.Read(b); err != nil {
 		return nil, err
 	}
 	return b, nil
@@ -79,7 +80,7 @@ func Random(n int) (b []byte, err error) {
 // It panics if it fails to read n random bytes
 // from its entropy source.
 //
-// MustRandom uses crypto/rand.Reader as cryptographically
+// MustRandom uses crypto/rand.Read as cryptographically
 // secure random number generator (CSPRNG).
 func MustRandom(n int) []byte {
 	b, err := Random(n)
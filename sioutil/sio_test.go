@@ -4,7 +4,49 @@
 
 package sioutil
 
-import "testing"
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/secure-io/sio-go"
+)
+
+// encryptStream returns the header followed by the plaintext
+// encrypted by the EncWriter returned by encryptWriter.
+func encryptStream(t *testing.T, header []byte, encryptWriter func(io.Writer) *sio.EncWriter, plaintext []byte) []byte {
+	var ciphertext bytes.Buffer
+	ciphertext.Write(header)
+	w := encryptWriter(&ciphertext)
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("Failed to encrypt plaintext: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close EncWriter: %v", err)
+	}
+	return ciphertext.Bytes()
+}
+
+// streamDecrypter decrypts a data stream with
+// the nonce and header of its encrypting stream.
+type streamDecrypter interface {
+	DecryptReader(r io.Reader) *sio.DecReader
+}
+
+// decryptStream reads the header of the ciphertext using open
+// and returns the decrypted data stream.
+func decryptStream[S streamDecrypter](ciphertext []byte, open func(io.Reader) (S, error)) ([]byte, error) {
+	r := bytes.NewReader(ciphertext)
+	stream, err := open(r)
+	if err != nil {
+		return nil, err
+	}
+	var plaintext bytes.Buffer
+	if _, err = stream.DecryptReader(r).WriteTo(&plaintext); err != nil {
+		return nil, err
+	}
+	return plaintext.Bytes(), nil
+}
 
 func TestRandom(t *testing.T) {
 	b, err := Random(0)
@@ -0,0 +1,148 @@
+// Copyright (c) 2020 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sioutil
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// x25519StanzaType is the stanza type of X25519 recipients.
+const x25519StanzaType = "X25519"
+
+// x25519Label is the HKDF info used to derive the
+// key that wraps the file key.
+const x25519Label = "sio: X25519 file key wrapping"
+
+// An X25519Recipient wraps the file key of an envelope
+// for the owner of an X25519 public key.
+//
+// It generates an ephemeral X25519 key pair for each file
+// key, derives a wrapping key from the shared secret using
+// HKDF-SHA-256 and encrypts the file key with
+// ChaCha20-Poly1305.
+type X25519Recipient struct {
+	publicKey *ecdh.PublicKey
+}
+
+var _ Recipient = (*X25519Recipient)(nil)
+
+// NewX25519Recipient returns a new X25519Recipient
+// from a 32 byte X25519 public key.
+func NewX25519Recipient(publicKey []byte) (*X25519Recipient, error) {
+	key, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, errors.New("sioutil: invalid X25519 public key")
+	}
+	return &X25519Recipient{publicKey: key}, nil
+}
+
+// Bytes returns the 32 byte X25519 public key.
+func (r *X25519Recipient) Bytes() []byte { return r.publicKey.Bytes() }
+
+// Wrap wraps the file key for the recipient.
+func (r *X25519Recipient) Wrap(fileKey []byte) (*Stanza, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := ephemeral.ECDH(r.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := x25519WrappingKey(sharedSecret, ephemeral.PublicKey().Bytes(), r.publicKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	body := ephemeral.PublicKey().Bytes()
+	body = aead.Seal(body, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+	return &Stanza{Type: x25519StanzaType, Body: body}, nil
+}
+
+// An X25519Identity unwraps file keys that have
+// been wrapped for its X25519Recipient.
+type X25519Identity struct {
+	privateKey *ecdh.PrivateKey
+}
+
+var _ Identity = (*X25519Identity)(nil)
+
+// GenerateX25519Identity returns a new random X25519Identity.
+func GenerateX25519Identity() (*X25519Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{privateKey: key}, nil
+}
+
+// NewX25519Identity returns a new X25519Identity
+// from a 32 byte X25519 private key.
+func NewX25519Identity(privateKey []byte) (*X25519Identity, error) {
+	key, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, errors.New("sioutil: invalid X25519 private key")
+	}
+	return &X25519Identity{privateKey: key}, nil
+}
+
+// Bytes returns the 32 byte X25519 private key.
+func (i *X25519Identity) Bytes() []byte { return i.privateKey.Bytes() }
+
+// Recipient returns the X25519Recipient of the identity.
+func (i *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{publicKey: i.privateKey.PublicKey()}
+}
+
+// Unwrap unwraps the file key if the stanza has been
+// created for the identity. Otherwise, it returns
+// ErrIncorrectIdentity.
+func (i *X25519Identity) Unwrap(stanza *Stanza) ([]byte, error) {
+	const bodySize = 32 + FileKeySize + chacha20poly1305.Overhead
+	if stanza.Type != x25519StanzaType {
+		return nil, ErrIncorrectIdentity
+	}
+	if len(stanza.Body) != bodySize {
+		return nil, errors.New("sioutil: invalid X25519 stanza")
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(stanza.Body[:32])
+	if err != nil {
+		return nil, errors.New("sioutil: invalid X25519 stanza")
+	}
+	sharedSecret, err := i.privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, errors.New("sioutil: invalid X25519 stanza")
+	}
+	aead, err := x25519WrappingKey(sharedSecret, ephemeral.Bytes(), i.privateKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), stanza.Body[32:], nil)
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+	return fileKey, nil
+}
+
+// x25519WrappingKey returns the AEAD that wraps the file key
+// derived from the shared secret and both public keys.
+func x25519WrappingKey(sharedSecret, ephemeral, publicKey []byte) (cipher.AEAD, error) {
+	salt := make([]byte, 0, len(ephemeral)+len(publicKey))
+	salt = append(salt, ephemeral...)
+	salt = append(salt, publicKey...)
+	key, err := hkdf.Key(sha256.New, sharedSecret, salt, x25519Label, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
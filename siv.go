@@ -0,0 +1,221 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+)
+
+const (
+	sivNonceSize = 16
+	sivSize      = aes.BlockSize
+)
+
+// aesSIV implements the cipher.AEAD interface using
+// AES-SIV as specified by RFC 5297.
+//
+// It computes the synthetic IV over the associated data,
+// the nonce and the plaintext - in this order - as described
+// in RFC 5297, Section 3. The synthetic IV is prepended to
+// the ciphertext.
+//
+// The nonce is 16 bytes long such that a Stream using AES-SIV
+// has a 12 byte nonce followed by its 4 byte fragment counter.
+// However, AES-SIV does not depend on unique nonces. Repeating
+// a nonce for the same key only reveals whether the same
+// fragment has been encrypted under the same nonce, associated
+// data and sequence number.
+type aesSIV struct {
+	mac  cmac
+	ctr  cipher.Block
+	zero [sivSize]byte // CMAC(0¹²⁸)
+}
+
+func newAESSIV(key []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	default:
+		return nil, aes.KeySizeError(len(key))
+	case 256 / 8, 384 / 8, 512 / 8:
+	}
+
+	// RFC 5297 uses the first half of the key for
+	// S2V and the second half for CTR encryption.
+	macBlock, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	ctrBlock, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+	c := &aesSIV{
+		mac: newCMAC(macBlock),
+		ctr: ctrBlock,
+	}
+	c.zero = c.mac.sum(make([]byte, sivSize), nil)
+	return c, nil
+}
+
+func (c *aesSIV) NonceSize() int { return sivNonceSize }
+
+func (c *aesSIV) Overhead() int { return sivSize }
+
+func (c *aesSIV) Seal(dst, nonce, plaintext, associatedData []byte) []byte {
+	if len(nonce) != sivNonceSize {
+		panic("sio: incorrect nonce length given to AES-SIV")
+	}
+	ret, out := sliceForAppend(dst, sivSize+len(plaintext))
+	if inexactOverlap(out, plaintext) {
+		panic("sio: invalid buffer overlap")
+	}
+
+	// The plaintext may alias the output. Therefore, we compute
+	// the SIV before moving the plaintext behind the SIV and
+	// encrypt the ciphertext in-place.
+	v := c.s2v(associatedData, nonce, plaintext)
+	copy(out[sivSize:], plaintext)
+	c.xorKeyStream(out[sivSize:], &v, out[sivSize:])
+	copy(out, v[:])
+	return ret
+}
+
+func (c *aesSIV) Open(dst, nonce, ciphertext, associatedData []byte) ([]byte, error) {
+	if len(nonce) != sivNonceSize {
+		panic("sio: incorrect nonce length given to AES-SIV")
+	}
+	if len(ciphertext) < sivSize {
+		return nil, errOpen
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext)-sivSize)
+	if inexactOverlap(out, ciphertext) {
+		panic("sio: invalid buffer overlap")
+	}
+
+	var v [sivSize]byte
+	copy(v[:], ciphertext)
+	ciphertext = ciphertext[sivSize:]
+	inPlace := anyOverlap(out, ciphertext)
+	if inPlace {
+		// The SIV precedes the ciphertext. Hence, when decrypting
+		// in-place, we decrypt the ciphertext first and then move
+		// the plaintext to the front.
+		c.xorKeyStream(ciphertext, &v, ciphertext)
+		copy(out, ciphertext)
+	} else {
+		c.xorKeyStream(out, &v, ciphertext)
+	}
+
+	expected := c.s2v(associatedData, nonce, out)
+	if subtle.ConstantTimeCompare(expected[:], v[:]) != 1 {
+		// When decrypting in-place, the last sivSize bytes
+		// of the plaintext remain behind out. Hence, we have
+		// to clear the decrypted ciphertext as well.
+		clear(out)
+		if inPlace {
+			clear(ciphertext)
+		}
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+// xorKeyStream en/decrypts src using AES-CTR with the
+// synthetic IV v as initial counter block. As specified
+// by RFC 5297, the 31st and 63rd bit (from the right)
+// of the IV are cleared.
+func (c *aesSIV) xorKeyStream(dst []byte, v *[sivSize]byte, src []byte) {
+	q := *v
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	cipher.NewCTR(c.ctr, q[:]).XORKeyStream(dst, src)
+}
+
+// s2v implements the S2V construction as specified by
+// RFC 5297, Section 2.4. The last string must be the
+// plaintext.
+func (c *aesSIV) s2v(components ...[]byte) [sivSize]byte {
+	d := c.zero
+	for _, s := range components[:len(components)-1] {
+		dbl(&d)
+		t := c.mac.sum(s, nil)
+		subtle.XORBytes(d[:], d[:], t[:])
+	}
+
+	plaintext := components[len(components)-1]
+	if len(plaintext) >= sivSize {
+		return c.mac.sum(plaintext, &d) // CMAC(plaintext xorend D)
+	}
+
+	dbl(&d)
+	var t [sivSize]byte
+	copy(t[:], plaintext)
+	t[len(plaintext)] = 0x80
+	subtle.XORBytes(t[:], t[:], d[:])
+	return c.mac.sum(t[:], nil)
+}
+
+// cmac implements AES-CMAC as specified by RFC 4493.
+type cmac struct {
+	block  cipher.Block
+	k1, k2 [aes.BlockSize]byte
+}
+
+func newCMAC(block cipher.Block) cmac {
+	c := cmac{block: block}
+	block.Encrypt(c.k1[:], c.k1[:])
+	dbl(&c.k1)
+	c.k2 = c.k1
+	dbl(&c.k2)
+	return c
+}
+
+// sum returns the CMAC of msg. If xorend is not nil,
+// it is XOR-ed into the last 16 bytes of msg before
+// computing the CMAC - without modifying msg. Then,
+// msg must be at least 16 bytes long.
+func (c *cmac) sum(msg []byte, xorend *[aes.BlockSize]byte) [aes.BlockSize]byte {
+	var x, b [aes.BlockSize]byte
+	tail := len(msg) - aes.BlockSize
+	for off := 0; ; off += aes.BlockSize {
+		end := min(off+aes.BlockSize, len(msg))
+		clear(b[:])
+		copy(b[:], msg[off:end])
+		if xorend != nil && end > tail {
+			for i := max(off, tail); i < end; i++ {
+				b[i-off] ^= xorend[i-tail]
+			}
+		}
+		if end < len(msg) {
+			subtle.XORBytes(x[:], x[:], b[:])
+			c.block.Encrypt(x[:], x[:])
+			continue
+		}
+
+		if n := end - off; n == aes.BlockSize {
+			subtle.XORBytes(b[:], b[:], c.k1[:])
+		} else {
+			b[n] = 0x80
+			subtle.XORBytes(b[:], b[:], c.k2[:])
+		}
+		subtle.XORBytes(x[:], x[:], b[:])
+		c.block.Encrypt(x[:], x[:])
+		return x
+	}
+}
+
+// dbl multiplies v by x in GF(2¹²⁸) as specified
+// by RFC 5297, Section 2.3.
+func dbl(v *[aes.BlockSize]byte) {
+	var carry byte
+	for i := len(v) - 1; i >= 0; i-- {
+		b := v[i] >> 7
+		v[i] = v[i]<<1 | carry
+		carry = b
+	}
+	v[len(v)-1] ^= 0x87 & (0 - carry)
+}
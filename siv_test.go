@@ -0,0 +1,158 @@
+// Copyright (c) 2019 Andreas Auernhammer. All rights reserved.
+// Use of this source code is governed by a license that can be
+// found in the LICENSE file.
+
+package sio
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	mrand "math/rand"
+	"testing"
+)
+
+var cmacTests = []struct {
+	Key, Message, Tag string
+}{
+	{ // RFC 4493, Example 1
+		Key:     "2b7e151628aed2a6abf7158809cf4f3c",
+		Message: "",
+		Tag:     "bb1d6929e95937287fa37d129b756746",
+	},
+	{ // RFC 4493, Example 2
+		Key:     "2b7e151628aed2a6abf7158809cf4f3c",
+		Message: "6bc1bee22e409f96e93d7e117393172a",
+		Tag:     "070a16b46b4d4144f79bdd9dd04a287c",
+	},
+	{ // RFC 4493, Example 3
+		Key:     "2b7e151628aed2a6abf7158809cf4f3c",
+		Message: "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411",
+		Tag:     "dfa66747de9ae63030ca32611497c827",
+	},
+	{ // RFC 4493, Example 4
+		Key:     "2b7e151628aed2a6abf7158809cf4f3c",
+		Message: "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710",
+		Tag:     "51f0bebf7e3b9d92fc49741779363cfe",
+	},
+}
+
+func TestCMAC(t *testing.T) {
+	for i, test := range cmacTests {
+		key, _ := hex.DecodeString(test.Key)
+		message, _ := hex.DecodeString(test.Message)
+		tag, _ := hex.DecodeString(test.Tag)
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create AES cipher: %v", i, err)
+		}
+		mac := newCMAC(block)
+		if sum := mac.sum(message, nil); !bytes.Equal(sum[:], tag) {
+			t.Fatalf("Test %d: got %x - want %x", i, sum, tag)
+		}
+	}
+}
+
+var sivTests = []struct {
+	Key            string
+	AssociatedData []string
+	Plaintext      string
+	Ciphertext     string
+}{
+	{ // RFC 5297, Appendix A.1
+		Key:            "fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+		AssociatedData: []string{"101112131415161718191a1b1c1d1e1f2021222324252627"},
+		Plaintext:      "112233445566778899aabbccddee",
+		Ciphertext:     "85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c",
+	},
+	{ // RFC 5297, Appendix A.2
+		Key: "7f7e7d7c7b7a79787776757473727170404142434445464748494a4b4c4d4e4f",
+		AssociatedData: []string{
+			"00112233445566778899aabbccddeeffdeaddadadeaddadaffeeddccbbaa99887766554433221100",
+			"102030405060708090a0",
+			"09f911029d74e35bd84156c5635688c0",
+		},
+		Plaintext:  "7468697320697320736f6d6520706c61696e7465787420746f20656e6372797074207573696e67205349562d414553",
+		Ciphertext: "7bdb6e3b432667eb06f4d14bff2fbd0fcb900f2fddbe404326601965c889bf17dba77ceb094fa663b7a3f748ba8af829ea64ad544a272e9c485b62a3fd5c0d",
+	},
+}
+
+func TestSIV(t *testing.T) {
+	for i, test := range sivTests {
+		key, _ := hex.DecodeString(test.Key)
+		plaintext, _ := hex.DecodeString(test.Plaintext)
+		ciphertext, _ := hex.DecodeString(test.Ciphertext)
+
+		var components [][]byte
+		for _, s := range test.AssociatedData {
+			ad, _ := hex.DecodeString(s)
+			components = append(components, ad)
+		}
+		components = append(components, plaintext)
+
+		aead, err := newAESSIV(key)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create AES-SIV: %v", i, err)
+		}
+		c := aead.(*aesSIV)
+
+		v := c.s2v(components...)
+		if !bytes.Equal(v[:], ciphertext[:sivSize]) {
+			t.Fatalf("Test %d: SIV mismatch: got %x - want %x", i, v, ciphertext[:sivSize])
+		}
+		encrypted := make([]byte, len(plaintext))
+		c.xorKeyStream(encrypted, &v, plaintext)
+		if !bytes.Equal(encrypted, ciphertext[sivSize:]) {
+			t.Fatalf("Test %d: ciphertext mismatch: got %x - want %x", i, encrypted, ciphertext[sivSize:])
+		}
+	}
+}
+
+func TestSIVSealOpen(t *testing.T) {
+	for i := 0; i < 64; i++ {
+		key := random([]int{32, 48, 64}[i%3])
+		nonce := random(sivNonceSize)
+		plaintext, associatedData := randomN(1024), randomN(64)
+
+		c, err := newAESSIV(key)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create AES-SIV: %v", i, err)
+		}
+		ciphertext := c.Seal(nil, nonce, plaintext, associatedData)
+		if !bytes.Equal(ciphertext, c.Seal(nil, nonce, plaintext, associatedData)) {
+			t.Fatalf("Test %d: AES-SIV is not deterministic", i)
+		}
+
+		// Seal and Open must work in-place.
+		buffer := make([]byte, len(plaintext), len(plaintext)+c.Overhead())
+		copy(buffer, plaintext)
+		if sealed := c.Seal(buffer[:0], nonce, buffer, associatedData); !bytes.Equal(sealed, ciphertext) {
+			t.Fatalf("Test %d: in-place Seal does not match ciphertext", i)
+		}
+		opened, err := c.Open(buffer[:0], nonce, buffer[:len(ciphertext)], associatedData)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to open ciphertext in-place: %v", i, err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Test %d: plaintext does not match original plaintext", i)
+		}
+
+		ciphertext[mrand.Intn(len(ciphertext))] ^= 1
+		if _, err = c.Open(nil, nonce, ciphertext, associatedData); err == nil {
+			t.Fatalf("Test %d: modified ciphertext is authentic", i)
+		}
+
+		// A failed in-place Open must not leave any plaintext behind.
+		buffer = buffer[:len(ciphertext)]
+		copy(buffer, ciphertext)
+		if _, err = c.Open(buffer[:0], nonce, buffer, associatedData); err == nil {
+			t.Fatalf("Test %d: modified ciphertext is authentic", i)
+		}
+		for j := range buffer {
+			if buffer[j] != 0 && buffer[j] != ciphertext[j] {
+				t.Fatalf("Test %d: failed in-place Open does not clear the plaintext at offset %d", i, j)
+			}
+		}
+	}
+}
@@ -47,6 +47,45 @@ func TestVectorWrite(t *testing.T) {
 	}
 }
 
+func TestVectorWriteBlockNum(t *testing.T) {
+	ciphertext := bytes.NewBuffer(nil)
+	plaintext := bytes.NewBuffer(nil)
+
+	for i, test := range BlockNumTestVectors {
+		ciphertext.Reset()
+		plaintext.Reset()
+
+		stream, err := test.Algorithm.streamWithBufSize(test.Key, test.BufSize)
+		if err != nil {
+			t.Fatalf("Test %d: Failed to create new Stream: %v", i, err)
+		}
+
+		dw := stream.DecryptWriter(plaintext, test.Nonce, test.AssociatedData)
+		dw.Reset(test.BlockNum)
+		if _, err = dw.Write(test.Ciphertext); err != nil {
+			t.Fatalf("Test %d: Failed to decrypt ciphertext: %v", i, err)
+		}
+		if err = dw.Close(); err != nil {
+			t.Fatalf("Test: %d: Failed to close DecWriter: %v", i, err)
+		}
+		if !bytes.Equal(plaintext.Bytes(), test.Plaintext) {
+			t.Fatalf("Test %d: plaintext does not match original plaintext", i)
+		}
+
+		ew := stream.EncryptWriter(ciphertext, test.Nonce, test.AssociatedData)
+		ew.Reset(test.BlockNum)
+		if _, err = ew.Write(test.Plaintext); err != nil {
+			t.Fatalf("Test: %d: Failed to encrypt plaintext: %v", i, err)
+		}
+		if err = ew.Close(); err != nil {
+			t.Fatalf("Test: %d: Failed to close EncWriter: %v", i, err)
+		}
+		if !bytes.Equal(ciphertext.Bytes(), test.Ciphertext) {
+			t.Fatalf("Test %d: ciphertext does not match original plaintext", i)
+		}
+	}
+}
+
 func TestVectorWriteByte(t *testing.T) {
 	ciphertext := bytes.NewBuffer(nil)
 	plaintext := bytes.NewBuffer(nil)